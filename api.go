@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiFields are the canonical field names of an entry in API responses.
+var apiFields = []string{"id", "name", "count", "pinned", "excuse", "added_at"}
+
+func parseFieldMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("invalid field mapping %q, want canonical=renamed", pair)
+		}
+		known := false
+		for _, f := range apiFields {
+			known = known || f == from
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown api field %q", from)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
+
+type apiEntry struct {
+	ID      uint64    `json:"id"`
+	Name    string    `json:"name"`
+	Count   int       `json:"count"`
+	Pinned  bool      `json:"pinned"`
+	Excuse  string    `json:"excuse"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func newAPIEntry(e entry) apiEntry {
+	return apiEntry{e.ID, e.Name, e.count(), e.Pinned, e.Excuse, e.addedAt()}
+}
+
+// fields returns the entry keyed by field name, renamed per the mapping.
+func (e apiEntry) fields(rename map[string]string) map[string]interface{} {
+	values := []interface{}{e.ID, e.Name, e.Count, e.Pinned, e.Excuse, e.AddedAt}
+	m := make(map[string]interface{}, len(apiFields))
+	for i, f := range apiFields {
+		if to, ok := rename[f]; ok {
+			f = to
+		}
+		m[f] = values[i]
+	}
+	return m
+}
+
+func (s *server) handleAPIList(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeEntries(w, req)
+}
+
+// writeEntries responds with the entries of the backlog named by the team
+// and channel query parameters.
+func (s *server) writeEntries(w http.ResponseWriter, req *http.Request) {
+	entries, err := s.store.List(req.Context(), s.backlogKey(req.FormValue("team"), req.FormValue("channel")))
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("api list failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	items := make([]interface{}, len(entries))
+	for i, e := range entries {
+		items[i] = s.apiValue(e)
+	}
+	writeJSON(w, req, http.StatusOK, items)
+}
+
+// apiValue is the entry as the API encodes it, renamed per -api-field-map.
+func (s *server) apiValue(e entry) interface{} {
+	if len(s.apiFieldMap) == 0 {
+		return newAPIEntry(e)
+	}
+	return newAPIEntry(e).fields(s.apiFieldMap)
+}
+
+func writeJSON(w http.ResponseWriter, req *http.Request, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger(req.Context()).Error("api response failed", "path", req.URL.Path, "err", err)
+	}
+}
+
+type apiAddRequest struct {
+	Name    string `json:"name"`
+	Team    string `json:"team,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// handleEntries serves GET and POST on /api/v1/entries.
+func (s *server) handleEntries(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.writeEntries(w, req)
+	case http.MethodPost:
+		s.handleAPIAdd(w, req)
+	default:
+		abort(w, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) handleAPIAdd(w http.ResponseWriter, req *http.Request) {
+	if s.drain.draining() {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	var r apiAddRequest
+	err := json.NewDecoder(req.Body).Decode(&r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name, userID := s.parseUser(req.Context(), r.Team, strings.TrimSpace(r.Name))
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if s.reserved[strings.ToLower(name)] || s.reserved[strings.ToLower(userID)] {
+		http.Error(w, "name is reserved", http.StatusUnprocessableEntity)
+		return
+	}
+	added, err := s.store.Add(req.Context(), s.backlogKey(r.Team, r.Channel), reporter{}, addDetails{}, name)
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("api add failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, req, http.StatusCreated, s.apiValue(added[0]))
+}
+
+// handleEntry serves DELETE on /api/v1/entries/{id}, taking one off the
+// entry's count like the del command.
+func (s *server) handleEntry(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.drain.draining() {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	if err != nil {
+		abort(w, http.StatusNotFound)
+		return
+	}
+	_, err = s.store.Del(req.Context(), s.backlogKey(req.FormValue("team"), req.FormValue("channel")), id, reporter{})
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errNotFound:
+		abort(w, http.StatusNotFound)
+	case errShuttingDown:
+		abort(w, http.StatusServiceUnavailable)
+	default:
+		logger(req.Context()).Error("api delete failed", "id", id, "err", err)
+		abort(w, http.StatusInternalServerError)
+	}
+}
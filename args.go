@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// usageError is returned by a command that was called wrongly. The reply
+// explains the problem, if there is one, followed by the command's usage.
+type usageError struct {
+	problem string
+}
+
+func (e *usageError) Error() string {
+	if e.problem == "" {
+		return "usage"
+	}
+	return "usage: " + e.problem
+}
+
+var errUsage = &usageError{}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// usages are the usage lines of the commands, keyed by name.
+var usages = map[string]string{
+	"help":         "`/icecream help`",
+	"list":         "`/icecream list [pattern] [--sort id|due]`",
+	"me":           "`/icecream me`",
+	"between":      "`/icecream between <reporter> <username>`",
+	"rivalry":      "`/icecream rivalry <username> <username>`",
+	"overdue":      "`/icecream overdue`",
+	"snooze":       "`/icecream snooze <id> <duration>` such as `3d`, or `off` to end it",
+	"show":         "`/icecream show <id>`",
+	"info":         "`/icecream info <id>`",
+	"whoadded":     "`/icecream whoadded <id>`",
+	"excuse":       "`/icecream excuse <id> <text>`",
+	"reason":       "`/icecream reason <id> <text>`",
+	"edit":         "`/icecream edit <id> <new name>`",
+	"rename":       "`/icecream rename <id> <new name>`",
+	"pin":          "`/icecream pin <id>`",
+	"unpin":        "`/icecream unpin <id>`",
+	"spotlight":    "`/icecream spotlight <id> <duration>` such as `2h`, or `off` to clear it",
+	"random":       "`/icecream random [--weighted]`",
+	"sample":       "`/icecream sample [--weighted]`",
+	"settle-round": "`/icecream settle-round`",
+	"heatmap":      "`/icecream heatmap [weeks]` with 1 to 52 weeks",
+	"summary":      "`/icecream summary`",
+	"dwell":        "`/icecream dwell`",
+	"export-md":    "`/icecream export-md`",
+	"export":       "`/icecream export`",
+	"quiet":        "`/icecream quiet <duration>` such as `2h` or `1d`",
+	"share":        "`/icecream share [duration]` such as `1h` or `7d`",
+	"diff":         "`/icecream diff <backupA> <backupB>`",
+	"fsck":         "`/icecream fsck [--repair]`",
+	"notify":       "`/icecream notify on|off`",
+	"config":       "`/icecream config digest <day> <HH:MM>|off`, `config admins [add|remove <@user>]` or `config settle [<strategy>|default]` or `config public [on|off]`",
+	"add":          "`/icecream add <username>[, <username>...] [--count <n>] [--reason <text>] [--due <when>] [because <reason>]`",
+	"add-at":       "`/icecream add-at <time> <username>` where time is like `+2d` or `2024-06-03T09:00`",
+	"scheduled":    "`/icecream scheduled`",
+	"del":          "`/icecream del <id|username>`",
+	"clear":        "`/icecream clear`",
+	"migrate-to":   "`/icecream migrate-to <#channel> [--replace] [--clear]`",
+	"pay":          "`/icecream pay <id>` or `/icecream pay <username>`",
+	"paid":         "`/icecream paid <id> [<id>...] [--dry-run]` or `/icecream paid all [--dry-run]`",
+	"amnesty":      "`/icecream amnesty [note] [--dry-run]`",
+	"history":      fmt.Sprintf("`/icecream history [count]` with a count up to %d", maxHistory),
+	"trim-history": "`/icecream trim-history`",
+	"stats":        "`/icecream stats`",
+	"undo":         "`/icecream undo`",
+}
+
+// commandOptions lists the options each command accepts and whether they
+// take a value.
+var commandOptions = map[string]map[string]bool{
+	"add":        {"count": true, "reason": true, "due": true},
+	"list":       {"sort": true},
+	"fsck":       {"repair": false},
+	"migrate-to": {"replace": false, "clear": false},
+	"paid":       {"dry-run": false},
+	"amnesty":    {"dry-run": false},
+	"random":     {"weighted": false},
+	"sample":     {"weighted": false},
+}
+
+// freeText lists the commands whose tail is free text, where words that
+// look like options are kept as they are. The text starts at the given
+// word, or after the first positional word when that is empty.
+var freeText = map[string]string{
+	"add":    "because",
+	"excuse": "",
+	"reason": "",
+	"edit":   "",
+	"rename": "",
+}
+
+func usageMessage(name, problem string) msg {
+	text := "Usage: " + usages[name]
+	if problem != "" {
+		text = problem + "\n" + text
+	}
+	return newPrivateMessage(text)
+}
+
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// closingQuotes maps the quotes that can open an argument to the one that
+// closes it. Slack turns straight quotes into curly ones on some clients.
+var closingQuotes = map[rune]rune{'"': '"', '\'': '\'', '“': '”', '‘': '’'}
+
+// tokenize splits text into words at whitespace. A word that starts with a
+// quote runs to the matching quote, so it can hold spaces, and is returned
+// in the quoted set as well. Quotes inside a word are kept, so names like
+// O'Brien need no escaping.
+func tokenize(text string) ([]string, map[string]bool, error) {
+	var words []string
+	quoted := make(map[string]bool)
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		if closing, ok := closingQuotes[runes[i]]; ok {
+			end := i + 1
+			for end < len(runes) && runes[end] != closing {
+				end++
+			}
+			if end == len(runes) {
+				return nil, nil, errUnterminatedQuote
+			}
+			words = append(words, string(runes[i+1:end]))
+			quoted[string(runes[i+1:end])] = true
+			i = end + 1
+			continue
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		words = append(words, string(runes[start:i]))
+	}
+	return words, quoted, nil
+}
+
+// parseArgs separates the options of the named command from its positional
+// words. Options are written `--name value` or `--name=value`, and `--`
+// or the start of the command's free text ends them.
+func parseArgs(name string, tokens []string) ([]string, map[string]string, error) {
+	known := commandOptions[name]
+	free, hasFree := freeText[name]
+	var words []string
+	opts := make(map[string]string)
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t == "--" {
+			words = append(words, tokens[i+1:]...)
+			break
+		}
+		if hasFree && ((free == "" && len(words) > 0) || strings.EqualFold(t, free)) {
+			words = append(words, tokens[i:]...)
+			break
+		}
+		opt, ok := strings.CutPrefix(t, "--")
+		if !ok || opt == "" {
+			words = append(words, t)
+			continue
+		}
+		opt, value, hasValue := strings.Cut(opt, "=")
+		takesValue, ok := known[opt]
+		switch {
+		case !ok:
+			return nil, nil, usageErrorf("Unknown option `--%s`.", opt)
+		case !takesValue && hasValue:
+			return nil, nil, usageErrorf("`--%s` doesn't take a value.", opt)
+		case takesValue && !hasValue:
+			if i+1 == len(tokens) {
+				return nil, nil, usageErrorf("`--%s` needs a value.", opt)
+			}
+			i++
+			value = tokens[i]
+		}
+		opts[opt] = value
+	}
+	return words, opts, nil
+}
+
+// parseText fills in the command's name, arguments and options from the
+// text typed after the slash command.
+func (cmd *command) parseText(text string) {
+	tokens, quoted, err := tokenize(text)
+	if err != nil {
+		// Fall back to plain words so the command name is still known.
+		tokens = strings.Fields(text)
+		cmd.parseErr = usageErrorf("There's an unterminated quote in there.")
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	cmd.name = tokens[0]
+	if cmd.parseErr != nil {
+		return
+	}
+	words, opts, err := parseArgs(cmd.name, tokens[1:])
+	if err != nil {
+		cmd.parseErr = err
+		return
+	}
+	cmd.words = words
+	cmd.args = strings.Join(words, " ")
+	cmd.opts = opts
+	cmd.quoted = quoted
+}
+
+// splitNames splits words further at commas, so both `alice bob` and
+// `alice, bob` name two people. A quoted word is one name, commas and
+// spaces included, as in `"Smith, Jane"`.
+func splitNames(words []string, quoted map[string]bool) []string {
+	var names []string
+	for _, w := range words {
+		if quoted[w] {
+			if w = strings.TrimSpace(w); w != "" {
+				names = append(names, w)
+			}
+			continue
+		}
+		for _, name := range strings.Split(w, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func (cmd *command) option(name string) (string, bool) {
+	v, ok := cmd.opts[name]
+	return v, ok
+}
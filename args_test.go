@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		words []string
+		opts  map[string]string
+		err   bool
+	}{
+		{"add", "bob --count 2", []string{"bob"}, map[string]string{"count": "2"}, false},
+		{"add", "bob --count=2 because he did a --force push", []string{"bob", "because", "he", "did", "a", "--force", "push"}, map[string]string{"count": "2"}, false},
+		{"add", "bob Because --reason", []string{"bob", "Because", "--reason"}, map[string]string{}, false},
+		{"add", "bob --force", nil, nil, true},
+		{"excuse", "3 the --force push was needed", []string{"3", "the", "--force", "push", "was", "needed"}, map[string]string{}, false},
+		{"reason", "3 --amend", []string{"3", "--amend"}, map[string]string{}, false},
+		{"edit", "3 --bob", []string{"3", "--bob"}, map[string]string{}, false},
+		{"excuse", "--force 3", nil, nil, true},
+		{"list", "al --sort due", []string{"al"}, map[string]string{"sort": "due"}, false},
+		{"list", "-- --sort", []string{"--sort"}, map[string]string{}, false},
+		{"fsck", "--repair=yes", nil, nil, true},
+	}
+	for _, tt := range tests {
+		tokens, _, err := tokenize(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		words, opts, err := parseArgs(tt.name, tokens)
+		var usage *usageError
+		if tt.err {
+			if !errors.As(err, &usage) {
+				t.Errorf("%s %s: err = %v, want a usage error", tt.name, tt.text, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.name, tt.text, err)
+			continue
+		}
+		if !slices.Equal(words, tt.words) || !maps.Equal(opts, tt.opts) {
+			t.Errorf("%s %s = %q %v, want %q %v", tt.name, tt.text, words, opts, tt.words, tt.opts)
+		}
+	}
+}
+
+func TestSplitNames(t *testing.T) {
+	tests := []struct {
+		text  string
+		names []string
+	}{
+		{"alice bob", []string{"alice", "bob"}},
+		{"alice,bob, carol", []string{"alice", "bob", "carol"}},
+		{`"Smith, Jane"`, []string{"Smith, Jane"}},
+		{`"Smith, Jane", bob`, []string{"Smith, Jane", "bob"}},
+		{`“Mary Ann” O'Brien,alice`, []string{"Mary Ann", "O'Brien", "alice"}},
+	}
+	for _, tt := range tests {
+		words, quoted, err := tokenize(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := splitNames(words, quoted); !slices.Equal(names, tt.names) {
+			t.Errorf("splitNames(%s) = %q, want %q", tt.text, names, tt.names)
+		}
+	}
+}
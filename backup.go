@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pnelson/icecream/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+const backupTimeFormat = "20060102T150405Z"
+
+var errBackupRunning = errors.New("a backup is already running")
+
+// backuper writes periodic snapshots to a local directory, an S3 bucket
+// or both, counting the outcomes for /metrics.
+type backuper struct {
+	db        *store
+	dir       string
+	s3        *s3Client
+	retention time.Duration
+	running   atomic.Bool
+
+	succeeded   atomic.Uint64
+	failed      atomic.Uint64
+	lastSuccess atomic.Int64
+	lastSize    atomic.Int64
+}
+
+func (b *backuper) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			start := time.Now()
+			name, size, err := b.backup()
+			if err == errBackupRunning {
+				slog.Warn("skipping scheduled backup", "err", err)
+				continue
+			}
+			if err != nil {
+				b.failed.Add(1)
+				slog.Error("backup failed", "err", err)
+				continue
+			}
+			b.succeeded.Add(1)
+			b.lastSuccess.Store(time.Now().Unix())
+			b.lastSize.Store(size)
+			slog.Info("backup written", "name", name, "size", size, "duration", time.Since(start))
+			err = b.prune(time.Now())
+			if err != nil {
+				slog.Error("backup prune failed", "err", err)
+			}
+		}
+	}
+}
+
+func backupName(t time.Time) string {
+	return "icecream-" + t.UTC().Format(backupTimeFormat) + ".db"
+}
+
+// backup writes a consistent snapshot of the database to a temporary file,
+// then uploads it and gives it its timestamped name in the backup
+// directory, so a partial backup never carries the final name.
+func (b *backuper) backup() (string, int64, error) {
+	if !b.running.CompareAndSwap(false, true) {
+		return "", 0, errBackupRunning
+	}
+	defer b.running.Store(false)
+	f, err := os.CreateTemp(b.dir, ".icecream-*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var size int64
+	err = b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		size, err = tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	name := backupName(time.Now())
+	if b.s3 != nil {
+		_, err = f.Seek(0, io.SeekStart)
+		if err == nil {
+			err = b.s3.put(name, f)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		slog.Info("backup uploaded", "url", b.s3.url(name))
+	}
+	if b.dir == "" {
+		return name, size, nil
+	}
+	err = f.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	return name, size, os.Rename(f.Name(), filepath.Join(b.dir, name))
+}
+
+// expired reports whether the backup with the given file name is older
+// than the retention period. Names that aren't backups never expire.
+func (b *backuper) expired(name string, now time.Time) bool {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, "icecream-"), ".db")
+	t, err := time.Parse(backupTimeFormat, stamp)
+	return err == nil && now.Sub(t) >= b.retention
+}
+
+func (b *backuper) prune(now time.Time) error {
+	if b.retention <= 0 {
+		return nil
+	}
+	if b.dir != "" {
+		names, err := filepath.Glob(filepath.Join(b.dir, "icecream-*.db"))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !b.expired(filepath.Base(name), now) {
+				continue
+			}
+			err = os.Remove(name)
+			if err != nil {
+				return err
+			}
+			slog.Info("backup pruned", "path", name)
+		}
+	}
+	if b.s3 != nil {
+		keys, err := b.s3.list("icecream-")
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if strings.Contains(key, "/") || !b.expired(key, now) {
+				continue
+			}
+			err = b.s3.remove(key)
+			if err != nil {
+				return err
+			}
+			slog.Info("backup pruned", "url", b.s3.url(key))
+		}
+	}
+	return nil
+}
+
+func (b *backuper) writeMetrics(w io.Writer) {
+	writeCounter(w, "icecream_backups_total", "Periodic backups written.", b.succeeded.Load())
+	writeCounter(w, "icecream_backup_failures_total", "Periodic backups that failed.", b.failed.Load())
+	writeGauge(w, "icecream_backup_last_success_timestamp_seconds", "Unix time of the last periodic backup, 0 if none yet.", b.lastSuccess.Load())
+	writeGauge(w, "icecream_backup_last_size_bytes", "Size of the last periodic backup.", b.lastSize.Load())
+}
+
+// handleBackup serves GET /api/v1/backup, streaming a consistent snapshot
+// of the database from a read transaction. Writers carry on while it
+// streams, but compaction waits for it to finish.
+func (s *server) handleBackup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	start := time.Now()
+	var size int64
+	err := s.bolt.DB.View(req.Context(), func(tx *bolt.Tx) error {
+		name := backupName(start)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+		var err error
+		size, err = tx.WriteTo(w)
+		return err
+	})
+	if errors.Is(err, storage.ErrClosed) {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		// The status line has gone out with the first bytes, so the
+		// client only sees a short body.
+		logger(req.Context()).Error("backup failed", "size", size, "err", err)
+		return
+	}
+	logger(req.Context()).Info("backup streamed", "size", size, "duration", time.Since(start))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxListSections keeps list messages under Slack's limit of 50 blocks.
+const maxListSections = 40
+
+type block struct {
+	Type     string      `json:"type"`
+	Text     *blockText  `json:"text,omitempty"`
+	Elements []blockText `json:"elements,omitempty"`
+}
+
+type blockText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func headerBlock(text string) block {
+	return block{Type: "header", Text: &blockText{"plain_text", text}}
+}
+
+func dividerBlock() block {
+	return block{Type: "divider"}
+}
+
+func sectionBlock(text string) block {
+	return block{Type: "section", Text: &blockText{"mrkdwn", text}}
+}
+
+func contextBlock(text string) block {
+	return block{Type: "context", Elements: []blockText{{"mrkdwn", text}}}
+}
+
+// withBlocks attaches blocks to the message, which Slack shows instead of
+// the text. Public messages repeat the footer as a context block since
+// the text is no longer visible.
+func withBlocks(m msg, blocks []block) msg {
+	if m.Type == "in_channel" && publicFooter != "" {
+		blocks = append(blocks, contextBlock(publicFooter))
+	}
+	m.Blocks = blocks
+	return m
+}
+
+// listBlocks renders the backlog with a section per entry.
+func listBlocks(entries []entry, now time.Time) []block {
+	blocks := []block{headerBlock(fmt.Sprintf("🍦 Ice cream backlog (%d)", len(entries))), dividerBlock()}
+	for i, e := range entries {
+		if i == maxListSections {
+			blocks = append(blocks, contextBlock(fmt.Sprintf("…and %d more", len(entries)-i)))
+			break
+		}
+		title := fmt.Sprintf("*%d.* %s", e.ID, e.Name)
+		if e.count() > 1 {
+			title += fmt.Sprintf("  *×%d*", e.count())
+		}
+		if e.Pinned {
+			title = "📌 " + title
+		}
+		if e.overdue(now) {
+			title = "⚠️ " + title
+		}
+		var details []string
+		if e.Reason != "" {
+			details = append(details, fmt.Sprintf("because _%s_", escape(e.Reason)))
+		}
+		if add := e.firstAdd(); !add.Time.IsZero() {
+			details = append(details, fmt.Sprintf("added by %s %s ago", add.By, humanize(now.Sub(add.Time))))
+		}
+		if left := e.SpotlightUntil.Sub(now); left > 0 {
+			details = append(details, fmt.Sprintf("⏳ %s left to buy", humanize(left)))
+		}
+		if due := e.dueLabel(now); due != "" {
+			details = append(details, due)
+		}
+		if e.snoozed(now) {
+			details = append(details, fmt.Sprintf("😴 snoozed for %s", humanize(e.SnoozedUntil.Sub(now))))
+		}
+		text := title
+		if len(details) > 0 {
+			text += "\n" + strings.Join(details, " · ")
+		}
+		blocks = append(blocks, sectionBlock(text))
+	}
+	return append(blocks, dividerBlock())
+}
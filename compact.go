@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var errCompactionRunning = errors.New("a compaction is already running")
+
+type compactor struct {
+	db        *store
+	threshold float64
+	cooldown  time.Duration
+	mu        sync.Mutex
+	last      time.Time
+}
+
+func (c *compactor) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if now.Sub(c.last) < c.cooldown {
+				continue
+			}
+			ratio, size, err := c.db.FreeRatio()
+			if err != nil {
+				slog.Error("free page check failed", "err", err)
+				continue
+			}
+			if ratio < c.threshold {
+				continue
+			}
+			slog.Info("free page ratio over threshold, compacting", "ratio", ratio, "size", size)
+			start := time.Now()
+			after, err := c.compact()
+			if err != nil {
+				slog.Error("compaction failed", "err", err)
+				continue
+			}
+			slog.Info("compaction finished", "before", size, "after", after, "reclaimed", size-after, "duration", time.Since(start))
+		}
+	}
+}
+
+func (c *compactor) compact() (int64, error) {
+	if !c.mu.TryLock() {
+		return 0, errCompactionRunning
+	}
+	defer c.mu.Unlock()
+	c.last = time.Now()
+	return c.db.Compact()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentRequests fires adds, retried adds, lists, deletes and
+// reads of the metrics and summary image at once, for the race detector
+// to check the caches, queues and counters they share.
+func TestConcurrentRequests(t *testing.T) {
+	const n = 40
+	ts := newTestServer(t, func(s *server) {
+		s.asyncSlow = true
+		s.responder = newResponder(s, 4)
+		s.batcher = newAddBatcher(20 * time.Millisecond)
+		s.summaryImage = true
+		s.shareSecret, _ = newSecret("share", "")
+	})
+	hook, _ := newResponseHook(t)
+	summary := ts.URL + "/summary.png?" + ts.s.shareQuery("", "", time.Hour).Encode()
+
+	get := func(t *testing.T, url string) {
+		resp, err := ts.Client().Get(url)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d", url, resp.StatusCode)
+		}
+	}
+	run := func(fns ...func(i int)) {
+		var wg sync.WaitGroup
+		for i := range n {
+			for _, fn := range fns {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					fn(i)
+				}()
+			}
+		}
+		wg.Wait()
+	}
+	add := func(retry string) func(i int) {
+		return func(i int) {
+			form := slashForm("U"+strconv.Itoa(i%3), fmt.Sprintf("add p%d", i))
+			form.Set("response_url", hook)
+			form.Set("trigger_id", fmt.Sprintf("trigger-%d", i))
+			var header http.Header
+			if retry != "" {
+				header = http.Header{"X-Slack-Retry-Num": {retry}}
+			}
+			code, _ := ts.post(t, form, header)
+			if code != http.StatusOK {
+				t.Errorf("add p%d: status %d", i, code)
+			}
+		}
+	}
+	list := func(i int) {
+		form := slashForm("U1", "list")
+		form.Set("response_url", hook)
+		ts.post(t, form, nil)
+	}
+	run(add(""), add("1"), list,
+		func(int) { get(t, ts.URL+"/metrics") },
+		func(int) { get(t, summary) },
+	)
+
+	entries := ts.entries(t)
+	if len(entries) != n {
+		t.Fatalf("%d entries after %d adds", len(entries), n)
+	}
+	for _, e := range entries {
+		if e.count() != 1 {
+			t.Errorf("%s has count %d, a retried add ran twice", e.Name, e.count())
+		}
+	}
+
+	run(func(i int) {
+		m := ts.slash(t, "U1", fmt.Sprintf("del %d", entries[i].ID))
+		wantText(t, m, entries[i].Name)
+	}, list, func(int) { get(t, summary) })
+	ts.s.responder.stop(5 * time.Second)
+	if entries := ts.entries(t); len(entries) != 0 {
+		t.Errorf("%d entries left after deleting them all", len(entries))
+	}
+}
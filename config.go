@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadConfig sets flags from a TOML file whose keys are flag names, such as
+//
+//	addr = ":8080"
+//	admins = ["U123", "U456"]
+//	per-channel = true
+//
+// Flags already given are left alone.
+func loadConfig(path string, given map[string]bool) error {
+	var values map[string]interface{}
+	_, err := toml.DecodeFile(path, &values)
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		s, err := configValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		err = flag.Set(name, s)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// givenFlags returns the names of the flags set on the command line.
+func givenFlags() map[string]bool {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// envName is the environment variable for a flag, ICECREAM_DB_PATH for
+// -db-path.
+func envName(flagName string) string {
+	return "ICECREAM_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnv sets flags that weren't given from their environment variables
+// and adds them to given.
+func loadEnv(given map[string]bool) error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		err = flag.Set(f.Name, v)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), err)
+			return
+		}
+		given[f.Name] = true
+	})
+	return err
+}
+
+// configValue formats a TOML value as a flag value. Arrays become the
+// comma separated lists the flags take.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string, bool, int64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// csvHeader names the columns of the CSV export.
+var csvHeader = []string{"id", "name", "count", "reason", "excuse", "added_by", "added_at", "updated_at", "due", "pinned"}
+
+// maxCSVMessage keeps the export command's reply under Slack's limit on
+// message length.
+const maxCSVMessage = 35000
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formulaStart holds the characters that make a spreadsheet read a cell
+// as a formula.
+const formulaStart = "=+-@\t\r"
+
+// csvCell prefixes text a spreadsheet would run as a formula with a quote
+// so it is shown as text instead.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune(formulaStart, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvUncell drops the quote csvCell added, for imports of an export.
+func csvUncell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(formulaStart, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// writeCSV writes the entries with a header row.
+func writeCSV(w io.Writer, entries []entry) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		add := e.firstAdd()
+		var updated time.Time
+		if len(e.Events) > 0 {
+			updated = e.Events[len(e.Events)-1].Time
+		}
+		var by string
+		if add.By != (reporter{}) {
+			by = add.By.String()
+		}
+		err = cw.Write([]string{
+			fmt.Sprint(e.ID),
+			csvCell(e.Name),
+			fmt.Sprint(e.count()),
+			csvCell(e.Reason),
+			csvCell(e.Excuse),
+			csvCell(by),
+			csvTime(add.Time),
+			csvTime(updated),
+			csvTime(e.Due),
+			fmt.Sprint(e.Pinned),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *server) exportCSV(cmd *command) (msg, error) {
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	if len(entries) == 0 {
+		return newPrivateMessage("The icecream backlog is empty, there is nothing to export."), nil
+	}
+	var sb strings.Builder
+	err = writeCSV(&sb, entries)
+	if err != nil {
+		return msg{}, err
+	}
+	if sb.Len() > maxCSVMessage {
+		return newPrivateMessage("The backlog is too big to export here, use `GET /api/v1/export.csv` instead."), nil
+	}
+	return newPrivateMessage("```\n" + sb.String() + "```"), nil
+}
+
+// handleExportCSV serves GET /api/v1/export.csv for the backlog named by
+// the team and channel query parameters.
+func (s *server) handleExportCSV(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := s.store.List(req.Context(), s.backlogKey(req.FormValue("team"), req.FormValue("channel")))
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("csv export failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="icecream.csv"`)
+	err = writeCSV(w, entries)
+	if err != nil {
+		logger(req.Context()).Error("csv export failed", "err", err)
+	}
+}
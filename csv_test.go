@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSVFormulas(t *testing.T) {
+	names := []string{"=HYPERLINK(\"http://example.com\")", "+1", "-1", "@SUM(A1)", "alice", "o'brien"}
+	var entries []entry
+	for i, name := range names {
+		entries = append(entries, entry{ID: uint64(i + 1), Name: name, Count: 1, Reason: "=1+1", Excuse: "-" + name})
+	}
+	var sb strings.Builder
+	err := writeCSV(&sb, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(sb.String()), "\n")[1:] {
+		for _, cell := range strings.Split(line, ",") {
+			cell = strings.TrimPrefix(cell, `"`)
+			if cell != "" && strings.ContainsRune(formulaStart, rune(cell[0])) {
+				t.Errorf("cell %q of %q starts a formula", cell, line)
+			}
+		}
+	}
+
+	rows, errs, err := parseImportCSV(strings.NewReader(sb.String()))
+	if err != nil || len(errs) > 0 {
+		t.Fatal(err, errs)
+	}
+	for i, row := range rows {
+		if row.Name != names[i] || row.Reason != "=1+1" || row.Excuse != "-"+names[i] {
+			t.Errorf("row %d imported as %+v", i, row)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// addBatcher collects public add confirmations per channel and posts
+// them as a single message once the window has passed since the first
+// add in the batch.
+type addBatcher struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*addBatch
+}
+
+type addBatch struct {
+	responseURL string
+	names       []string
+	texts       []string
+}
+
+func newAddBatcher(window time.Duration) *addBatcher {
+	return &addBatcher{window: window, pending: make(map[string]*addBatch)}
+}
+
+func (b *addBatcher) queue(ctx context.Context, channel, responseURL, name, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.pending[channel]
+	if !ok {
+		batch = &addBatch{}
+		b.pending[channel] = batch
+		ctx = context.WithoutCancel(ctx)
+		time.AfterFunc(b.window, func() { b.flush(ctx, channel) })
+	}
+	// Any response_url from the channel can post there, the latest is
+	// the furthest from expiring.
+	batch.responseURL = responseURL
+	batch.names = append(batch.names, name)
+	batch.texts = append(batch.texts, text)
+}
+
+func (b *addBatcher) flush(ctx context.Context, channel string) {
+	b.mu.Lock()
+	batch := b.pending[channel]
+	delete(b.pending, channel)
+	b.mu.Unlock()
+	if batch == nil {
+		return
+	}
+	text := batch.texts[0]
+	if len(batch.names) > 1 {
+		text = fmt.Sprintf("Added %d people: %s.", len(batch.names), strings.Join(batch.names, ", "))
+	}
+	err := postResponse(ctx, batch.responseURL, newPublicMessage(text))
+	if err != nil {
+		logger(ctx).Error("batched add confirmation lost", "channel", channel, "adds", len(batch.names), "err", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const maxDiffLines = 10
+
+// readBackup loads the entries of a backup file opened read-only.
+func readBackup(path string, bucketName []byte) (map[uint64]entry, error) {
+	db, err := bolt.Open(path, 0, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	entries := make(map[uint64]entry)
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			// Keys that aren't ids are left to fsck.
+			if v == nil || len(k) != 8 {
+				return nil
+			}
+			e, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			entries[e.ID] = e
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (s *server) diff(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	if s.backupDir == "" {
+		return newPrivateMessage("Backups are not enabled on this server."), nil
+	}
+	names := strings.Fields(cmd.args)
+	if len(names) != 2 {
+		return msg{}, errUsage
+	}
+	var sets [2]map[uint64]entry
+	for i, name := range names {
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return newPrivateMessage(fmt.Sprintf("`%s` is not a backup file name.", name)), nil
+		}
+		var err error
+		sets[i], err = readBackup(filepath.Join(s.backupDir, name), s.bolt.backlog(s.backlogKey(cmd.teamID, cmd.channelID)).name)
+		if err != nil {
+			logger(cmd.ctx).Warn("backup unreadable", "name", name, "err", err)
+			return newPrivateMessage(fmt.Sprintf("Couldn't read backup `%s`.", name)), nil
+		}
+	}
+	var added, removed, renamed []uint64
+	for id, b := range sets[1] {
+		a, ok := sets[0][id]
+		switch {
+		case !ok:
+			added = append(added, id)
+		case a.Name != b.Name:
+			renamed = append(renamed, id)
+		}
+	}
+	for id := range sets[0] {
+		if _, ok := sets[1][id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	lines := []string{fmt.Sprintf("*%s → %s:* %d added, %d removed, %d renamed", names[0], names[1], len(added), len(removed), len(renamed))}
+	lines = appendDiff(lines, "+", added, func(id uint64) string {
+		return fmt.Sprintf("%d. %s", id, sets[1][id].Name)
+	})
+	lines = appendDiff(lines, "-", removed, func(id uint64) string {
+		return fmt.Sprintf("%d. %s", id, sets[0][id].Name)
+	})
+	lines = appendDiff(lines, "~", renamed, func(id uint64) string {
+		return fmt.Sprintf("%d. %s → %s", id, sets[0][id].Name, sets[1][id].Name)
+	})
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
+
+func appendDiff(lines []string, prefix string, ids []uint64, format func(uint64) string) []string {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		if i == maxDiffLines {
+			return append(lines, fmt.Sprintf("%s …and %d more", prefix, len(ids)-i))
+		}
+		lines = append(lines, prefix+" "+format(id))
+	}
+	return lines
+}
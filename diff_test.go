@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestReadBackupShortKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.db")
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("backlog"))
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte("x"), []byte(`{"name":"broken"}`))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(1), []byte(`{"name":"alice"}`))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readBackup(path, []byte("backlog"))
+	if err != nil || len(entries) != 1 || entries[1].Name != "alice" {
+		t.Errorf("readBackup = %+v, %v, want only alice", entries, err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var digestsBucket = []byte("digests")
+
+// digest is a weekly summary of what is owed, posted to a channel at a
+// fixed day and time in the server's time zone.
+type digest struct {
+	Team     string       `json:"team,omitempty"`
+	Channel  string       `json:"channel"`
+	Weekday  time.Weekday `json:"weekday"`
+	Hour     int          `json:"hour"`
+	Minute   int          `json:"minute"`
+	LastSent time.Time    `json:"last_sent,omitzero"`
+	// ThreadTS is the first digest posted with threaded replies on,
+	// which later digests reply to.
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+func digestID(team, channel string) string {
+	return team + "/" + channel
+}
+
+// due returns the most recent time the digest was due at or before now.
+func (d digest) due(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), d.Hour, d.Minute, 0, 0, now.Location())
+	t = t.AddDate(0, 0, int(d.Weekday-now.Weekday()))
+	if t.After(now) {
+		t = t.AddDate(0, 0, -7)
+	}
+	return t
+}
+
+func (d digest) String() string {
+	return fmt.Sprintf("%ss at %02d:%02d", d.Weekday, d.Hour, d.Minute)
+}
+
+func (db *store) Digests(ctx context.Context) ([]digest, error) {
+	db = db.with(ctx)
+	var rv []digest
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(digestsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var d digest
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+			rv = append(rv, d)
+			return nil
+		})
+	})
+	return rv, err
+}
+
+func (db *store) SaveDigest(ctx context.Context, d digest) error {
+	db = db.with(ctx)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(digestsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(digestID(d.Team, d.Channel)), b)
+	})
+}
+
+func (db *store) DeleteDigest(ctx context.Context, team, channel string) error {
+	db = db.with(ctx)
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(digestsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(digestID(team, channel)))
+	})
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWeekday accepts day names such as fri, friday or fridays.
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	if len(s) < 3 {
+		return 0, false
+	}
+	d, ok := weekdays[s[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(d.String())+"s", s) {
+		return 0, false
+	}
+	return d, true
+}
+
+func parseClock(s string) (hour, minute int, ok bool) {
+	h, m, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	minute, err = strconv.Atoi(m)
+	if err != nil || len(m) != 2 || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+func (s *server) config(cmd *command) (msg, error) {
+	setting, value, _ := strings.Cut(cmd.args, " ")
+	switch setting {
+	case "digest":
+		return s.configDigest(cmd, strings.Fields(value))
+	case "admins":
+		return s.configAdmins(cmd, strings.Fields(value))
+	case "settle":
+		return s.configSettle(cmd, strings.Fields(value))
+	case "public":
+		return s.configPublic(cmd, strings.Fields(value))
+	}
+	return msg{}, errUsage
+}
+
+func (s *server) configDigest(cmd *command, args []string) (msg, error) {
+	if len(args) == 0 {
+		digests, err := s.store.Digests(cmd.ctx)
+		if err != nil {
+			return msg{}, err
+		}
+		for _, d := range digests {
+			if d.Team == cmd.teamID && d.Channel == cmd.channelID {
+				return newPrivateMessage(fmt.Sprintf("The digest is posted here on %s.", d)), nil
+			}
+		}
+		return newPrivateMessage("No digest is posted here, set one with `/icecream config digest fridays 15:00`."), nil
+	}
+	if len(args) == 1 && args[0] == "off" {
+		err := s.store.DeleteDigest(cmd.ctx, cmd.teamID, cmd.channelID)
+		if err != nil {
+			return msg{}, err
+		}
+		return newPublicMessage("The weekly digest is off for this channel."), nil
+	}
+	if len(args) != 2 {
+		return msg{}, errUsage
+	}
+	day, ok := parseWeekday(args[0])
+	if !ok {
+		return msg{}, usageErrorf("`%s` isn't a day of the week.", args[0])
+	}
+	hour, minute, ok := parseClock(args[1])
+	if !ok {
+		return msg{}, usageErrorf("`%s` isn't a time such as `15:00`.", args[1])
+	}
+	// Count the digest as sent now so a day and time that has already
+	// passed this week doesn't post straight away.
+	d := digest{Team: cmd.teamID, Channel: cmd.channelID, Weekday: day, Hour: hour, Minute: minute, LastSent: time.Now()}
+	err := s.store.SaveDigest(cmd.ctx, d)
+	if err != nil {
+		return msg{}, err
+	}
+	return newPublicMessage(fmt.Sprintf("📬 The ice cream digest will be posted here on %s.", d)), nil
+}
+
+// digestMessage lists what is owed on the backlog, most owed first.
+// Snoozed entries are left out.
+func digestMessage(entries []entry, now time.Time) msg {
+	snoozed := 0
+	entries = slices.DeleteFunc(entries, func(e entry) bool {
+		if e.snoozed(now) {
+			snoozed++
+			return true
+		}
+		return false
+	})
+	if len(entries) == 0 && snoozed > 0 {
+		return newPublicMessage(fmt.Sprintf("🍦 Nothing to chase this week, %s snoozed.", plural(snoozed, "entry", "entries")))
+	}
+	if len(entries) == 0 {
+		return newPublicMessage("🍦 No ice cream debts outstanding this week. Keep those screens locked!")
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].count() > entries[j].count() })
+	owed := 0
+	lines := []string{""}
+	for _, e := range entries {
+		owed += e.count()
+		line := "• " + e.label()
+		if add := e.firstAdd(); !add.Time.IsZero() {
+			line += fmt.Sprintf(" — waiting %s", humanize(now.Sub(add.Time)))
+		}
+		lines = append(lines, line)
+	}
+	lines[0] = fmt.Sprintf("🍦 *Ice cream debts outstanding:* %s", plural(owed, "ice cream", "ice creams"))
+	if snoozed > 0 {
+		lines = append(lines, fmt.Sprintf("_%s snoozed._", plural(snoozed, "entry", "entries")))
+	}
+	return newPublicMessage(strings.Join(lines, "\n"))
+}
+
+// runDigests posts each channel's digest once it falls due.
+func (s *server) runDigests(ctx context.Context) {
+	t := time.NewTicker(schedulePoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			digests, err := s.store.Digests(ctx)
+			if err != nil {
+				slog.Error("digest lookup failed", "err", err)
+				continue
+			}
+			for _, d := range digests {
+				if d.LastSent.Before(d.due(now)) {
+					s.sendDigest(ctx, d, now)
+				}
+			}
+		}
+	}
+}
+
+// sendDigest posts the digest and records it as sent. A digest that can't
+// be posted is skipped until next week rather than retried on every tick.
+func (s *server) sendDigest(ctx context.Context, d digest, now time.Time) {
+	log := slog.With("team", d.Team, "channel", d.Channel)
+	err := s.postDigest(ctx, &d, now)
+	if err != nil {
+		log.Error("digest failed", "err", err)
+	}
+	d.LastSent = now
+	err = s.store.SaveDigest(ctx, d)
+	if err != nil {
+		log.Error("digest failed", "err", err)
+	}
+}
+
+// postDigest posts the digest, in the channel's digest thread when
+// threaded replies are on. A quiet channel misses the week's digest
+// rather than getting it late.
+func (s *server) postDigest(ctx context.Context, d *digest, now time.Time) error {
+	entries, err := s.store.List(ctx, s.backlogKey(d.Team, d.Channel))
+	if err != nil {
+		return err
+	}
+	var thread string
+	if s.threads {
+		thread = d.ThreadTS
+	}
+	ts, err := s.post(ctx, d.Team, d.Channel, thread, digestMessage(entries, now))
+	if err == nil && s.threads && d.ThreadTS == "" {
+		d.ThreadTS = ts
+	}
+	return err
+}
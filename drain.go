@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const drainCheck = 5 * time.Second
+
+// drainer reports maintenance mode, which is on while a file exists at
+// path. The file is polled rather than checked per request.
+type drainer struct {
+	path string
+	on   atomic.Bool
+}
+
+func newDrainer(path string) *drainer {
+	d := &drainer{path: path}
+	d.check()
+	return d
+}
+
+func (d *drainer) draining() bool {
+	return d != nil && d.on.Load()
+}
+
+func (d *drainer) check() {
+	_, err := os.Stat(d.path)
+	on := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("drain file check failed", "path", d.path, "err", err)
+		return
+	}
+	if d.on.Swap(on) != on {
+		slog.Info("maintenance mode changed", "draining", on, "path", d.path)
+	}
+}
+
+func (d *drainer) run(ctx context.Context) {
+	t := time.NewTicker(drainCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			d.check()
+		}
+	}
+}
+
+// mutates reports whether the command writes to the store and so is
+// refused during maintenance.
+func mutates(cmd *command) bool {
+	switch cmd.name {
+	case "add", "add-at", "del", "pay", "undo", "excuse", "reason", "edit", "rename", "clear", "migrate-to", "trim-history", "config", "notify", "snooze", "pin", "unpin", "spotlight", "quiet":
+		return true
+	case "fsck":
+		_, repair := cmd.option("repair")
+		return repair
+	case "paid", "amnesty":
+		_, dryRun := cmd.option("dry-run")
+		return !dryRun
+	}
+	return false
+}
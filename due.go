@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseDue accepts a deadline relative to now such as 2w or 3d, or a date
+// as taken by add-at.
+func parseDue(s string, now time.Time) (time.Time, error) {
+	d, err := parseDuration(s)
+	if err == nil {
+		return now.Add(d), nil
+	}
+	return parseScheduleTime(s, now)
+}
+
+func (e entry) overdue(now time.Time) bool {
+	return !e.Due.IsZero() && e.Due.Before(now)
+}
+
+// dueLabel describes the deadline of the entry, empty when it has none.
+func (e entry) dueLabel(now time.Time) string {
+	switch {
+	case e.Due.IsZero():
+		return ""
+	case e.overdue(now):
+		return fmt.Sprintf("overdue by %s", humanize(now.Sub(e.Due)))
+	}
+	return fmt.Sprintf("due in %s", humanize(e.Due.Sub(now)))
+}
+
+// sortByDue orders entries by deadline, soonest first, with entries that
+// have none at the end.
+func sortByDue(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Due, entries[j].Due
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero()
+		}
+		return a.Before(b)
+	})
+}
+
+func (s *server) overdue(cmd *command) (msg, error) {
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	now := time.Now()
+	sortByDue(entries)
+	var lines []string
+	for _, e := range entries {
+		if e.overdue(now) {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s", e.ID, e.label(), e.dueLabel(now)))
+		}
+	}
+	if len(lines) == 0 {
+		return newPublicMessage("Nothing is overdue. 🍦"), nil
+	}
+	return newPublicMessage(strings.Join(lines, "\n")), nil
+}
+
+func (e entry) snoozed(now time.Time) bool {
+	return e.SnoozedUntil.After(now)
+}
+
+// snooze pushes back the entry's deadline by the duration, and keeps it
+// out of digests until then. `off` ends a snooze early.
+func (s *server) snooze(cmd *command) (msg, error) {
+	id, arg, _ := strings.Cut(cmd.args, " ")
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	arg = strings.TrimSpace(arg)
+	var d time.Duration
+	if arg != "off" {
+		d, err = parseDuration(arg)
+		if err != nil || d <= 0 {
+			return msg{}, errUsage
+		}
+	}
+	now := time.Now()
+	e, err := s.store.Modify(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, func(e *entry) error {
+		if d == 0 {
+			e.SnoozedUntil = time.Time{}
+			e.logBy("woken", now, cmd.reporter())
+			return nil
+		}
+		e.SnoozedUntil = now.Add(d)
+		if !e.Due.IsZero() {
+			e.Due = e.Due.Add(d)
+		}
+		e.logBy("snoozed for "+arg, now, cmd.reporter())
+		return nil
+	})
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	if d == 0 {
+		return newPublicMessage(fmt.Sprintf("⏰ %s (%d) is awake again.", e.Name, e.ID)), nil
+	}
+	text := fmt.Sprintf("😴 Snoozed %s (%d) until %s.", e.Name, e.ID, e.SnoozedUntil.Format(timeFormat))
+	if !e.Due.IsZero() {
+		text += fmt.Sprintf(" Now due %s.", e.Due.Format(timeFormat))
+	}
+	return newPublicMessage(text), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type eventEnvelope struct {
+	Type      string     `json:"type"`
+	TeamID    string     `json:"team_id"`
+	Challenge string     `json:"challenge"`
+	Event     slackEvent `json:"event"`
+}
+
+type slackEvent struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+// handleEvents receives Events API callbacks. Mentions are acknowledged
+// right away and answered in the mention's thread once the command has
+// run, since Slack expects a reply within three seconds.
+func (s *server) handleEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxSignedBody))
+	if err != nil {
+		abort(w, http.StatusBadRequest)
+		return
+	}
+	err = verifySignature(s.signingSecret, req.Header, body, time.Now())
+	if err != nil {
+		abort(w, http.StatusUnauthorized)
+		return
+	}
+	var env eventEnvelope
+	err = json.Unmarshal(body, &env)
+	if err != nil {
+		abort(w, http.StatusBadRequest)
+		return
+	}
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, env.Challenge)
+		return
+	case "event_callback":
+	default:
+		return
+	}
+	// Slack retries callbacks it thinks timed out, and the first delivery
+	// has already been handled.
+	if req.Header.Get("X-Slack-Retry-Num") != "" {
+		return
+	}
+	ev := env.Event
+	if ev.Type != "app_mention" || ev.BotID != "" || ev.User == s.botID {
+		return
+	}
+	if s.channel != "" && ev.Channel != s.channel {
+		return
+	}
+	go s.respondMention(context.WithoutCancel(req.Context()), env.TeamID, ev)
+}
+
+func (s *server) respondMention(ctx context.Context, team string, ev slackEvent) {
+	cmd := &command{
+		ctx:       ctx,
+		userID:    ev.User,
+		teamID:    team,
+		channelID: ev.Channel,
+	}
+	cmd.parseText(stripMention(ev.Text))
+	cmd.ctx = withLogger(ctx, logger(ctx).With(cmd.logFields()...))
+	m, err := s.run(cmd)
+	if err == errUnknownCommand {
+		m, err = s.help(cmd)
+	}
+	if err == errShuttingDown {
+		m = newPrivateMessage("The service is shutting down, please try again shortly.")
+	}
+	thread := ev.ThreadTS
+	if thread == "" {
+		thread = ev.TS
+	}
+	if m.Type == "ephemeral" {
+		var client *slackClient
+		client, err = s.slackFor(ctx, team)
+		if err == nil {
+			err = client.postEphemeral(ctx, ev.Channel, ev.User, thread, m)
+		}
+	} else {
+		_, err = s.post(ctx, team, ev.Channel, thread, m)
+	}
+	if err != nil {
+		logger(ctx).Error("mention reply failed", "command", cmd.name, "channel", ev.Channel, "err", err)
+	}
+}
+
+// stripMention removes the leading bot mention from the text of an
+// app_mention event, leaving the command.
+func stripMention(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "<@") {
+		if i := strings.Index(text, ">"); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const exportVersion = 2
+
+// maxFullImport limits the size of a full import's body.
+var maxFullImport int64 = 64 << 20
+
+// snapshot is everything a store holds in the same shape whatever the
+// backend, so a deployment can move between bolt and SQL. Pending undos
+// aren't carried over.
+type snapshot struct {
+	Version   int                 `json:"version"`
+	Schema    int                 `json:"schema"`
+	Backlogs  []backlogSnapshot   `json:"backlogs"`
+	Scheduled []scheduledSnapshot `json:"scheduled,omitempty"`
+	Teams     []teamSnapshot      `json:"teams,omitempty"`
+	Digests   []digest            `json:"digests,omitempty"`
+	Quiet     []quietSnapshot     `json:"quiet,omitempty"`
+	NotifyOff []notifyOffSnapshot `json:"notify_off,omitempty"`
+}
+
+type backlogSnapshot struct {
+	Key             string          `json:"key"`
+	Sequence        uint64          `json:"sequence"`
+	Entries         []entrySnapshot `json:"entries,omitempty"`
+	ArchiveSequence uint64          `json:"archive_sequence,omitempty"`
+	Archive         []entrySnapshot `json:"archive,omitempty"`
+	History         []historyRecord `json:"history,omitempty"`
+}
+
+type entrySnapshot struct {
+	ID    uint64 `json:"id"`
+	Entry entry  `json:"entry"`
+}
+
+type scheduledSnapshot struct {
+	ID  uint64       `json:"id"`
+	Add scheduledAdd `json:"add"`
+}
+
+type teamSnapshot struct {
+	ID      string       `json:"id"`
+	Install *teamInstall `json:"install,omitempty"`
+	Config  teamConfig   `json:"config,omitzero"`
+}
+
+type quietSnapshot struct {
+	Team    string    `json:"team"`
+	Channel string    `json:"channel"`
+	Until   time.Time `json:"until"`
+}
+
+type notifyOffSnapshot struct {
+	Team string `json:"team"`
+	User string `json:"user"`
+}
+
+func newSnapshot() snapshot {
+	return snapshot{Version: exportVersion, Schema: schemaVersion()}
+}
+
+// check refuses a snapshot this build can't load.
+func (data snapshot) check() error {
+	if data.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d", data.Version)
+	}
+	if data.Schema > schemaVersion() {
+		return fmt.Errorf("export schema version %d is newer than the %d this build supports", data.Schema, schemaVersion())
+	}
+	keys := make(map[string]bool)
+	for _, b := range data.Backlogs {
+		if keys[b.Key] {
+			return fmt.Errorf("backlog %q is exported twice", b.Key)
+		}
+		keys[b.Key] = true
+		err := checkEntries(b.Key, b.Sequence, b.Entries)
+		if err == nil {
+			err = checkEntries(archiveKey(b.Key), b.ArchiveSequence, b.Archive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntries makes sure each entry has a name and an id of its own
+// that the backlog's sequence has already handed out.
+func checkEntries(key string, seq uint64, entries []entrySnapshot) error {
+	ids := make(map[uint64]bool)
+	for _, e := range entries {
+		if e.ID == 0 || e.ID > seq || ids[e.ID] {
+			return fmt.Errorf("backlog %q: entry id %d is missing, repeated or past the sequence", key, e.ID)
+		}
+		ids[e.ID] = true
+		if strings.TrimSpace(e.Entry.Name) == "" {
+			return fmt.Errorf("backlog %q: entry %d has no name", key, e.ID)
+		}
+	}
+	return nil
+}
+
+// snapshotEntries returns the snapshot's entries with their ids filled in.
+func snapshotEntries(entries []entrySnapshot) []entry {
+	rv := make([]entry, len(entries))
+	for i, e := range entries {
+		rv[i] = e.Entry
+		rv[i].ID = e.ID
+	}
+	return rv
+}
+
+func entrySnapshots(entries []entry) []entrySnapshot {
+	var rv []entrySnapshot
+	for _, e := range entries {
+		rv = append(rv, entrySnapshot{e.ID, e})
+	}
+	return rv
+}
+
+// snapshotBacklogs gathers backlogs by key while a store is read.
+type snapshotBacklogs map[string]*backlogSnapshot
+
+func (m snapshotBacklogs) get(key string) *backlogSnapshot {
+	b, ok := m[key]
+	if !ok {
+		b = &backlogSnapshot{Key: key}
+		m[key] = b
+	}
+	return b
+}
+
+func (m snapshotBacklogs) sorted() []backlogSnapshot {
+	rv := make([]backlogSnapshot, 0, len(m))
+	for _, b := range m {
+		rv = append(rv, *b)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Key < rv[j].Key })
+	return rv
+}
+
+// snapshotTeams gathers installs and configs by team id.
+type snapshotTeams map[string]*teamSnapshot
+
+func (m snapshotTeams) get(id string) *teamSnapshot {
+	t, ok := m[id]
+	if !ok {
+		t = &teamSnapshot{ID: id}
+		m[id] = t
+	}
+	return t
+}
+
+func (m snapshotTeams) sorted() []teamSnapshot {
+	rv := make([]teamSnapshot, 0, len(m))
+	for _, t := range m {
+		rv = append(rv, *t)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].ID < rv[j].ID })
+	return rv
+}
+
+func (db *store) Export(ctx context.Context) (snapshot, error) {
+	db = db.with(ctx)
+	data := newSnapshot()
+	backlogs := snapshotBacklogs{}
+	teams := snapshotTeams{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			var err error
+			switch s := string(name); {
+			case db.isBacklog(name):
+				b := backlogs.get(db.keyOf(name))
+				b.Sequence = bucket.Sequence()
+				b.Entries, err = dumpEntries(bucket)
+			case strings.HasPrefix(s, "archive:") && db.isBacklog(name[len("archive:"):]):
+				b := backlogs.get(db.keyOf(name[len("archive:"):]))
+				b.ArchiveSequence = bucket.Sequence()
+				b.Archive, err = dumpEntries(bucket)
+			case strings.HasPrefix(s, "history:") && db.isBacklog(name[len("history:"):]):
+				b := backlogs.get(db.keyOf(name[len("history:"):]))
+				err = bucket.ForEach(func(k, v []byte) error {
+					var r historyRecord
+					err := json.Unmarshal(v, &r)
+					b.History = append(b.History, r)
+					return err
+				})
+			case s == string(scheduledBucket):
+				err = bucket.ForEach(func(k, v []byte) error {
+					a := scheduledSnapshot{ID: itou(k)}
+					err := json.Unmarshal(v, &a.Add)
+					data.Scheduled = append(data.Scheduled, a)
+					return err
+				})
+			case s == string(teamsBucket):
+				err = bucket.ForEach(func(k, v []byte) error {
+					var t teamInstall
+					err := json.Unmarshal(v, &t)
+					teams.get(string(k)).Install = &t
+					return err
+				})
+			case s == string(teamConfigBucket):
+				err = bucket.ForEach(func(k, v []byte) error {
+					return json.Unmarshal(v, &teams.get(string(k)).Config)
+				})
+			case s == string(digestsBucket):
+				err = bucket.ForEach(func(k, v []byte) error {
+					var d digest
+					err := json.Unmarshal(v, &d)
+					data.Digests = append(data.Digests, d)
+					return err
+				})
+			case s == string(metaBucket):
+				err = bucket.ForEach(func(k, v []byte) error {
+					if id, ok := strings.CutPrefix(string(k), "quiet:"); ok {
+						team, channel, _ := strings.Cut(id, "/")
+						data.Quiet = append(data.Quiet, quietSnapshot{team, channel, time.Unix(int64(itou(v)), 0)})
+					}
+					if id, ok := strings.CutPrefix(string(k), "notify-off:"); ok {
+						team, user, _ := strings.Cut(id, "/")
+						data.NotifyOff = append(data.NotifyOff, notifyOffSnapshot{team, user})
+					}
+					return nil
+				})
+			}
+			return err
+		})
+	})
+	data.Backlogs = backlogs.sorted()
+	data.Teams = teams.sorted()
+	return data, err
+}
+
+func dumpEntries(bucket *bolt.Bucket) ([]entrySnapshot, error) {
+	var rv []entrySnapshot
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		e, err := decodeEntry(k, v)
+		rv = append(rv, entrySnapshot{e.ID, e})
+		return err
+	})
+	return rv, err
+}
+
+// Import replaces everything in the database with the snapshot in a
+// single transaction. The snapshot's schema version is recorded before
+// the migrations run, so one taken by an older build is brought up to
+// date.
+func (db *store) Import(ctx context.Context, data snapshot) error {
+	db = db.with(ctx)
+	return db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			err = tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, bs := range data.Backlogs {
+			b := db.backlog(bs.Key)
+			err = loadEntries(tx, b.name, bs.Sequence, bs.Entries)
+			if err != nil {
+				return err
+			}
+			if bs.ArchiveSequence > 0 || len(bs.Archive) > 0 {
+				err = loadEntries(tx, b.archiveName(), bs.ArchiveSequence, bs.Archive)
+				if err != nil {
+					return err
+				}
+			}
+			for _, r := range bs.History {
+				err = b.record(tx, r.Action, entry{ID: r.EntryID, Name: r.Name}, r.Time, r.By)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(data.Scheduled) > 0 {
+			bucket, err := tx.CreateBucket(scheduledBucket)
+			if err != nil {
+				return err
+			}
+			for _, a := range data.Scheduled {
+				err = putJSON(bucket, itob(a.ID), a.Add)
+				if err == nil && a.ID > bucket.Sequence() {
+					err = bucket.SetSequence(a.ID)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, t := range data.Teams {
+			if t.Install != nil {
+				err = createPutJSON(tx, teamsBucket, []byte(t.ID), t.Install)
+				if err != nil {
+					return err
+				}
+			}
+			err = createPutJSON(tx, teamConfigBucket, []byte(t.ID), t.Config)
+			if err != nil {
+				return err
+			}
+		}
+		for _, d := range data.Digests {
+			err = createPutJSON(tx, digestsBucket, []byte(digestID(d.Team, d.Channel)), d)
+			if err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		for _, q := range data.Quiet {
+			err = meta.Put(quietKey(q.Team, q.Channel), itob(uint64(q.Until.Unix())))
+			if err != nil {
+				return err
+			}
+		}
+		for _, n := range data.NotifyOff {
+			err = meta.Put(notifyOffKey(n.Team, n.User), []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		err = meta.Put(schemaVersionKey, itob(uint64(data.Schema)))
+		if err != nil {
+			return err
+		}
+		return db.migrateTx(tx)
+	})
+}
+
+func loadEntries(tx *bolt.Tx, name []byte, seq uint64, entries []entrySnapshot) error {
+	bucket, err := tx.CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	err = bucket.SetSequence(seq)
+	if err != nil {
+		return err
+	}
+	for _, e := range snapshotEntries(entries) {
+		err = putEntry(bucket, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putJSON(bucket *bolt.Bucket, k []byte, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(k, b)
+}
+
+func createPutJSON(tx *bolt.Tx, name, k []byte, v any) error {
+	bucket, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	return putJSON(bucket, k, v)
+}
+
+func (s *server) requireAPIKey(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if s.apiKey == nil || !s.apiKey.equal(key) {
+			abort(w, http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
+
+func (s *server) handleExportFull(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := s.store.Export(req.Context())
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("export failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="icecream.json"`)
+	err = json.NewEncoder(w).Encode(data)
+	if err != nil {
+		logger(req.Context()).Error("export failed", "err", err)
+	}
+}
+
+func (s *server) handleImportFull(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.drain.draining() {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	var data snapshot
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxFullImport)).Decode(&data)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abort(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err == nil {
+		err = data.check()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = s.store.Import(req.Context(), data)
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("import failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	logger(req.Context()).Info("store replaced from import", "backlogs", len(data.Backlogs))
+	w.WriteHeader(http.StatusNoContent)
+}
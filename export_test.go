@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillStore writes something of every kind a snapshot carries.
+func fillStore(t *testing.T, db Store) {
+	t.Helper()
+	alice, bob := reporter{ID: "U1", Name: "alice"}, reporter{ID: "U2", Name: "bob"}
+	_, err := db.Add(t.Context(), "", alice, addDetails{reason: "broke the build"}, "carol", "dave")
+	if err == nil {
+		_, err = db.Add(t.Context(), "", bob, addDetails{}, "carol", "erin")
+	}
+	if err == nil {
+		_, err = db.Add(t.Context(), "T1/C2", bob, addDetails{}, "frank")
+	}
+	if err == nil {
+		_, err = db.Pay(t.Context(), "", 1, "", alice)
+	}
+	if err == nil {
+		_, err = db.Del(t.Context(), "", 2, bob)
+	}
+	if err == nil {
+		_, err = db.Schedule(t.Context(), "T1/C2", "gina", time.Unix(2000000000, 0), alice)
+	}
+	if err == nil {
+		err = db.SaveTeam(t.Context(), "T1", teamInstall{Name: "Acme", BotToken: "xoxb-1", InstalledAt: time.Unix(1700000000, 0)})
+	}
+	if err == nil {
+		err = db.SaveTeamConfig(t.Context(), "T2", teamConfig{Admins: []string{"U9"}, Settle: "chain"})
+	}
+	if err == nil {
+		err = db.SaveDigest(t.Context(), digest{Team: "T1", Channel: "C1", Weekday: time.Friday, Hour: 16})
+	}
+	if err == nil {
+		err = db.SetQuiet(t.Context(), "T1", "C3", time.Unix(2000000000, 0))
+	}
+	if err == nil {
+		err = db.SetNotifyOptOut(t.Context(), "T1", "U2", true)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exportJSON(t *testing.T, db Store) string {
+	t.Helper()
+	data, err := db.Export(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestExportImport moves a snapshot from every backend into every other
+// and expects the same snapshot back out.
+func TestExportImport(t *testing.T) {
+	for from, src := range stores(t) {
+		fillStore(t, src)
+		data, err := src.Export(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := data.check(); err != nil {
+			t.Fatalf("%s: %v", from, err)
+		}
+		want := exportJSON(t, src)
+		for to, dst := range stores(t) {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				_, err := dst.Add(t.Context(), "", reporter{}, addDetails{}, "stale")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = dst.Import(t.Context(), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := exportJSON(t, dst); got != want {
+					t.Errorf("export after import =\n%s\nwant\n%s", got, want)
+				}
+				entries, err := dst.List(t.Context(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 2 || entries[0].Name != "carol" || entries[1].Name != "erin" {
+					t.Errorf("entries = %+v, want carol and erin", entries)
+				}
+				added, err := dst.Add(t.Context(), "", reporter{}, addDetails{}, "hank")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if added[0].ID != 4 {
+					t.Errorf("next id = %d, want 4", added[0].ID)
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshotCheck(t *testing.T) {
+	entries := []entrySnapshot{{1, entry{Name: "alice"}}}
+	tests := []struct {
+		name string
+		data snapshot
+		ok   bool
+	}{
+		{"current", snapshot{Version: exportVersion, Schema: schemaVersion(), Backlogs: []backlogSnapshot{{Key: "", Sequence: 1, Entries: entries}}}, true},
+		{"older schema", snapshot{Version: exportVersion, Schema: 1}, true},
+		{"bucket dump", snapshot{Version: 1}, false},
+		{"newer schema", snapshot{Version: exportVersion, Schema: schemaVersion() + 1}, false},
+		{"id past sequence", snapshot{Version: exportVersion, Backlogs: []backlogSnapshot{{Key: "", Entries: entries}}}, false},
+		{"repeated backlog", snapshot{Version: exportVersion, Backlogs: []backlogSnapshot{{Key: "C1"}, {Key: "C1"}}}, false},
+		{"no name", snapshot{Version: exportVersion, Backlogs: []backlogSnapshot{{Key: "", Sequence: 1, Entries: []entrySnapshot{{1, entry{}}}}}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.data.check()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: check() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+type fillReader byte
+
+func (r fillReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestFullImportAPI(t *testing.T) {
+	defer func(n int64) { maxFullImport = n }(maxFullImport)
+	maxFullImport = 16 << 10
+	ts := newTestServer(t)
+	fillStore(t, ts.db)
+	var data snapshot
+	code := ts.api(t, http.MethodGet, "/api/export/full", testAPIKey, nil, &data)
+	if code != http.StatusOK {
+		t.Fatalf("export = %d", code)
+	}
+	want := exportJSON(t, ts.db)
+
+	tests := []struct {
+		name string
+		body io.Reader
+		want int
+	}{
+		{"bucket dump", strings.NewReader(`{"version":1,"buckets":[]}`), http.StatusBadRequest},
+		{"newer schema", strings.NewReader(`{"version":2,"schema":99}`), http.StatusBadRequest},
+		{"too large", io.MultiReader(strings.NewReader(`{"version":2,"backlogs":[{"key":"`), io.LimitReader(fillReader('a'), maxFullImport)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := ts.api(t, http.MethodPost, "/api/import/full", testAPIKey, tt.body, nil)
+			if code != tt.want {
+				t.Errorf("import = %d, want %d", code, tt.want)
+			}
+		})
+	}
+	if got := exportJSON(t, ts.db); got != want {
+		t.Errorf("rejected imports changed the store:\n%s", got)
+	}
+
+	ts.slash(t, "U1", "add zed")
+	b, _ := json.Marshal(data)
+	code = ts.api(t, http.MethodPost, "/api/import/full", testAPIKey, strings.NewReader(string(b)), nil)
+	if code != http.StatusNoContent {
+		t.Fatalf("import = %d", code)
+	}
+	if got := exportJSON(t, ts.db); got != want {
+		t.Errorf("export after import =\n%s\nwant\n%s", got, want)
+	}
+}
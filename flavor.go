@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+var defaultAddMessages = []string{
+	"Added {{.Name}} to the queue.",
+	"{{.Name}} is on the hook for ice cream (#{{.ID}}). 🍦",
+	"Another one! {{.Name}} joins the backlog as #{{.ID}}.",
+	"Screen left unlocked? That'll be ice cream, {{.Name}}. (#{{.ID}})",
+	"🍨 {{.Name}} has been volunteered. Entry #{{.ID}}.",
+}
+
+type flavorData struct {
+	ID   uint64
+	Name string
+}
+
+// parseAddMessages parses the templates and runs each once against a
+// sample entry, so a template naming a field that doesn't exist is
+// rejected at startup instead of failing an add.
+func parseAddMessages(texts []string) ([]*template.Template, error) {
+	rv := make([]*template.Template, len(texts))
+	for i, text := range texts {
+		t, err := template.New("add").Option("missingkey=error").Parse(text)
+		if err == nil {
+			err = t.Execute(io.Discard, flavorData{ID: 1, Name: "alice"})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("add message %q: %w", text, err)
+		}
+		rv[i] = t
+	}
+	return rv, nil
+}
+
+// loadAddMessages reads one message template per line, skipping blank
+// lines and lines starting with #.
+func loadAddMessages(path string) ([]*template.Template, error) {
+	if path == "" {
+		return parseAddMessages(defaultAddMessages)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var texts []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			texts = append(texts, line)
+		}
+	}
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+	return parseAddMessages(texts)
+}
+
+func (s *server) addMessage(id uint64, name string) (string, error) {
+	if len(s.addMessages) == 0 {
+		return "Added " + name + " to the queue.", nil
+	}
+	t := s.addMessages[int(s.random()*float64(len(s.addMessages)))]
+	var sb strings.Builder
+	err := t.Execute(&sb, flavorData{id, name})
+	return sb.String(), err
+}
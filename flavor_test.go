@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestParseAddMessages(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+	}{
+		{"Added {{.Name}} as #{{.ID}}.", true},
+		{"No fields at all.", true},
+		{"{{.Name", false},
+		{"Added {{.Nmae}}.", false},
+		{"{{.Name.First}}", false},
+	}
+	for _, tt := range tests {
+		_, err := parseAddMessages([]string{tt.text})
+		if (err == nil) != tt.ok {
+			t.Errorf("parseAddMessages(%q) error = %v, want ok %v", tt.text, err, tt.ok)
+		}
+	}
+	_, err := parseAddMessages(defaultAddMessages)
+	if err != nil {
+		t.Errorf("default messages: %v", err)
+	}
+}
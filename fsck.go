@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var quarantineBucket = []byte("quarantine")
+
+type fsckReport struct {
+	healthy     int
+	legacy      int
+	broken      int
+	repaired    int
+	quarantined int
+}
+
+// fsck checks that every value in the backlogs decodes. With repair set,
+// legacy plain-name values are rewritten in the current format and values
+// that can't be decoded are moved to the quarantine bucket, in a nested
+// bucket named after their backlog under their original key.
+func (db *store) fsck(repair bool) (fsckReport, error) {
+	var r fsckReport
+	fn := func(tx *bolt.Tx) error {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if db.isBacklog(name) {
+				names = append(names, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			err = fsckBucket(tx, name, repair, &r)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if repair {
+		return r, db.Update(fn)
+	}
+	return r, db.View(fn)
+}
+
+func fsckBucket(tx *bolt.Tx, name []byte, repair bool, r *fsckReport) error {
+	bucket := tx.Bucket(name)
+	var legacy []entry
+	var broken [][2][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v == nil || len(k) != 8 {
+			broken = append(broken, [2][]byte{k, v})
+			return nil
+		}
+		e, err := decodeEntry(k, v)
+		switch {
+		case err != nil || e.Name == "":
+			broken = append(broken, [2][]byte{k, v})
+		case !bytes.HasPrefix(v, []byte("{")):
+			legacy = append(legacy, e)
+		default:
+			r.healthy++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.legacy += len(legacy)
+	r.broken += len(broken)
+	if !repair {
+		return nil
+	}
+	for _, e := range legacy {
+		err = putEntry(bucket, e)
+		if err != nil {
+			return err
+		}
+		r.repaired++
+	}
+	if len(broken) == 0 {
+		return nil
+	}
+	root, err := tx.CreateBucketIfNotExists(quarantineBucket)
+	if err != nil {
+		return err
+	}
+	q, err := root.CreateBucketIfNotExists(name)
+	if err != nil {
+		return err
+	}
+	for _, kv := range broken {
+		k := append([]byte(nil), kv[0]...)
+		if kv[1] == nil {
+			// Nested buckets don't belong in a backlog and can't be
+			// moved as a value, so they are left for an operator to
+			// inspect.
+			continue
+		}
+		err = q.Put(k, append([]byte(nil), kv[1]...))
+		if err != nil {
+			return err
+		}
+		err = bucket.Delete(k)
+		if err != nil {
+			return err
+		}
+		r.quarantined++
+	}
+	return nil
+}
+
+func (s *server) fsck(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	if s.bolt == nil {
+		return newPrivateMessage("fsck only works with the bolt store."), nil
+	}
+	_, repair := cmd.option("repair")
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	r, err := s.bolt.fsck(repair)
+	if err != nil {
+		return msg{}, err
+	}
+	lines := []string{fmt.Sprintf("*fsck:* %d healthy, %d in the legacy format, %d unreadable", r.healthy, r.legacy, r.broken)}
+	if repair {
+		lines = append(lines, fmt.Sprintf("Repaired %d and quarantined %d.", r.repaired, r.quarantined))
+		if n := r.broken - r.quarantined; n > 0 {
+			lines = append(lines, fmt.Sprintf("%d nested buckets need manual attention.", n))
+		}
+	} else if r.legacy+r.broken > 0 {
+		lines = append(lines, "Run `/icecream fsck --repair` to migrate legacy entries and quarantine unreadable ones.")
+	}
+	logger(cmd.ctx).Info("fsck", "repair", repair, "healthy", r.healthy, "legacy", r.legacy, "broken", r.broken, "repaired", r.repaired, "quarantined", r.quarantined)
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleHealth reports that the process is up.
+func handleHealth(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady reports whether the store can be read, using the version
+// lookup as a cheap read transaction.
+func (s *server) handleReady(w http.ResponseWriter, req *http.Request) {
+	_, err := s.store.Version(req.Context())
+	if err != nil {
+		logger(req.Context()).Warn("not ready", "err", err)
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	handleHealth(w, req)
+}
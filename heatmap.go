@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+var heatmapShades = []rune("·░▒▓█")
+
+// renderHeatmap draws one row per weekday and one column per week, ending
+// with the week containing now, shading each day by how many of the times
+// fall on it relative to the busiest day.
+func renderHeatmap(times []time.Time, now time.Time, weeks int) string {
+	today := day(now)
+	offset := (int(today.Weekday()) + 6) % 7
+	start := today.AddDate(0, 0, -offset-7*(weeks-1))
+	counts := make(map[time.Time]int)
+	max := 0
+	for _, t := range times {
+		d := day(t.In(now.Location()))
+		if d.Before(start) || d.After(today) {
+			continue
+		}
+		counts[d]++
+		if counts[d] > max {
+			max = counts[d]
+		}
+	}
+	var sb strings.Builder
+	for i, label := range []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"} {
+		sb.WriteString(label)
+		sb.WriteByte(' ')
+		for w := 0; w < weeks; w++ {
+			d := start.AddDate(0, 0, 7*w+i)
+			switch {
+			case d.After(today):
+				sb.WriteRune(' ')
+			case counts[d] == 0:
+				sb.WriteRune(heatmapShades[0])
+			default:
+				n := len(heatmapShades) - 1
+				sb.WriteRune(heatmapShades[(counts[d]*n+max-1)/max])
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
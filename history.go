@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistory = 10
+	maxHistory     = 50
+	historyTrim    = time.Hour
+)
+
+// historyRecord is an entry in a backlog's append-only history, kept even
+// after the entry it describes is gone.
+type historyRecord struct {
+	Action  string    `json:"action"`
+	EntryID uint64    `json:"entry_id"`
+	Name    string    `json:"name"`
+	Time    time.Time `json:"time"`
+	By      reporter  `json:"by,omitzero"`
+}
+
+func (db backlog) historyName() []byte {
+	return []byte("history:" + string(db.name))
+}
+
+// record appends to the backlog's history within the caller's transaction.
+func (db backlog) record(tx *bolt.Tx, action string, e entry, t time.Time, by reporter) error {
+	bucket, err := tx.CreateBucketIfNotExists(db.historyName())
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(historyRecord{action, e.ID, e.Name, t, by})
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(seq), b)
+}
+
+// history returns up to n of the most recent records, newest first.
+func (db backlog) history(n int) ([]historyRecord, error) {
+	var rv []historyRecord
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.historyName())
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil && len(rv) < n; k, v = c.Prev() {
+			var r historyRecord
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			rv = append(rv, r)
+		}
+		return nil
+	})
+	return rv, err
+}
+
+// TrimHistory deletes the history records of every backlog written before
+// the given time and returns how many there were. Records are keyed in
+// the order they were written, so the old ones are the start of each
+// bucket and the scan stops at the first record to keep.
+func (db *store) TrimHistory(ctx context.Context, before time.Time) (int, error) {
+	db = db.with(ctx)
+	var n int
+	err := db.Update(func(tx *bolt.Tx) error {
+		n = 0
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if !strings.HasPrefix(string(name), "history:") {
+				return nil
+			}
+			var old [][]byte
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var r historyRecord
+				err := json.Unmarshal(v, &r)
+				if err != nil {
+					return err
+				}
+				if !r.Time.Before(before) {
+					break
+				}
+				old = append(old, k)
+			}
+			for _, k := range old {
+				err := bucket.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			n += len(old)
+			return nil
+		})
+	})
+	return n, err
+}
+
+// runHistoryTrimmer deletes history older than the retention every
+// historyTrim.
+func runHistoryTrimmer(ctx context.Context, st Store, retention time.Duration) {
+	t := time.NewTicker(historyTrim)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			n, err := st.TrimHistory(ctx, now.Add(-retention))
+			if err != nil {
+				slog.Error("history trim failed", "err", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("trimmed history", "records", n)
+			}
+		}
+	}
+}
+
+func (s *server) trimHistory(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	if s.historyRetention == 0 {
+		return newPrivateMessage("History is kept forever, set -history-retention to trim it."), nil
+	}
+	n, err := s.store.TrimHistory(cmd.ctx, time.Now().Add(-s.historyRetention))
+	if err != nil {
+		return msg{}, err
+	}
+	if n == 0 {
+		return newPrivateMessage(fmt.Sprintf("There is no history older than %s to trim.", humanize(s.historyRetention))), nil
+	}
+	return newPrivateMessage(fmt.Sprintf("Trimmed %s older than %s.", plural(n, "history record", "history records"), humanize(s.historyRetention))), nil
+}
+
+func (s *server) history(cmd *command) (msg, error) {
+	n := defaultHistory
+	if cmd.args != "" {
+		v, err := strconv.Atoi(cmd.args)
+		if err != nil || v < 1 || v > maxHistory {
+			return msg{}, errUsage
+		}
+		n = v
+	}
+	records, err := s.store.History(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n)
+	if err != nil {
+		return msg{}, err
+	}
+	if len(records) == 0 {
+		return newPrivateMessage("Nothing has happened on this backlog yet."), nil
+	}
+	lines := []string{"*Recent history:*"}
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("• %s %s %s (%d) by %s", r.Time.Format(timeFormat), r.Action, r.Name, r.EntryID, r.By))
+	}
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// importRow is an entry to import, in the shape of the CSV export. JSON
+// imports use the same field names.
+type importRow struct {
+	Name    string    `json:"name"`
+	Count   int       `json:"count,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Excuse  string    `json:"excuse,omitempty"`
+	AddedBy string    `json:"added_by,omitempty"`
+	AddedAt time.Time `json:"added_at,omitzero"`
+	Due     time.Time `json:"due,omitzero"`
+	Pinned  bool      `json:"pinned,omitempty"`
+
+	// line is the row's position in the file, counting from 1 for the
+	// first entry.
+	line int
+}
+
+// importError reports what is wrong with one row.
+type importError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+var errImportFormat = errors.New("import format must be csv or json")
+
+// parseImport reads the rows in the given format, csv or json. Rows that
+// don't validate are reported rather than returned.
+func parseImport(r io.Reader, format string) ([]importRow, []importError, error) {
+	var rows []importRow
+	var errs []importError
+	switch format {
+	case "json":
+		err := json.NewDecoder(r).Decode(&rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range rows {
+			rows[i].line = i + 1
+		}
+	case "csv":
+		var err error
+		rows, errs, err = parseImportCSV(r)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, errImportFormat
+	}
+	valid := rows[:0]
+	for _, row := range rows {
+		row.Name = strings.TrimSpace(row.Name)
+		row.Reason = sanitize(row.Reason)
+		row.Excuse = sanitize(row.Excuse)
+		if row.Count == 0 {
+			row.Count = 1
+		}
+		switch {
+		case row.Name == "":
+			errs = append(errs, importError{row.line, "name is required"})
+		case row.Count < 0:
+			errs = append(errs, importError{row.line, "count must be positive"})
+		case utf8.RuneCountInString(row.Reason) > maxReasonLength:
+			errs = append(errs, importError{row.line, fmt.Sprintf("reason is longer than %d characters", maxReasonLength)})
+		case utf8.RuneCountInString(row.Excuse) > maxExcuseLength:
+			errs = append(errs, importError{row.line, fmt.Sprintf("excuse is longer than %d characters", maxExcuseLength)})
+		default:
+			valid = append(valid, row)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Row < errs[j].Row })
+	return valid, errs, nil
+}
+
+// parseImportCSV maps columns by the header row, so the columns can come
+// in any order and unknown ones, such as id, are ignored.
+func parseImportCSV(r io.Reader) ([]importRow, []importError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["name"]; !ok {
+		return nil, nil, errors.New("csv header has no name column")
+	}
+	var rows []importRow
+	var errs []importError
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		get := func(name string) string {
+			i, ok := col[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return csvUncell(strings.TrimSpace(record[i]))
+		}
+		row := importRow{Name: get("name"), Reason: get("reason"), Excuse: get("excuse"), AddedBy: get("added_by"), line: n}
+		var problems []string
+		if v := get("count"); v != "" {
+			row.Count, err = strconv.Atoi(v)
+			if err != nil || row.Count < 1 {
+				problems = append(problems, fmt.Sprintf("invalid count %q", v))
+			}
+		}
+		for _, f := range []struct {
+			name string
+			t    *time.Time
+		}{{"added_at", &row.AddedAt}, {"due", &row.Due}} {
+			if v := get(f.name); v != "" {
+				*f.t, err = time.Parse(time.RFC3339, v)
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("invalid %s %q", f.name, v))
+				}
+			}
+		}
+		if v := get("pinned"); v != "" {
+			row.Pinned, err = strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("invalid pinned %q", v))
+			}
+		}
+		if len(problems) > 0 {
+			errs = append(errs, importError{n, strings.Join(problems, ", ")})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs, nil
+}
+
+// importRows adds the rows to the backlog, merging with entries of the
+// same name as add does. It returns the number of rows imported.
+func (s *server) importRows(ctx context.Context, key string, rows []importRow) (int, error) {
+	for i, row := range rows {
+		name, _ := parseMention(row.Name)
+		by := reporter{Name: strings.TrimPrefix(row.AddedBy, "@")}
+		if _, id := parseMention(row.AddedBy); id != "" {
+			by = reporter{ID: id}
+		}
+		names := make([]string, row.Count)
+		for j := range names {
+			names[j] = name
+		}
+		added, err := s.store.Add(ctx, key, by, addDetails{}, names...)
+		if err != nil {
+			return i, err
+		}
+		_, err = s.store.Modify(ctx, key, added[len(added)-1].ID, func(e *entry) error {
+			if !row.AddedAt.IsZero() {
+				for j := max(len(e.Events)-row.Count, 0); j < len(e.Events); j++ {
+					e.Events[j].Time = row.AddedAt
+				}
+			}
+			if row.Reason != "" {
+				e.Reason = row.Reason
+			}
+			if row.Excuse != "" {
+				e.Excuse = row.Excuse
+			}
+			if !row.Due.IsZero() {
+				e.Due = row.Due
+			}
+			if row.Pinned && !e.Pinned {
+				e.setPinned(true, time.Now())
+			}
+			e.log("imported", time.Now())
+			return nil
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(rows), nil
+}
+
+// handleImport serves POST /api/v1/import, taking CSV or JSON by content
+// type. Nothing is imported unless every row is valid.
+func (s *server) handleImport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.drain.draining() {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	format := "json"
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "text/csv") {
+		format = "csv"
+	}
+	rows, errs, err := parseImport(req.Body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(errs) > 0 {
+		writeJSON(w, req, http.StatusUnprocessableEntity, map[string]interface{}{"errors": errs})
+		return
+	}
+	n, err := s.importRows(req.Context(), s.backlogKey(req.FormValue("team"), req.FormValue("channel")), rows)
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("import failed", "imported", n, "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, req, http.StatusOK, map[string]int{"imported": n})
+}
+
+// importFile runs the one-shot -import mode, taking the format from the
+// file's extension.
+func (s *server) importFile(path, team, channel string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	rows, errs, err := parseImport(f, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			slog.Error("invalid import row", "path", path, "row", e.Row, "err", e.Error)
+		}
+		log.Fatalf("%s: %d invalid rows, nothing imported", path, len(errs))
+	}
+	n, err := s.importRows(context.Background(), s.backlogKey(team, channel), rows)
+	if err != nil {
+		log.Fatalf("%s: imported %d rows before failing: %v", path, n, err)
+	}
+	slog.Info("imported entries", "path", path, "rows", n)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var errNotEmpty = errors.New("the store already holds entries")
+
+// importBolt copies the backlogs, their history and archives, scheduled
+// adds, quiet channels, team installs, digests and notification opt-outs
+// from a bolt store into db, which
+// must not hold any entries yet. Pending undos are left behind. It returns
+// the number of entries copied.
+func (db *sqlStore) importBolt(src *store) (int, error) {
+	n := 0
+	err := src.View(func(btx *bolt.Tx) error {
+		return db.update(context.Background(), func(tx sqlTx) error {
+			var existing int
+			err := tx.queryRow("SELECT COUNT(*) FROM entries").Scan(&existing)
+			if err != nil {
+				return err
+			}
+			if existing > 0 {
+				return errNotEmpty
+			}
+			return btx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+				switch s := string(name); {
+				case src.isBacklog(name):
+					key := src.keyOf(name)
+					copied, err := db.importEntries(tx, key, bucket)
+					n += copied
+					return err
+				case strings.HasPrefix(s, "archive:") && src.isBacklog(name[len("archive:"):]):
+					key := archiveKey(src.keyOf(name[len("archive:"):]))
+					_, err := db.importEntries(tx, key, bucket)
+					return err
+				case strings.HasPrefix(s, "history:") && src.isBacklog(name[len("history:"):]):
+					key := src.keyOf(name[len("history:"):])
+					return bucket.ForEach(func(k, v []byte) error {
+						return tx.exec("INSERT INTO history (backlog, data) VALUES (?, ?)", key, string(v))
+					})
+				case bytes.Equal(name, scheduledBucket):
+					err := db.setSequence(tx, "scheduled", bucket.Sequence())
+					if err != nil {
+						return err
+					}
+					return bucket.ForEach(func(k, v []byte) error {
+						var a scheduledAdd
+						err := json.Unmarshal(v, &a)
+						if err != nil {
+							return err
+						}
+						return tx.exec("INSERT INTO scheduled (id, backlog, data) VALUES (?, ?, ?)", itou(k), a.Backlog, string(v))
+					})
+				case bytes.Equal(name, teamsBucket):
+					return bucket.ForEach(func(k, v []byte) error {
+						return tx.exec("INSERT INTO teams (id, data) VALUES (?, ?)", string(k), string(v))
+					})
+				case bytes.Equal(name, teamConfigBucket):
+					return bucket.ForEach(func(k, v []byte) error {
+						return tx.exec("INSERT INTO team_config (id, data) VALUES (?, ?)", string(k), string(v))
+					})
+				case bytes.Equal(name, digestsBucket):
+					return bucket.ForEach(func(k, v []byte) error {
+						return tx.exec("INSERT INTO digests (id, data) VALUES (?, ?)", string(k), string(v))
+					})
+				case bytes.Equal(name, metaBucket):
+					return bucket.ForEach(func(k, v []byte) error {
+						if id, ok := strings.CutPrefix(string(k), "notify-off:"); ok {
+							team, user, _ := strings.Cut(id, "/")
+							return tx.exec("INSERT INTO notify_optout (team, user_id) VALUES (?, ?)", team, user)
+						}
+						id, ok := strings.CutPrefix(string(k), "quiet:")
+						if !ok || time.Unix(int64(itou(v)), 0).Before(time.Now()) {
+							return nil
+						}
+						team, channel, _ := strings.Cut(id, "/")
+						return tx.exec("INSERT INTO quiet_channels (team, channel, until_unix) VALUES (?, ?, ?)", team, channel, int64(itou(v)))
+					})
+				}
+				return nil
+			})
+		})
+	})
+	return n, err
+}
+
+func (db *sqlStore) importEntries(tx sqlTx, key string, bucket *bolt.Bucket) (int, error) {
+	n := 0
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		e, err := decodeEntry(k, v)
+		if err != nil {
+			return err
+		}
+		n++
+		return db.putTx(tx, key, e)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, db.setSequence(tx, key, bucket.Sequence())
+}
+
+func (db *sqlStore) setSequence(tx sqlTx, seq string, n uint64) error {
+	return tx.exec("INSERT INTO sequences (name, n) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET n = excluded.n", seq, n)
+}
+
+// importBoltFile runs the one-shot -import-bolt mode.
+func importBoltFile(dst *sqlStore, path string) {
+	defer dst.Close()
+	src, err := openBolt(path, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+	n, err := dst.importBolt(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("imported bolt database", "path", path, "entries", n)
+}
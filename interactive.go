@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	deleteCallback  = "del"
+	clearCallback   = "clear"
+	amnestyCallback = "amnesty"
+)
+
+type attachment struct {
+	Text       string   `json:"text"`
+	CallbackID string   `json:"callback_id"`
+	Actions    []action `json:"actions"`
+}
+
+type action struct {
+	Name  string `json:"name"`
+	Text  string `json:"text,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+	Style string `json:"style,omitempty"`
+}
+
+type interactionPayload struct {
+	Type        string   `json:"type"`
+	CallbackID  string   `json:"callback_id"`
+	Actions     []action `json:"actions"`
+	ResponseURL string   `json:"response_url"`
+	User        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+}
+
+// confirmDelete asks the user to confirm a delete with buttons that are
+// answered at /interactive.
+func (s *server) confirmDelete(cmd *command, id uint64) (msg, error) {
+	e, err := s.store.Get(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), id)
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", id)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	m := newPrivateMessage("")
+	m.Attachments = []attachment{{
+		Text:       fmt.Sprintf("Take one off %s (%d)?", e.label(), e.ID),
+		CallbackID: deleteCallback,
+		Actions: []action{
+			{Name: "confirm", Text: "Confirm", Type: "button", Value: fmt.Sprint(e.ID), Style: "danger"},
+			{Name: "cancel", Text: "Cancel", Type: "button", Value: "cancel"},
+		},
+	}}
+	return m, nil
+}
+
+// confirmClear asks an admin to confirm wiping the backlog.
+func (s *server) confirmClear(cmd *command, entries []entry) msg {
+	owed := 0
+	for _, e := range entries {
+		owed += e.count()
+	}
+	m := newPrivateMessage("")
+	m.Attachments = []attachment{{
+		Text:       fmt.Sprintf("Clear all %s (%s owed) from the backlog? This can't be undone.", plural(len(entries), "entry", "entries"), plural(owed, "ice cream", "ice creams")),
+		CallbackID: clearCallback,
+		Actions: []action{
+			{Name: "confirm", Text: "Clear the backlog", Type: "button", Value: "clear", Style: "danger"},
+			{Name: "cancel", Text: "Cancel", Type: "button", Value: "cancel"},
+		},
+	}}
+	return m
+}
+
+// confirmAmnesty asks an admin to confirm settling the whole backlog. The
+// note rides along in the button's value.
+func (s *server) confirmAmnesty(cmd *command, entries []entry) msg {
+	m := newPrivateMessage("")
+	m.Attachments = []attachment{{
+		Text:       fmt.Sprintf("Grant amnesty to %s? Everything they owe moves to the ledger.", plural(len(entries), "person", "people")),
+		CallbackID: amnestyCallback,
+		Actions: []action{
+			{Name: "confirm", Text: "Grant amnesty", Type: "button", Value: cmd.args, Style: "primary"},
+			{Name: "cancel", Text: "Cancel", Type: "button", Value: "cancel"},
+		},
+	}}
+	return m
+}
+
+// handleInteractive receives button presses. Confirming a delete, clear or
+// amnesty removes the prompt and posts the result through the response url
+// like any other reply.
+func (s *server) handleInteractive(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.verify(req) {
+		abort(w, http.StatusBadRequest)
+		return
+	}
+	var p interactionPayload
+	err := json.Unmarshal([]byte(req.PostFormValue("payload")), &p)
+	if err != nil {
+		abort(w, http.StatusBadRequest)
+		return
+	}
+	if (p.CallbackID != deleteCallback && p.CallbackID != clearCallback && p.CallbackID != amnestyCallback) || len(p.Actions) != 1 {
+		return
+	}
+	if s.channel != "" && p.Channel.ID != s.channel {
+		return
+	}
+	if p.Actions[0].Name != "confirm" {
+		text := "Cancelled, nothing was changed."
+		if p.CallbackID == deleteCallback {
+			text = "Cancelled, nothing was deleted."
+		}
+		m := newPrivateMessage(text)
+		m.ReplaceOriginal = true
+		err = render(w, m)
+		if err != nil {
+			logger(req.Context()).Error("render failed", "err", err)
+		}
+		return
+	}
+	cmd := &command{
+		ctx:         context.WithoutCancel(req.Context()),
+		name:        p.CallbackID,
+		userID:      p.User.ID,
+		userName:    p.User.Name,
+		teamID:      p.Team.ID,
+		channelID:   p.Channel.ID,
+		responseURL: p.ResponseURL,
+		confirmed:   true,
+	}
+	if cmd.name == deleteCallback || cmd.name == amnestyCallback {
+		cmd.args = p.Actions[0].Value
+	}
+	cmd.ctx = annotate(cmd.ctx, cmd.logFields()...)
+	go s.respondAsync(cmd)
+	err = render(w, msg{DeleteOriginal: true})
+	if err != nil {
+		logger(req.Context()).Error("render failed", "err", err)
+	}
+}
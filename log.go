@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+type (
+	loggerKey struct{}
+	fieldsKey struct{}
+)
+
+func newLogger(format string) (*slog.Logger, error) {
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, nil)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+func logger(ctx context.Context) *slog.Logger {
+	l, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return l
+}
+
+// requestFields are extra fields for the line logged once a request has
+// been served.
+type requestFields struct {
+	args []any
+}
+
+// annotate adds fields to the request's log line and to the logger used
+// for the rest of the request. It must be called from the goroutine
+// serving the request.
+func annotate(ctx context.Context, args ...any) context.Context {
+	if f, ok := ctx.Value(fieldsKey{}).(*requestFields); ok {
+		f.args = append(f.args, args...)
+	}
+	return withLogger(ctx, logger(ctx).With(args...))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// logRequests attaches a logger carrying the request's correlation id to
+// the request context and logs each request once it has been served.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		l := slog.Default().With("request_id", id)
+		fields := &requestFields{}
+		ctx := context.WithValue(req.Context(), fieldsKey{}, fields)
+		req = req.WithContext(withLogger(ctx, l))
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, req)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		args := []any{"method", req.Method, "path", req.URL.Path, "status", sw.status, "duration", time.Since(start)}
+		l.Info("request", append(args, fields.args...)...)
+	})
+}
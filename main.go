@@ -1,243 +1,429 @@
 package main
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
 var (
-	addr   = flag.String("addr", ":9000", "address to listen on")
-	token  = flag.String("token", "", "slack API token")
-	dbPath = flag.String("db-path", "icecream.db", "path to database file")
+	config    = flag.String("config", "", "path to a TOML file of flag names and values, flags and ICECREAM_ environment variables take precedence")
+	addr      = flag.String("addr", ":9000", "address to listen on")
+	drainWait = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight requests on SIGINT or SIGTERM")
+	tlsCert   = flag.String("tls-cert", "", "path to a TLS certificate to serve HTTPS with, requires -tls-key")
+	tlsKey    = flag.String("tls-key", "", "path to the TLS certificate's private key")
+	acmeHosts = flag.String("acme-host", "", "comma separated host names to get Let's Encrypt certificates for, -addr must be reachable on port 443")
+	acmeCache = flag.String("acme-cache", "acme-cache", "directory for caching Let's Encrypt certificates")
+	token     = flag.String("token", "", "slack API token")
+	tokenFile = flag.String("token-file", "", "path to a file containing the slack API token, reloaded on SIGHUP")
+	dbPath    = flag.String("db-path", "icecream.db", "path to database file")
+	storeKind = flag.String("store", "bolt", "storage backend (bolt, postgres, sqlite, memory)")
+	dsn       = flag.String("dsn", "", "connection string for the postgres store or file path for the sqlite store")
+	importDB  = flag.String("import-bolt", "", "copy the bolt database at this path into an empty postgres or sqlite store and exit")
+	restore   = flag.String("restore", "", "backup file or s3://bucket/key URL to install as the bolt database before starting")
+	force     = flag.Bool("force", false, "let -restore replace a database that already has data")
+
+	importPath = flag.String("import", "", "add the entries in this .csv or .json file to the backlog and exit")
+	importChan = flag.String("import-channel", "", "channel id whose backlog -import adds to, with -per-channel")
+	importTeam = flag.String("import-team", "", "team id whose backlog -import adds to, with -client-id")
+
+	apiKey      = flag.String("api-key", "", "bearer key for the /api endpoints, disabled if empty")
+	apiKeyFile  = flag.String("api-key-file", "", "path to a file containing the api key, reloaded on SIGHUP")
+	apiFieldMap = flag.String("api-field-map", "", "comma separated canonical=renamed field names for /api/list, such as name=user")
+	botToken    = flag.String("bot-token", "", "slack bot token for web API calls")
+	clientID    = flag.String("client-id", "", "slack app client id, enables installing into several workspaces with data kept per team, requires -public-url")
+	clientSec   = flag.String("client-secret", "", "slack app client secret for the install flow")
+	clientFile  = flag.String("client-secret-file", "", "path to a file containing the client secret, reloaded on SIGHUP")
+	signing     = flag.String("signing-secret", "", "slack signing secret for verifying requests, the legacy token is still accepted if set")
+	signingFile = flag.String("signing-secret-file", "", "path to a file containing the signing secret, reloaded on SIGHUP")
+	admins      = flag.String("admins", "", "comma separated slack user ids with admin rights")
+	roles       = flag.String("roles", "", "JSON file mapping roles to the commands only they may run, roles limited to their own commands, and user ids to roles")
+	reserved    = flag.String("reserved", "@channel,@here,@everyone", "comma separated names that can't be added")
+	logFormat   = flag.String("log-format", "text", "log format (text, json)")
+	jsonLogs    = flag.Bool("json-logs", false, "same as -log-format=json")
+	async       = flag.Bool("async-responses", false, "acknowledge commands immediately and post results to response_url")
+	asyncSlow   = flag.Bool("async-slow", false, "opt in to acknowledging slow commands such as list, stats and export immediately and posting results to response_url, which must be reachable")
+	respWorkers = flag.Int("response-workers", 4, "background workers running commands answered through response_url")
+	channel     = flag.String("channel", "", "only respond to commands from this channel id")
+	perChannel  = flag.Bool("per-channel", false, "keep a separate backlog for each channel, entries added before enabling stay in the shared backlog")
+	multiTeam   = flag.Bool("multi-team", false, "keep a separate backlog for each slack team, implied by -client-id, entries added before enabling stay in the shared backlog")
+	footer      = flag.String("response-footer", "", "text appended to public messages")
+	threads     = flag.Bool("thread-replies", false, "post each channel's weekly digest as replies in one thread instead of as new messages, mentions are always answered in their thread")
+	outboundMax = flag.Int("outbound-concurrency", 4, "maximum concurrent outbound calls to slack and response urls")
+	drainFile   = flag.String("drain-file", "", "path to a file whose presence puts the bot in maintenance mode, refusing changes")
+
+	backupDir       = flag.String("backup-dir", "", "directory for periodic database backups, disabled if empty")
+	backupS3        = flag.String("backup-s3", "", "s3://bucket/prefix URL for periodic database backups, with credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Endpoint      = flag.String("s3-endpoint", "", "URL of an S3-compatible service for -backup-s3, AWS if empty")
+	s3Region        = flag.String("s3-region", "us-east-1", "region for -backup-s3")
+	backupInterval  = flag.Duration("backup-interval", 24*time.Hour, "time between periodic backups")
+	backupRetention = flag.Duration("backup-retention", 7*24*time.Hour, "age after which periodic backups are pruned, 0 keeps all")
+
+	compactThreshold = flag.Float64("compact-threshold", 0, "free page ratio between 0 and 1 that triggers compaction, 0 disables")
+	compactCooldown  = flag.Duration("compact-cooldown", 6*time.Hour, "minimum time between automatic compactions")
+	compactCheck     = flag.Duration("compact-check", 10*time.Minute, "time between free page checks")
+
+	publicURL       = flag.String("public-url", "", "externally reachable base url of this server")
+	shareSecret     = flag.String("share-secret", "", "secret for signing read-only share links, requires -public-url")
+	shareSecretFile = flag.String("share-secret-file", "", "path to a file containing the share secret, reloaded on SIGHUP")
+	summaryImage    = flag.Bool("summary-image", false, "serve a rendered backlog image at /summary.png, requires -public-url and -share-secret")
+
+	idStart        = flag.Uint64("id-start", 0, "offset for ids in a newly created backlog, the first entry gets id-start+1")
+	bonusChance    = flag.Float64("bonus-chance", 0, "probability between 0 and 1 that an add counts twice")
+	addMessages    = flag.String("add-messages", "", "file of add message templates, one per line, using {{.Name}} and {{.ID}}")
+	plainAdd       = flag.Bool("plain-add", false, "always reply to add with the plain confirmation message")
+	undoWindow     = flag.Duration("undo-window", 5*time.Minute, "how long after an add or delete the user can still undo it")
+	historyKeep    = flag.Duration("history-retention", 0, "age after which history records are deleted, checked hourly, 0 keeps all")
+	confirmDel     = flag.Bool("confirm-deletes", false, "ask for confirmation with buttons before del, requires interactivity pointed at /interactive")
+	warnDuplicates = flag.Bool("warn-duplicates", true, "warn when adding a name that is already on the backlog")
+	notifyAdds     = flag.Bool("notify-added", false, "send a direct message to slack users when they are added, with a welcome the first time, needs a bot token with the im:write scope or oauth")
+	addDebounce    = flag.Duration("add-debounce", 0, "window for batching public add confirmations per channel into one message, 0 disables")
+
+	settleStrategy = flag.String("settle-strategy", "chain", "settle-round strategy (chain, pairs, top)")
 )
 
 func init() {
 	log.SetFlags(0)
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\nEvery flag can also be set from the environment, such as %s for -db-path.\n", envName("db-path"))
+	}
 }
 
 func main() {
 	flag.Parse()
-	if *token == "" {
-		log.Fatalln("token must be set")
+	given := givenFlags()
+	err := loadEnv(given)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *config != "" {
+		err = loadConfig(*config, given)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *jsonLogs {
+		*logFormat = "json"
 	}
-	db, err := bolt.Open(*dbPath, 0660, &bolt.Options{Timeout: 3 * time.Second})
+	l, err := newLogger(*logFormat)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
-	s := &server{
-		token: *token,
-		store: &store{
-			DB:         db,
-			bucketName: []byte("icecream"),
-		},
+	slog.SetDefault(l)
+	if *importDB == "" && *importPath == "" && *token == "" && *tokenFile == "" && *signing == "" && *signingFile == "" {
+		log.Fatalln("signing-secret, token or their -file variants must be set")
+	}
+	var verifyToken *secret
+	if *token != "" || *tokenFile != "" {
+		verifyToken, err = newSecret(*token, *tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *bonusChance < 0 || *bonusChance > 1 {
+		log.Fatalln("bonus-chance must be between 0 and 1")
+	}
+	if *outboundMax < 1 {
+		log.Fatalln("outbound-concurrency must be at least 1")
+	}
+	outbound = make(chan struct{}, *outboundMax)
+	if *compactThreshold < 0 || *compactThreshold > 1 {
+		log.Fatalln("compact-threshold must be between 0 and 1")
+	}
+	if *summaryImage && (*publicURL == "" || *shareSecret == "" && *shareSecretFile == "") {
+		log.Fatalln("summary-image requires public-url and share-secret")
+	}
+	publicFooter = escape(*footer)
+	if *clientID != "" && (*publicURL == "" || *clientSec == "" && *clientFile == "") {
+		log.Fatalln("client-id requires public-url and client-secret")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalln("tls-cert and tls-key must be set together")
+	}
+	if *acmeHosts != "" && *tlsCert != "" {
+		log.Fatalln("acme-host can't be combined with tls-cert")
+	}
+	if (*shareSecret != "" || *shareSecretFile != "") && *publicURL == "" {
+		log.Fatalln("share-secret requires public-url")
+	}
+	if _, ok := settleStrategies[*settleStrategy]; !ok {
+		log.Fatalf("unknown settle strategy %q", *settleStrategy)
+	}
+	var st Store
+	var bst *store
+	switch *storeKind {
+	case "bolt":
+		if *restore != "" {
+			err = restoreBackup(*restore, *dbPath, *force, *s3Endpoint, *s3Region)
+			if err != nil {
+				log.Fatal(err)
+			}
+			slog.Info("restored backup", "from", *restore, "path", *dbPath)
+		}
+		bst, err = openBolt(*dbPath, *idStart)
+		st = bst
+	case "postgres", "sqlite":
+		if *dsn == "" {
+			log.Fatalf("the %s store requires dsn", *storeKind)
+		}
+		d := postgresDialect
+		if *storeKind == "sqlite" {
+			d = sqliteDialect
+		}
+		var sst *sqlStore
+		sst, err = openSQL(d, *dsn, *idStart)
+		if err == nil && *importDB != "" {
+			importBoltFile(sst, *importDB)
+			return
+		}
+		st = sst
+	case "memory":
+		slog.Warn("using the memory store, nothing is kept across restarts")
+		st = newMemStore(*idStart)
+	default:
+		log.Fatalf("unknown store %q", *storeKind)
 	}
-	err = http.ListenAndServe(*addr, s)
 	if err != nil {
 		log.Fatal(err)
 	}
-}
+	if *importDB != "" {
+		log.Fatalln("import-bolt requires the postgres or sqlite store")
+	}
+	if *restore != "" && bst == nil {
+		log.Fatalln("restore requires the bolt store")
+	}
+	defer st.Close()
+	if bst == nil && (*backupDir != "" || *backupS3 != "" || *compactThreshold > 0) {
+		log.Fatalln("backup-dir, backup-s3 and compact-threshold require the bolt store")
+	}
+	m := newMetrics()
+	st = &timedStore{Store: st, metrics: m}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	var backups *backuper
+	if *backupDir != "" || *backupS3 != "" {
+		backups = &backuper{db: bst, dir: *backupDir, retention: *backupRetention}
+		if *backupDir != "" {
+			err = os.MkdirAll(*backupDir, 0770)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *backupS3 != "" {
+			backups.s3, err = newS3Client(*backupS3, *s3Endpoint, *s3Region)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		go backups.run(ctx, *backupInterval)
+	}
+	if *compactThreshold > 0 {
+		c := &compactor{db: bst, threshold: *compactThreshold, cooldown: *compactCooldown}
+		go c.run(ctx, *compactCheck)
+	}
+	go runScheduler(ctx, st)
+	if *historyKeep > 0 {
+		go runHistoryTrimmer(ctx, st, *historyKeep)
+	}
+	s := &server{
+		token:      verifyToken,
+		store:      st,
+		bolt:       bst,
+		settle:     settleStrategies[*settleStrategy],
+		reserved:   make(map[string]bool),
+		admins:     make(map[string]bool),
+		async:      *async,
+		asyncSlow:  *asyncSlow,
+		retries:    newRetryCache(),
+		perChannel: *perChannel,
+		multiTeam:  *multiTeam,
+		undoWindow: *undoWindow,
 
-type store struct {
-	*bolt.DB
-	bucketName []byte
-}
+		confirmDeletes:   *confirmDel,
+		historyRetention: *historyKeep,
+		threads:          *threads,
+		channel:          *channel,
+
+		backupDir:    *backupDir,
+		backups:      backups,
+		publicURL:    strings.TrimSuffix(*publicURL, "/"),
+		summaryImage: *summaryImage,
 
-func (db *store) add(name string) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(db.bucketName)
+		bonusChance:    *bonusChance,
+		random:         rand.Float64,
+		warnDuplicates: *warnDuplicates,
+		notifyAdds:     *notifyAdds,
+		metrics:        m,
+	}
+	if *drainFile != "" {
+		s.drain = newDrainer(*drainFile)
+		go s.drain.run(ctx)
+	}
+	if *addDebounce > 0 {
+		s.batcher = newAddBatcher(*addDebounce)
+	}
+	if !*plainAdd {
+		s.addMessages, err = loadAddMessages(*addMessages)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		id, err := bucket.NextSequence()
+	}
+	for _, name := range strings.Split(*reserved, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			s.reserved[name] = true
+		}
+	}
+	for _, id := range strings.Split(*admins, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			s.admins[id] = true
+		}
+	}
+	if *roles != "" {
+		s.permissions, err = loadPermissions(*roles)
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		return bucket.Put(itob(id), []byte(name))
-	})
-}
-
-func (db *store) del(id uint64) (string, error) {
-	var name []byte
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(db.bucketName)
+	}
+	if *botToken != "" {
+		s.slack = newSlackClient(*botToken)
+		s.botID, err = s.slack.authTest(context.Background())
 		if err != nil {
-			return err
+			log.Fatal(err)
 		}
-		key := itob(id)
-		name = bucket.Get(key)
-		return bucket.Delete(key)
-	})
-	return string(name), err
-}
-
-type user struct {
-	id   uint64
-	name string
-}
-
-func (db *store) list() ([]user, error) {
-	var users []user
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(db.bucketName)
-		if bucket == nil {
-			return fmt.Errorf("bucket %q does not exist", db.bucketName)
-		}
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			u := user{
-				id:   binary.BigEndian.Uint64(k),
-				name: string(v),
-			}
-			users = append(users, u)
+		s.reserved[strings.ToLower(s.botID)] = true
+	}
+	if *apiKey != "" || *apiKeyFile != "" {
+		s.apiKey, err = newSecret(*apiKey, *apiKeyFile)
+		if err != nil {
+			log.Fatal(err)
 		}
-		return nil
-	})
-	return users, err
-}
-
-type server struct {
-	token string
-	store *store
-}
-
-func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if isCertCheck(req) {
-		return
 	}
-	if req.Method != http.MethodPost {
-		abort(w, http.StatusMethodNotAllowed)
-		return
+	if *clientID != "" {
+		clientSecret, err := newSecret(*clientSec, *clientFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.oauth = &oauthConfig{clientID: *clientID, clientSecret: clientSecret}
+		s.multiTeam = true
 	}
-	if req.PostFormValue("token") != s.token {
-		abort(w, http.StatusBadRequest)
+	if *importPath != "" {
+		s.importFile(*importPath, *importTeam, *importChan)
 		return
 	}
-	text := strings.TrimSpace(req.PostFormValue("text"))
-	switch {
-	case text == "help":
-		s.help(w)
-	case text == "list":
-		s.list(w)
-	case strings.HasPrefix(text, "add "):
-		s.add(w, text[4:])
-	case strings.HasPrefix(text, "del "):
-		s.del(w, text[4:])
+	if *signing != "" || *signingFile != "" {
+		s.signingSecret, err = newSecret(*signing, *signingFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-}
-
-func (s *server) help(w http.ResponseWriter) {
-	lines := []string{
-		"*Did someone leave their screen unlocked? Usage:*",
-		"`/icecream add <username>` to add a user to the owing backlog",
-		"`/icecream del <id>` to delete a user by id, use `list` to find id",
-		"`/icecream list` to list owing users",
-		"`/icecream help` to display this usage information",
+	if *shareSecret != "" || *shareSecretFile != "" {
+		s.shareSecret, err = newSecret(*shareSecret, *shareSecretFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	text := strings.Join(lines, "\n")
-	err := render(w, newPrivateMessage(text))
+	s.apiFieldMap, err = parseFieldMap(*apiFieldMap)
 	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+		log.Fatal(err)
 	}
-}
-
-func (s *server) list(w http.ResponseWriter) {
-	users, err := s.store.list()
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+	var clientSecret *secret
+	if s.oauth != nil {
+		clientSecret = s.oauth.clientSecret
 	}
-	lines := make([]string, len(users))
-	for i, u := range users {
-		lines[i] = fmt.Sprintf("%d. %s", u.id, u.name)
+	reloadOnHangup(s.token, s.apiKey, s.signingSecret, s.shareSecret, clientSecret)
+	go s.runDigests(ctx)
+	if *async || *asyncSlow {
+		s.responder = newResponder(s, max(*respWorkers, 1))
 	}
-	text := strings.Join(lines, "\n")
-	if text == "" {
-		text = "The icecream backlog is empty. Tread lightly."
+	srv := &http.Server{Addr: *addr, Handler: logRequests(s.routes())}
+	if *acmeHosts != "" {
+		srv.TLSConfig = autocertConfig(*acmeHosts, *acmeCache)
 	}
-	err = render(w, newPublicMessage(text))
+	err = serve(ctx, srv, *tlsCert, *tlsKey, *drainWait)
+	s.responder.stop(*drainWait)
 	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+		slog.Error("server failed", "err", err)
+		st.Close()
+		os.Exit(1)
 	}
 }
 
-func (s *server) add(w http.ResponseWriter, name string) {
-	err := s.store.add(name)
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+// routes returns the handler for every endpoint the server's settings
+// enable.
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	mux.HandleFunc("/interactive", s.handleInteractive)
+	mux.HandleFunc("/api/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.HandleFunc("/api/list", s.requireAPIKey(s.handleAPIList))
+	mux.HandleFunc("/api/v1/entries", s.requireAPIKey(s.handleEntries))
+	mux.HandleFunc("/api/v1/entries/{id}", s.requireAPIKey(s.handleEntry))
+	mux.HandleFunc("/api/v1/export.csv", s.requireAPIKey(s.handleExportCSV))
+	mux.HandleFunc("/api/v1/import", s.requireAPIKey(s.handleImport))
+	mux.HandleFunc("/api/export/full", s.requireAPIKey(s.handleExportFull))
+	mux.HandleFunc("/api/import/full", s.requireAPIKey(s.handleImportFull))
+	if s.bolt != nil {
+		mux.HandleFunc("/api/v1/backup", s.requireAPIKey(s.handleBackup))
 	}
-	text := fmt.Sprintf("Added %s to the queue.", name)
-	err = render(w, newPublicMessage(text))
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+	if s.summaryImage {
+		mux.HandleFunc("/summary.png", s.handleSummaryImage)
 	}
-}
-
-func (s *server) del(w http.ResponseWriter, id string) {
-	n, err := strconv.ParseUint(id, 10, 64)
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+	if s.oauth != nil {
+		mux.HandleFunc("/oauth/start", s.handleOAuthStart)
+		mux.HandleFunc("/oauth/callback", s.handleOAuthCallback)
 	}
-	name, err := s.store.del(n)
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+	if s.signingSecret != nil && (s.slack != nil || s.oauth != nil) {
+		mux.HandleFunc("/slack/events", s.handleEvents)
 	}
-	text := fmt.Sprintf("Deleted %s (%d) from the queue.", name, n)
-	err = render(w, newPublicMessage(text))
-	if err != nil {
-		abort(w, http.StatusInternalServerError)
-		return
+	if s.shareSecret != nil {
+		mux.HandleFunc("/api/share", s.requireAPIKey(s.handleShareLink))
+		mux.HandleFunc("/shared", s.handleShared)
 	}
+	return mux
 }
 
-func abort(w http.ResponseWriter, code int) {
-	http.Error(w, http.StatusText(code), code)
-}
-
-func isCertCheck(req *http.Request) bool {
-	return req.Method == http.MethodGet && req.PostFormValue("ssl_check") == "1"
-}
-
-func itob(n uint64) []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, n)
-	return b
-}
-
-type msg struct {
-	Type string `json:"response_type"`
-	Text string `json:"text"`
-}
-
-func newPublicMessage(text string) msg {
-	return msg{text, "in_channel"}
-}
-
-func newPrivateMessage(text string) msg {
-	return msg{text, "ephemeral"}
-}
-
-func render(w http.ResponseWriter, v msg) error {
-	b, err := json.Marshal(v)
-	if err != nil {
+// serve runs srv until ctx is done, then stops accepting connections and
+// waits up to timeout for in-flight requests so the store can be closed
+// cleanly afterwards. It serves HTTPS with the certificate files when set
+// or with the server's TLS config.
+func serve(ctx context.Context, srv *http.Server, certFile, keyFile string, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" || srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
 		return err
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		slog.Warn("requests still running at shutdown timeout")
+		return nil
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	_, err = w.Write(b)
 	return err
 }
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memStore keeps everything in memory and loses it on exit. It is meant
+// for tests and demos.
+type memStore struct {
+	mu        sync.Mutex
+	idStart   uint64
+	version   int
+	closed    bool
+	backlogs  map[string]*memBacklog
+	scheduled map[uint64]scheduledAdd
+	schedSeq  uint64
+	quiet     map[string]time.Time
+	teams     map[string]teamInstall
+	teamConf  map[string]teamConfig
+	digests   map[string]digest
+	notifyOff map[string]bool
+}
+
+type memBacklog struct {
+	seq        uint64
+	entries    map[uint64]entry
+	history    []historyRecord
+	archive    []entry
+	archiveSeq uint64
+	undo       map[string]lastChange
+}
+
+var _ Store = (*memStore)(nil)
+
+func newMemStore(idStart uint64) *memStore {
+	return &memStore{
+		idStart:   idStart,
+		backlogs:  make(map[string]*memBacklog),
+		scheduled: make(map[uint64]scheduledAdd),
+		quiet:     make(map[string]time.Time),
+		teams:     make(map[string]teamInstall),
+		teamConf:  make(map[string]teamConfig),
+		digests:   make(map[string]digest),
+		notifyOff: make(map[string]bool),
+	}
+}
+
+// clone copies the entry so callers can't change what is stored through
+// the shared events slice.
+func (e entry) clone() entry {
+	e.Events = slices.Clone(e.Events)
+	return e
+}
+
+// view locks the store for fn, failing once the store is closed. Writes
+// bump the version.
+func (db *memStore) view(ctx context.Context, fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errShuttingDown
+	}
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return fn()
+}
+
+func (db *memStore) update(ctx context.Context, fn func() error) error {
+	return db.view(ctx, func() error {
+		db.version++
+		return fn()
+	})
+}
+
+func (db *memStore) backlog(key string) *memBacklog {
+	b, ok := db.backlogs[key]
+	if !ok {
+		b = &memBacklog{
+			seq:        db.idStart,
+			archiveSeq: db.idStart,
+			entries:    make(map[uint64]entry),
+			undo:       make(map[string]lastChange),
+		}
+		db.backlogs[key] = b
+	}
+	return b
+}
+
+func (b *memBacklog) list() []entry {
+	rv := make([]entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		rv = append(rv, e.clone())
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].ID < rv[j].ID })
+	return rv
+}
+
+func (b *memBacklog) put(e entry) {
+	b.entries[e.ID] = e.clone()
+}
+
+func (b *memBacklog) addEntry(name string, fn func(e *entry)) entry {
+	if e, ok := b.find(name); ok {
+		e.Count = e.count() + 1
+		fn(&e)
+		b.put(e)
+		return e
+	}
+	b.seq++
+	e := entry{ID: b.seq, Name: name, Count: 1}
+	fn(&e)
+	b.put(e)
+	return e
+}
+
+// find returns the entry with the given name, ignoring case.
+func (b *memBacklog) find(name string) (entry, bool) {
+	for _, e := range b.list() {
+		if strings.EqualFold(e.Name, name) {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+func (b *memBacklog) record(action string, e entry, t time.Time, by reporter) {
+	b.history = append(b.history, historyRecord{action, e.ID, e.Name, t, by})
+}
+
+func (b *memBacklog) remember(by reporter, c lastChange) {
+	if by.ID != "" && (len(c.IDs) > 0 || c.Action != "added") {
+		c.Entry = c.Entry.clone()
+		b.undo[by.ID] = c
+	}
+}
+
+func (db *memStore) Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error) {
+	added := make([]entry, len(names))
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		now := time.Now()
+		for i, name := range names {
+			e := b.addEntry(name, func(e *entry) {
+				e.logBy("added", now, by)
+				d.apply(e, now, by)
+			})
+			b.record("added", e, now, by)
+			added[i] = e
+		}
+		b.remember(by, addedChange(added, now))
+		return nil
+	})
+	return added, err
+}
+
+func (db *memStore) Del(ctx context.Context, key string, id uint64, by reporter) (entry, error) {
+	var e entry
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		found, ok := b.entries[id]
+		if !ok {
+			return errNotFound
+		}
+		e = found.clone()
+		now := time.Now()
+		b.record("deleted", e, now, by)
+		b.remember(by, lastChange{Action: "deleted", EntryID: e.ID, Entry: e, Time: now})
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			delete(b.entries, id)
+			return nil
+		}
+		e.logBy("deleted one", now, by)
+		b.put(e)
+		return nil
+	})
+	return e, err
+}
+
+func (db *memStore) Get(ctx context.Context, key string, id uint64) (entry, error) {
+	var e entry
+	err := db.view(ctx, func() error {
+		found, ok := db.backlog(key).entries[id]
+		if !ok {
+			return errNotFound
+		}
+		e = found.clone()
+		return nil
+	})
+	return e, err
+}
+
+func (db *memStore) Modify(ctx context.Context, key string, id uint64, fn func(e *entry) error) (entry, error) {
+	var e entry
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		found, ok := b.entries[id]
+		if !ok {
+			return errNotFound
+		}
+		e = found.clone()
+		err := fn(&e)
+		if err != nil {
+			return err
+		}
+		b.put(e)
+		return nil
+	})
+	return e, err
+}
+
+func (db *memStore) Pin(ctx context.Context, key string, id uint64, pinned bool) (entry, error) {
+	return db.Modify(ctx, key, id, func(e *entry) error {
+		e.setPinned(pinned, time.Now())
+		return nil
+	})
+}
+
+func (db *memStore) Spotlight(ctx context.Context, key string, id uint64, until time.Time) (entry, error) {
+	return db.Modify(ctx, key, id, func(e *entry) error {
+		e.setSpotlight(until, time.Now())
+		return nil
+	})
+}
+
+func (db *memStore) LogEvent(ctx context.Context, key string, id uint64, action string) error {
+	_, err := db.Modify(ctx, key, id, func(e *entry) error {
+		e.log(action, time.Now())
+		return nil
+	})
+	return err
+}
+
+func (db *memStore) List(ctx context.Context, key string) ([]entry, error) {
+	var entries []entry
+	err := db.view(ctx, func() error {
+		if b, ok := db.backlogs[key]; ok {
+			entries = b.list()
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (db *memStore) Pay(ctx context.Context, key string, id uint64, name string, by reporter) (entry, error) {
+	var e entry
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		found := false
+		for _, candidate := range b.list() {
+			if candidate.ID == id || (id == 0 && strings.EqualFold(candidate.Name, name)) {
+				e, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return errNotFound
+		}
+		now := time.Now()
+		e.logBy("paid", now, by)
+		b.record("paid", e, now, by)
+		paid := e.clone()
+		paid.Count = 1
+		b.archiveSeq++
+		paid.ID = b.archiveSeq
+		b.archive = append(b.archive, paid)
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			delete(b.entries, e.ID)
+			return nil
+		}
+		b.put(e)
+		return nil
+	})
+	return e, err
+}
+
+func (db *memStore) Settle(ctx context.Context, key string, ids []uint64, action, note string, by reporter) ([]entry, []uint64, error) {
+	var settled []entry
+	var missing []uint64
+	err := db.update(ctx, func() error {
+		settled, missing = nil, nil
+		b := db.backlog(key)
+		if ids == nil {
+			settled = b.list()
+		}
+		for _, id := range ids {
+			e, ok := b.entries[id]
+			if !ok {
+				missing = append(missing, id)
+				continue
+			}
+			settled = append(settled, e.clone())
+		}
+		now := time.Now()
+		for _, e := range settled {
+			for _, paid := range settleDebts(&e, action, note, now, by) {
+				b.record(action, e, now, by)
+				b.archiveSeq++
+				paid.ID = b.archiveSeq
+				b.archive = append(b.archive, paid)
+			}
+			delete(b.entries, e.ID)
+		}
+		return nil
+	})
+	return settled, missing, err
+}
+
+func (db *memStore) History(ctx context.Context, key string, n int) ([]historyRecord, error) {
+	var rv []historyRecord
+	err := db.view(ctx, func() error {
+		b, ok := db.backlogs[key]
+		if !ok {
+			return nil
+		}
+		for i := len(b.history) - 1; i >= 0 && len(rv) < n; i-- {
+			rv = append(rv, b.history[i])
+		}
+		return nil
+	})
+	return rv, err
+}
+
+func (db *memStore) TrimHistory(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := db.update(ctx, func() error {
+		n = 0
+		for _, b := range db.backlogs {
+			i := 0
+			for i < len(b.history) && b.history[i].Time.Before(before) {
+				i++
+			}
+			b.history = slices.Clone(b.history[i:])
+			n += i
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (db *memStore) Activity(ctx context.Context, key string) ([]historyRecord, []entry, error) {
+	var records []historyRecord
+	var paid []entry
+	err := db.view(ctx, func() error {
+		b, ok := db.backlogs[key]
+		if !ok {
+			return nil
+		}
+		records = slices.Clone(b.history)
+		for _, e := range b.archive {
+			paid = append(paid, e.clone())
+		}
+		return nil
+	})
+	return records, paid, err
+}
+
+func (db *memStore) Undo(ctx context.Context, key string, by reporter, window time.Duration) (lastChange, error) {
+	var last lastChange
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		var ok bool
+		last, ok = b.undo[by.ID]
+		now := time.Now()
+		if !ok || now.Sub(last.Time) > window {
+			return errNothingToUndo
+		}
+		action := "undid " + undoVerb(last.Action)
+		put := func(e entry) {
+			b.record(action, e, now, by)
+			if e.Count == 0 {
+				delete(b.entries, e.ID)
+				return
+			}
+			e.logBy(action, now, by)
+			b.put(e)
+		}
+		if last.Action == "added" {
+			for _, id := range last.IDs {
+				found, ok := b.entries[id]
+				if !ok {
+					continue
+				}
+				e := found.clone()
+				e.Count = e.count() - 1
+				put(e)
+				last.Undone = append(last.Undone, e.Name)
+			}
+			if len(last.Undone) == 0 {
+				return errNothingToUndo
+			}
+			delete(b.undo, by.ID)
+			return nil
+		}
+		var e entry
+		if found, ok := b.entries[last.EntryID]; ok {
+			e = found.clone()
+			e.Count = e.count() + 1
+		} else if found, ok := b.find(last.Entry.Name); ok {
+			e = found
+			e.Count = e.count() + 1
+		} else {
+			e = last.Entry.clone()
+			e.Count = 1
+		}
+		last.EntryID = e.ID
+		delete(b.undo, by.ID)
+		put(e)
+		return nil
+	})
+	return last, err
+}
+
+func (db *memStore) Clear(ctx context.Context, key string, by reporter) ([]entry, error) {
+	var cleared []entry
+	err := db.update(ctx, func() error {
+		b := db.backlog(key)
+		cleared = b.list()
+		now := time.Now()
+		for _, e := range cleared {
+			b.record("cleared", e, now, by)
+		}
+		clear(b.entries)
+		return nil
+	})
+	return cleared, err
+}
+
+func (db *memStore) Migrate(ctx context.Context, from, to string, replace, move bool, by reporter) (int, error) {
+	var entries []entry
+	err := db.update(ctx, func() error {
+		src, dst := db.backlog(from), db.backlog(to)
+		entries = src.list()
+		if len(entries) == 0 {
+			return nil
+		}
+		now := time.Now()
+		if replace {
+			for _, e := range dst.list() {
+				dst.record("cleared", e, now, by)
+			}
+			clear(dst.entries)
+		}
+		for _, e := range entries {
+			if move {
+				delete(src.entries, e.ID)
+				src.record("migrated out", e, now, by)
+			}
+			e.logBy("migrated", now, by)
+			if existing, found := dst.find(e.Name); found {
+				existing.merge(e)
+				e = existing
+			} else {
+				dst.seq++
+				e.ID = dst.seq
+			}
+			dst.put(e)
+			dst.record("migrated in", e, now, by)
+		}
+		return nil
+	})
+	return len(entries), err
+}
+
+func (db *memStore) Schedule(ctx context.Context, key, name string, at time.Time, by reporter) (uint64, error) {
+	var id uint64
+	err := db.update(ctx, func() error {
+		db.schedSeq++
+		id = db.schedSeq
+		db.scheduled[id] = scheduledAdd{ID: id, Backlog: key, Name: name, At: at, ScheduledAt: time.Now(), By: by}
+		return nil
+	})
+	return id, err
+}
+
+// pending returns the scheduled adds in the order they were made.
+func (db *memStore) pending() []scheduledAdd {
+	rv := make([]scheduledAdd, 0, len(db.scheduled))
+	for _, a := range db.scheduled {
+		rv = append(rv, a)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].ID < rv[j].ID })
+	return rv
+}
+
+func (db *memStore) Scheduled(ctx context.Context, key string) ([]scheduledAdd, error) {
+	var rv []scheduledAdd
+	err := db.view(ctx, func() error {
+		for _, a := range db.pending() {
+			if a.Backlog == key {
+				rv = append(rv, a)
+			}
+		}
+		return nil
+	})
+	return rv, err
+}
+
+func (db *memStore) Promote(ctx context.Context, now time.Time) ([]entry, error) {
+	var added []entry
+	err := db.update(ctx, func() error {
+		for _, a := range db.pending() {
+			if a.At.After(now) {
+				continue
+			}
+			b := db.backlog(a.Backlog)
+			e := b.addEntry(a.Name, func(e *entry) {
+				e.logBy("scheduled", a.ScheduledAt, a.By)
+				e.logBy("added", now, a.By)
+			})
+			b.record("added", e, now, a.By)
+			delete(db.scheduled, a.ID)
+			added = append(added, e)
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (db *memStore) ClearSpotlights(ctx context.Context, now time.Time) ([]entry, error) {
+	var cleared []entry
+	err := db.update(ctx, func() error {
+		for _, b := range db.backlogs {
+			for _, e := range b.list() {
+				if e.SpotlightUntil.IsZero() || e.SpotlightUntil.After(now) {
+					continue
+				}
+				e.SpotlightUntil = time.Time{}
+				e.log("spotlight expired", now)
+				b.put(e)
+				cleared = append(cleared, e)
+			}
+		}
+		return nil
+	})
+	return cleared, err
+}
+
+func (db *memStore) QuietUntil(ctx context.Context, team, channel string) (time.Time, error) {
+	var until time.Time
+	err := db.view(ctx, func() error {
+		until = db.quiet[string(quietKey(team, channel))]
+		return nil
+	})
+	return until, err
+}
+
+func (db *memStore) SetQuiet(ctx context.Context, team, channel string, until time.Time) error {
+	return db.update(ctx, func() error {
+		if until.IsZero() {
+			delete(db.quiet, string(quietKey(team, channel)))
+		} else {
+			db.quiet[string(quietKey(team, channel))] = until
+		}
+		return nil
+	})
+}
+
+func (db *memStore) Team(ctx context.Context, id string) (teamInstall, error) {
+	var t teamInstall
+	err := db.view(ctx, func() error {
+		var ok bool
+		t, ok = db.teams[id]
+		if !ok {
+			return errNotFound
+		}
+		return nil
+	})
+	return t, err
+}
+
+func (db *memStore) SaveTeam(ctx context.Context, id string, t teamInstall) error {
+	return db.update(ctx, func() error {
+		db.teams[id] = t
+		return nil
+	})
+}
+
+func (db *memStore) TeamConfig(ctx context.Context, team string) (teamConfig, error) {
+	var c teamConfig
+	err := db.view(ctx, func() error {
+		c = db.teamConf[team]
+		c.Admins = slices.Clone(c.Admins)
+		return nil
+	})
+	return c, err
+}
+
+func (db *memStore) SaveTeamConfig(ctx context.Context, team string, c teamConfig) error {
+	return db.update(ctx, func() error {
+		c.Admins = slices.Clone(c.Admins)
+		db.teamConf[team] = c
+		return nil
+	})
+}
+
+func (db *memStore) Digests(ctx context.Context) ([]digest, error) {
+	var rv []digest
+	err := db.view(ctx, func() error {
+		for _, d := range db.digests {
+			rv = append(rv, d)
+		}
+		return nil
+	})
+	return rv, err
+}
+
+func (db *memStore) SaveDigest(ctx context.Context, d digest) error {
+	return db.update(ctx, func() error {
+		db.digests[digestID(d.Team, d.Channel)] = d
+		return nil
+	})
+}
+
+func (db *memStore) DeleteDigest(ctx context.Context, team, channel string) error {
+	return db.update(ctx, func() error {
+		delete(db.digests, digestID(team, channel))
+		return nil
+	})
+}
+
+func (db *memStore) NotifyOptOut(ctx context.Context, team, user string) (bool, error) {
+	var out bool
+	err := db.view(ctx, func() error {
+		out = db.notifyOff[string(notifyOffKey(team, user))]
+		return nil
+	})
+	return out, err
+}
+
+func (db *memStore) SetNotifyOptOut(ctx context.Context, team, user string, out bool) error {
+	return db.update(ctx, func() error {
+		if out {
+			db.notifyOff[string(notifyOffKey(team, user))] = true
+		} else {
+			delete(db.notifyOff, string(notifyOffKey(team, user)))
+		}
+		return nil
+	})
+}
+
+func (db *memStore) Export(ctx context.Context) (snapshot, error) {
+	data := newSnapshot()
+	teams := snapshotTeams{}
+	err := db.view(ctx, func() error {
+		backlogs := snapshotBacklogs{}
+		for key, b := range db.backlogs {
+			bs := backlogs.get(key)
+			bs.Sequence = b.seq
+			bs.Entries = entrySnapshots(b.list())
+			bs.ArchiveSequence = b.archiveSeq
+			for _, e := range b.archive {
+				bs.Archive = append(bs.Archive, entrySnapshot{e.ID, e.clone()})
+			}
+			bs.History = slices.Clone(b.history)
+		}
+		data.Backlogs = backlogs.sorted()
+		for _, a := range db.pending() {
+			data.Scheduled = append(data.Scheduled, scheduledSnapshot{a.ID, a})
+		}
+		for id, t := range db.teams {
+			teams.get(id).Install = &t
+		}
+		for id, c := range db.teamConf {
+			c.Admins = slices.Clone(c.Admins)
+			teams.get(id).Config = c
+		}
+		for _, d := range db.digests {
+			data.Digests = append(data.Digests, d)
+		}
+		sort.Slice(data.Digests, func(i, j int) bool {
+			return digestID(data.Digests[i].Team, data.Digests[i].Channel) < digestID(data.Digests[j].Team, data.Digests[j].Channel)
+		})
+		for k, until := range db.quiet {
+			team, channel, _ := strings.Cut(strings.TrimPrefix(k, "quiet:"), "/")
+			data.Quiet = append(data.Quiet, quietSnapshot{team, channel, until})
+		}
+		sort.Slice(data.Quiet, func(i, j int) bool {
+			a, b := data.Quiet[i], data.Quiet[j]
+			return a.Team < b.Team || a.Team == b.Team && a.Channel < b.Channel
+		})
+		for k := range db.notifyOff {
+			team, user, _ := strings.Cut(strings.TrimPrefix(k, "notify-off:"), "/")
+			data.NotifyOff = append(data.NotifyOff, notifyOffSnapshot{team, user})
+		}
+		sort.Slice(data.NotifyOff, func(i, j int) bool {
+			a, b := data.NotifyOff[i], data.NotifyOff[j]
+			return a.Team < b.Team || (a.Team == b.Team && a.User < b.User)
+		})
+		return nil
+	})
+	data.Teams = teams.sorted()
+	return data, err
+}
+
+func (db *memStore) Import(ctx context.Context, data snapshot) error {
+	return db.update(ctx, func() error {
+		fresh := newMemStore(db.idStart)
+		for _, bs := range data.Backlogs {
+			b := fresh.backlog(bs.Key)
+			b.seq = bs.Sequence
+			for _, e := range snapshotEntries(bs.Entries) {
+				b.put(e)
+			}
+			b.archiveSeq = bs.ArchiveSequence
+			b.archive = snapshotEntries(bs.Archive)
+			b.history = slices.Clone(bs.History)
+		}
+		for _, a := range data.Scheduled {
+			a.Add.ID = a.ID
+			fresh.scheduled[a.ID] = a.Add
+			fresh.schedSeq = max(fresh.schedSeq, a.ID)
+		}
+		for _, t := range data.Teams {
+			if t.Install != nil {
+				fresh.teams[t.ID] = *t.Install
+			}
+			fresh.teamConf[t.ID] = t.Config
+		}
+		for _, d := range data.Digests {
+			fresh.digests[digestID(d.Team, d.Channel)] = d
+		}
+		for _, q := range data.Quiet {
+			fresh.quiet[string(quietKey(q.Team, q.Channel))] = q.Until
+		}
+		for _, n := range data.NotifyOff {
+			fresh.notifyOff[string(notifyOffKey(n.Team, n.User))] = true
+		}
+		db.backlogs = fresh.backlogs
+		db.scheduled, db.schedSeq = fresh.scheduled, fresh.schedSeq
+		db.quiet, db.teams, db.teamConf = fresh.quiet, fresh.teams, fresh.teamConf
+		db.digests, db.notifyOff = fresh.digests, fresh.notifyOff
+		return nil
+	})
+}
+
+func (db *memStore) Version(ctx context.Context) (int, error) {
+	var v int
+	err := db.view(ctx, func() error {
+		v = db.version
+		return nil
+	})
+	return v, err
+}
+
+func (db *memStore) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closed = true
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// durationBuckets are the upper bounds in seconds of the latency
+// histograms.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// metrics times commands and store calls for the Prometheus /metrics
+// endpoint. A command's time includes the store calls it makes.
+type metrics struct {
+	mu       sync.Mutex
+	commands map[string]*latency
+	store    map[storeCall]*latency
+}
+
+// storeCall labels store latency by the Store method and the command that
+// called it, empty for calls made outside a command.
+type storeCall struct {
+	command string
+	op      string
+}
+
+type commandKey struct{}
+
+// withCommand marks ctx as carrying the named command, so the store calls
+// made under it are labelled with it.
+func withCommand(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, commandKey{}, name)
+}
+
+func commandName(ctx context.Context) string {
+	name, _ := ctx.Value(commandKey{}).(string)
+	return name
+}
+
+// latency counts calls and their failures, with a histogram of how long
+// they took.
+type latency struct {
+	count   uint64
+	errors  uint64
+	buckets []uint64
+	sum     float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{commands: make(map[string]*latency), store: make(map[storeCall]*latency)}
+}
+
+// observe records a command that took d. Only known command names should
+// be passed so the label values stay bounded.
+func (m *metrics) observe(name string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record(m.commands, name, d, failed)
+}
+
+// observeStore records a store call that took d, labelled by the command
+// making it and the Store method.
+func (m *metrics) observeStore(command, op string, d time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record(m.store, storeCall{command, op}, d, failed)
+}
+
+func record[K comparable](by map[K]*latency, label K, d time.Duration, failed bool) {
+	l, ok := by[label]
+	if !ok {
+		l = &latency{buckets: make([]uint64, len(durationBuckets))}
+		by[label] = l
+	}
+	l.count++
+	if failed {
+		l.errors++
+	}
+	l.sum += d.Seconds()
+	for i, le := range durationBuckets {
+		if d.Seconds() <= le {
+			l.buckets[i]++
+		}
+	}
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := sortedLabels(m.commands)
+	fmt.Fprintln(w, "# HELP icecream_commands_total Commands run, by subcommand.")
+	fmt.Fprintln(w, "# TYPE icecream_commands_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "icecream_commands_total{command=%q} %d\n", name, m.commands[name].count)
+	}
+	fmt.Fprintln(w, "# HELP icecream_command_errors_total Commands that failed, by subcommand.")
+	fmt.Fprintln(w, "# TYPE icecream_command_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "icecream_command_errors_total{command=%q} %d\n", name, m.commands[name].errors)
+	}
+	fmt.Fprintln(w, "# HELP icecream_command_duration_seconds Time to run a command including its store calls, by subcommand.")
+	fmt.Fprintln(w, "# TYPE icecream_command_duration_seconds histogram")
+	for _, name := range names {
+		writeHistogram(w, "icecream_command_duration_seconds", fmt.Sprintf("command=%q", name), m.commands[name])
+	}
+	calls := make([]storeCall, 0, len(m.store))
+	for c := range m.store {
+		calls = append(calls, c)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].command != calls[j].command {
+			return calls[i].command < calls[j].command
+		}
+		return calls[i].op < calls[j].op
+	})
+	fmt.Fprintln(w, "# HELP icecream_store_errors_total Store calls that failed, by command and operation.")
+	fmt.Fprintln(w, "# TYPE icecream_store_errors_total counter")
+	for _, c := range calls {
+		fmt.Fprintf(w, "icecream_store_errors_total{command=%q,op=%q} %d\n", c.command, c.op, m.store[c].errors)
+	}
+	fmt.Fprintln(w, "# HELP icecream_store_duration_seconds Time spent in the store, by command and operation. The command is empty for background work.")
+	fmt.Fprintln(w, "# TYPE icecream_store_duration_seconds histogram")
+	for _, c := range calls {
+		writeHistogram(w, "icecream_store_duration_seconds", fmt.Sprintf("command=%q,op=%q", c.command, c.op), m.store[c])
+	}
+}
+
+func sortedLabels(by map[string]*latency) []string {
+	labels := make([]string, 0, len(by))
+	for label := range by {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// writeHistogram writes l as the series name with labels, which are
+// already formatted as key="value" pairs.
+func writeHistogram(w io.Writer, name, labels string, l *latency) {
+	for i, le := range durationBuckets {
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, l.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, l.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, l.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, l.count)
+}
+
+// boltStats returns the database's statistics, the size of the data and
+// the number of entries and debts owed across all backlogs.
+func (db *store) boltStats() (stats bolt.Stats, size int64, entries, owed int, err error) {
+	stats = db.Stats()
+	err = db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if !db.isBacklog(name) {
+				return nil
+			}
+			return bucket.ForEach(func(k, v []byte) error {
+				e, err := decodeEntry(k, v)
+				if err != nil {
+					return nil
+				}
+				entries++
+				owed += e.count()
+				return nil
+			})
+		})
+	})
+	return stats, size, entries, owed, err
+}
+
+func writeGauge(w io.Writer, name, help string, v interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, v)
+}
+
+func writeCounter(w io.Writer, name, help string, v interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, v)
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w)
+	if s.backups != nil {
+		s.backups.writeMetrics(w)
+	}
+	if s.bolt == nil {
+		return
+	}
+	stats, size, entries, owed, err := s.bolt.boltStats()
+	if err != nil {
+		logger(req.Context()).Error("metrics failed", "err", err)
+		return
+	}
+	writeGauge(w, "icecream_entries", "Entries across all backlogs.", entries)
+	writeGauge(w, "icecream_owed", "Ice creams owed across all backlogs.", owed)
+	writeGauge(w, "icecream_bolt_size_bytes", "Size of the data in the bolt database.", size)
+	writeGauge(w, "icecream_bolt_free_pages", "Free pages in the bolt database.", stats.FreePageN)
+	writeGauge(w, "icecream_bolt_open_read_tx", "Open read transactions.", stats.OpenTxN)
+	writeCounter(w, "icecream_bolt_read_tx_total", "Read transactions started.", stats.TxN)
+	writeCounter(w, "icecream_bolt_tx_writes_total", "Pages written by write transactions.", stats.TxStats.GetWrite())
+	writeCounter(w, "icecream_bolt_tx_write_seconds_total", "Time spent writing to disk.", stats.TxStats.GetWriteTime().Seconds())
+	writeCounter(w, "icecream_bolt_tx_splits_total", "Node splits by write transactions.", stats.TxStats.GetSplit())
+	writeCounter(w, "icecream_bolt_tx_spills_total", "Node spills by write transactions.", stats.TxStats.GetSpill())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var schemaVersionKey = []byte("schema-version")
+
+// migration upgrades a database from the version before it to its own.
+// Migrations run inside the transaction that records the new version, so
+// an upgrade that fails leaves the database as it was.
+type migration struct {
+	version int
+	name    string
+	run     func(db *store, tx *bolt.Tx) error
+}
+
+// migrations must stay in version order. Append a migration whenever the
+// way values are encoded changes, rather than teaching the readers every
+// old format.
+var migrations = []migration{
+	{1, "structure plain-name entries", migrateStructuredEntries},
+	{2, "merge duplicate entries into counts", migrateMergeDuplicates},
+	{3, "key quiet channels by team", migrateQuietByTeam},
+}
+
+// schemaVersion is the version this build reads and writes.
+func schemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the database up to the current schema version. A
+// database written by a newer build is refused rather than risk writing
+// values it can't read back.
+func (db *store) migrate() error {
+	return db.Update(db.migrateTx)
+}
+
+func (db *store) migrateTx(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	var version int
+	if v := meta.Get(schemaVersionKey); v != nil {
+		version = int(itou(v))
+	}
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than the %d this build supports", version, schemaVersion())
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		err = m.run(db, tx)
+		if err != nil {
+			return fmt.Errorf("migration %d, %s: %w", m.version, m.name, err)
+		}
+		slog.Info("migrated database", "version", m.version, "migration", m.name)
+		version = m.version
+	}
+	return meta.Put(schemaVersionKey, itob(uint64(version)))
+}
+
+// migrateStructuredEntries rewrites entries written before values were
+// JSON, which held only the name.
+func migrateStructuredEntries(db *store, tx *bolt.Tx) error {
+	return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		if !db.isBacklog(name) {
+			return nil
+		}
+		var legacy []entry
+		err := bucket.ForEach(func(k, v []byte) error {
+			if v == nil || bytes.HasPrefix(v, []byte("{")) {
+				return nil
+			}
+			e, err := decodeEntry(k, v)
+			legacy = append(legacy, e)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range legacy {
+			err = putEntry(bucket, e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func migrateMergeDuplicates(db *store, tx *bolt.Tx) error {
+	merged, err := db.mergeDuplicates(tx)
+	if merged > 0 {
+		slog.Info("merged duplicate entries into counts", "removed", merged)
+	}
+	return err
+}
+
+// migrateQuietByTeam drops quiet modes kept by channel alone. The team
+// they were set in is unknown, and quiet mode is short lived anyway.
+func migrateQuietByTeam(db *store, tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	var stale [][]byte
+	err := meta.ForEach(func(k, v []byte) error {
+		if bytes.HasPrefix(k, []byte("quiet:")) && !bytes.Contains(k, []byte("/")) {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		err = meta.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Migrate copies every entry of the backlog from into the backlog to in a
+// single transaction. An entry for a name to already owes is merged into
+// it, the others get new ids from to's sequence. With replace, to is
+// emptied first, and with move, from is emptied afterwards. It returns the
+// number of entries copied.
+func (db *store) Migrate(ctx context.Context, from, to string, replace, move bool, by reporter) (int, error) {
+	db = db.with(ctx)
+	src, dst := db.backlog(from), db.backlog(to)
+	var entries []entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		source := tx.Bucket(src.name)
+		if source == nil {
+			return nil
+		}
+		err := source.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(k, v)
+			entries = append(entries, e)
+			return err
+		})
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		target, err := dst.createBucket(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if replace {
+			var old []entry
+			err = target.ForEach(func(k, v []byte) error {
+				e, err := decodeEntry(k, v)
+				old = append(old, e)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for _, e := range old {
+				err = dst.record(tx, "cleared", e, now, by)
+				if err == nil {
+					err = target.Delete(itob(e.ID))
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, e := range entries {
+			if move {
+				err = source.Delete(itob(e.ID))
+				if err == nil {
+					err = src.record(tx, "migrated out", e, now, by)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			e.logBy("migrated", now, by)
+			existing, found, err := findEntry(target, e.Name)
+			if err != nil {
+				return err
+			}
+			if found {
+				existing.merge(e)
+				e = existing
+				err = putEntry(target, e)
+			} else {
+				e.ID, err = insertEntry(target, e)
+			}
+			if err == nil {
+				err = dst.record(tx, "migrated in", e, now, by)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(entries), err
+}
+
+// parseChannel returns the id in a channel mention such as
+// <#C123|general>, or an empty string for anything else.
+func parseChannel(s string) string {
+	inner, ok := strings.CutPrefix(s, "<#")
+	if !ok || !strings.HasSuffix(inner, ">") {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimSuffix(inner, ">"), "|")
+	return id
+}
+
+func (s *server) migrateTo(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	if len(cmd.words) != 1 {
+		return msg{}, errUsage
+	}
+	channel := parseChannel(cmd.words[0])
+	if channel == "" {
+		return msg{}, usageErrorf("`%s` isn't a channel mention.", cmd.words[0])
+	}
+	if !s.perChannel {
+		return newPrivateMessage("Every channel shares one backlog, so there is nothing to move."), nil
+	}
+	if channel == cmd.channelID {
+		return newPrivateMessage("That's this channel's own backlog."), nil
+	}
+	_, replace := cmd.option("replace")
+	_, move := cmd.option("clear")
+	from, to := s.backlogKey(cmd.teamID, cmd.channelID), s.backlogKey(cmd.teamID, channel)
+	n, err := s.store.Migrate(cmd.ctx, from, to, replace, move, cmd.reporter())
+	if err != nil {
+		return msg{}, err
+	}
+	if n == 0 {
+		return newPrivateMessage("The backlog is empty, there is nothing to move."), nil
+	}
+	verb := "copied"
+	if move {
+		verb = "moved"
+	}
+	text := fmt.Sprintf("📦 %s %s %s to <#%s>", cmd.reporter(), verb, plural(n, "entry", "entries"), channel)
+	if replace {
+		text += ", replacing its backlog"
+	}
+	return newPublicMessage(text + "."), nil
+}
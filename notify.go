@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func notifyOffKey(team, user string) []byte {
+	return []byte("notify-off:" + team + "/" + user)
+}
+
+// NotifyOptOut reports whether the user asked not to be messaged when
+// they are added.
+func (db *store) NotifyOptOut(ctx context.Context, team, user string) (bool, error) {
+	db = db.with(ctx)
+	var out bool
+	err := db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return nil
+		}
+		out = meta.Get(notifyOffKey(team, user)) != nil
+		return nil
+	})
+	return out, err
+}
+
+func (db *store) SetNotifyOptOut(ctx context.Context, team, user string, out bool) error {
+	db = db.with(ctx)
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if !out {
+			return meta.Delete(notifyOffKey(team, user))
+		}
+		return meta.Put(notifyOffKey(team, user), []byte{1})
+	})
+}
+
+// notifyAdded lets a Slack user know they were added, unless they added
+// themselves or opted out. The message is sent in the background so the
+// add isn't held up by the Slack API.
+func (s *server) notifyAdded(cmd *command, e entry, details addDetails) {
+	_, userID := parseMention(e.Name)
+	if !s.notifyAdds || (s.slack == nil && s.oauth == nil) || userID == "" || userID == cmd.userID {
+		return
+	}
+	ctx := context.WithoutCancel(cmd.ctx)
+	go func() {
+		log := logger(ctx).With("user", userID)
+		out, err := s.store.NotifyOptOut(ctx, cmd.teamID, userID)
+		if err != nil {
+			log.Error("notify failed", "err", err)
+			return
+		}
+		if out {
+			return
+		}
+		client, err := s.slackFor(ctx, cmd.teamID)
+		if err != nil {
+			log.Error("notify failed", "err", err)
+			return
+		}
+		channel, err := client.openIM(ctx, userID)
+		if err != nil {
+			log.Error("notify failed", "err", err)
+			return
+		}
+		m := addedMessage(cmd, e, details)
+		first, err := s.firstTimer(cmd, e)
+		if err != nil {
+			log.Warn("first add check failed", "err", err)
+		}
+		if first {
+			m = welcomeMessage(cmd, e, details)
+		}
+		_, err = s.post(ctx, cmd.teamID, channel, "", m)
+		if err != nil {
+			log.Error("notify failed", "err", err)
+		}
+	}()
+}
+
+// firstTimer reports whether the add of e is the first time the name has
+// been added to the backlog, going by the same history totals as stats.
+func (s *server) firstTimer(cmd *command, e entry) (bool, error) {
+	if len(e.Events) == 0 {
+		return false, nil
+	}
+	added := e.Events[len(e.Events)-1].Time
+	records, _, err := s.store.Activity(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return false, err
+	}
+	n := 0
+	for _, r := range records {
+		if !strings.EqualFold(r.Name, e.Name) || !r.Time.Before(added) {
+			continue
+		}
+		switch r.Action {
+		case "added":
+			n++
+		case "undid add":
+			n--
+		}
+	}
+	return n <= 0, nil
+}
+
+// welcomeMessage is sent instead of addedMessage to someone added for the
+// first time, who may not know the tradition yet.
+func welcomeMessage(cmd *command, e entry, details addDetails) msg {
+	text := fmt.Sprintf("👋 Welcome to the ice cream backlog! %s added you", cmd.reporter())
+	if cmd.channelID != "" {
+		text += fmt.Sprintf(" in <#%s>", cmd.channelID)
+	}
+	text = details.annotate(text + ".")
+	text += "\nIt's a friendly tradition: whoever leaves their screen unlocked owes the team an ice cream. " +
+		"Nobody minds, just bring one in when you can and it comes off the list."
+	text += fmt.Sprintf("\nYou owe %s, `/icecream me` shows what you owe at any time.", plural(e.count(), "ice cream", "ice creams"))
+	text += "\nUse `/icecream notify off` to stop these messages."
+	return newPrivateMessage(text)
+}
+
+func addedMessage(cmd *command, e entry, details addDetails) msg {
+	text := fmt.Sprintf("🍦 %s added you to the ice cream backlog", cmd.reporter())
+	if cmd.channelID != "" {
+		text += fmt.Sprintf(" in <#%s>", cmd.channelID)
+	}
+	text += fmt.Sprintf(", you now owe %s.", plural(e.count(), "ice cream", "ice creams"))
+	text = details.annotate(text)
+	text += "\nUse `/icecream notify off` to stop these messages."
+	return newPrivateMessage(text)
+}
+
+func (s *server) notify(cmd *command) (msg, error) {
+	var out bool
+	switch cmd.args {
+	case "on":
+	case "off":
+		out = true
+	default:
+		return msg{}, errUsage
+	}
+	err := s.store.SetNotifyOptOut(cmd.ctx, cmd.teamID, cmd.userID, out)
+	if err != nil {
+		return msg{}, err
+	}
+	if out {
+		return newPrivateMessage("You won't get a message when someone adds you."), nil
+	}
+	return newPrivateMessage("You'll get a message when someone adds you."), nil
+}
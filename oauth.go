@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	oauthAuthorize   = "https://slack.com/oauth/v2/authorize"
+	oauthScopes      = "commands,chat:write,app_mentions:read,users:read,users:read.email,im:write"
+	oauthStateCookie = "icecream_oauth_state"
+)
+
+var teamsBucket = []byte("teams")
+
+// oauthConfig holds the app credentials used to install into workspaces.
+type oauthConfig struct {
+	clientID     string
+	clientSecret *secret
+}
+
+// teamInstall is a workspace's installation, stored by team id. The bot
+// token is kept in the database in the clear, so the file must be
+// protected accordingly.
+type teamInstall struct {
+	Name        string    `json:"name"`
+	BotToken    string    `json:"bot_token"`
+	BotUserID   string    `json:"bot_user_id"`
+	InstalledBy string    `json:"installed_by"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+func (db *store) SaveTeam(ctx context.Context, id string, t teamInstall) error {
+	db = db.with(ctx)
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(teamsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), b)
+	})
+}
+
+func (db *store) Team(ctx context.Context, id string) (teamInstall, error) {
+	db = db.with(ctx)
+	var t teamInstall
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(teamsBucket)
+		if bucket == nil {
+			return errNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return errNotFound
+		}
+		return json.Unmarshal(v, &t)
+	})
+	return t, err
+}
+
+// slackFor returns a client acting as the bot installed in the team,
+// falling back to the -bot-token client.
+func (s *server) slackFor(ctx context.Context, team string) (*slackClient, error) {
+	if s.oauth == nil || team == "" {
+		if s.slack == nil {
+			return nil, fmt.Errorf("no bot token for team %q", team)
+		}
+		return s.slack, nil
+	}
+	t, err := s.store.Team(ctx, team)
+	if err == errNotFound && s.slack != nil {
+		return s.slack, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("team %s: %w", team, err)
+	}
+	return newSlackClient(t.BotToken), nil
+}
+
+func (s *server) oauthRedirect() string {
+	return s.publicURL + "/oauth/callback"
+}
+
+func (s *server) handleOAuthStart(w http.ResponseWriter, req *http.Request) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/oauth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	v := url.Values{
+		"client_id":    {s.oauth.clientID},
+		"scope":        {oauthScopes},
+		"redirect_uri": {s.oauthRedirect()},
+		"state":        {state},
+	}
+	http.Redirect(w, req, oauthAuthorize+"?"+v.Encode(), http.StatusFound)
+}
+
+// handleOAuthCallback finishes an install by exchanging the code Slack
+// sent back for the workspace's bot token.
+func (s *server) handleOAuthCallback(w http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(oauthStateCookie)
+	state := req.FormValue("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "The install link expired, please start again.", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth/", MaxAge: -1})
+	if e := req.FormValue("error"); e != "" {
+		http.Error(w, "The install was cancelled: "+e, http.StatusBadRequest)
+		return
+	}
+	var r struct {
+		AccessToken string `json:"access_token"`
+		BotUserID   string `json:"bot_user_id"`
+		Team        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"team"`
+		AuthedUser struct {
+			ID string `json:"id"`
+		} `json:"authed_user"`
+	}
+	params := url.Values{
+		"client_id":     {s.oauth.clientID},
+		"client_secret": {s.oauth.clientSecret.load()},
+		"code":          {req.FormValue("code")},
+		"redirect_uri":  {s.oauthRedirect()},
+	}
+	err = newSlackClient("").call(req.Context(), "oauth.v2.access", params, &r)
+	if err != nil {
+		logger(req.Context()).Error("oauth exchange failed", "err", err)
+		http.Error(w, "Slack didn't accept the install, please try again.", http.StatusBadGateway)
+		return
+	}
+	err = s.store.SaveTeam(req.Context(), r.Team.ID, teamInstall{
+		Name:        r.Team.Name,
+		BotToken:    r.AccessToken,
+		BotUserID:   r.BotUserID,
+		InstalledBy: r.AuthedUser.ID,
+		InstalledAt: time.Now(),
+	})
+	if err != nil {
+		logger(req.Context()).Error("saving install failed", "team", r.Team.ID, "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	s.addInstaller(req.Context(), r.Team.ID, r.AuthedUser.ID)
+	logger(req.Context()).Info("installed", "team", r.Team.ID, "by", r.AuthedUser.ID)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "Installed into %s. Try /icecream help in any channel.\n", r.Team.Name)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type object = map[string]interface{}
+
+// schemaNames names the component schemas generated from API types.
+var schemaNames = map[reflect.Type]string{
+	reflect.TypeOf(apiEntry{}):      "Entry",
+	reflect.TypeOf(apiAddRequest{}): "NewEntry",
+	reflect.TypeOf(importRow{}):     "ImportRow",
+	reflect.TypeOf(importError{}):   "ImportError",
+
+	reflect.TypeOf(snapshot{}):          "Snapshot",
+	reflect.TypeOf(backlogSnapshot{}):   "SnapshotBacklog",
+	reflect.TypeOf(entrySnapshot{}):     "SnapshotEntry",
+	reflect.TypeOf(scheduledSnapshot{}): "SnapshotScheduled",
+	reflect.TypeOf(teamSnapshot{}):      "SnapshotTeam",
+	reflect.TypeOf(quietSnapshot{}):     "SnapshotQuiet",
+	reflect.TypeOf(notifyOffSnapshot{}): "SnapshotNotifyOff",
+	reflect.TypeOf(entry{}):             "StoredEntry",
+	reflect.TypeOf(event{}):             "Event",
+	reflect.TypeOf(reporter{}):          "Reporter",
+	reflect.TypeOf(historyRecord{}):     "HistoryRecord",
+	reflect.TypeOf(scheduledAdd{}):      "ScheduledAdd",
+	reflect.TypeOf(teamInstall{}):       "TeamInstall",
+	reflect.TypeOf(teamConfig{}):        "TeamConfig",
+	reflect.TypeOf(digest{}):            "Digest",
+}
+
+// schemas builds JSON schemas from Go types using their json tags, so the
+// document follows the structs the handlers encode.
+type schemas map[string]object
+
+func (c schemas) of(t reflect.Type) object {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return object{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return object{"type": "string", "format": "byte"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return c.of(t.Elem())
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Slice:
+		return object{"type": "array", "items": c.of(t.Elem())}
+	case reflect.Struct:
+		name := schemaNames[t]
+		ref := object{"$ref": "#/components/schemas/" + name}
+		if _, ok := c[name]; ok {
+			return ref
+		}
+		// Claim the name before recursing so self-referencing types
+		// such as Bucket terminate.
+		c[name] = nil
+		props := object{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("json")
+			field, opts, _ := strings.Cut(tag, ",")
+			if field == "" || field == "-" {
+				continue
+			}
+			props[field] = c.of(t.Field(i).Type)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+				required = append(required, field)
+			}
+		}
+		c[name] = object{"type": "object", "properties": props, "required": required}
+		return ref
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// renameFields applies the -api-field-map renames to an object schema.
+func renameFields(schema object, rename map[string]string) {
+	props := schema["properties"].(object)
+	required := schema["required"].([]string)
+	for from, to := range rename {
+		props[to] = props[from]
+		delete(props, from)
+		for i, f := range required {
+			if f == from {
+				required[i] = to
+			}
+		}
+	}
+}
+
+func jsonBody(schema object) object {
+	return object{"content": object{"application/json": object{"schema": schema}}}
+}
+
+func jsonResponse(description string, schema object) object {
+	r := jsonBody(schema)
+	r["description"] = description
+	return r
+}
+
+func (s *server) openAPI() object {
+	c := schemas{}
+	entry := c.of(reflect.TypeOf(apiEntry{}))
+	entries := object{"type": "array", "items": entry}
+	renameFields(c["Entry"], s.apiFieldMap)
+	secured := []object{{"bearer": []string{}}}
+	backlog := []object{
+		{"name": "team", "in": "query", "schema": object{"type": "string"}, "description": "Team id of the backlog when installed into several workspaces"},
+		{"name": "channel", "in": "query", "schema": object{"type": "string"}, "description": "Channel id of the backlog when backlogs are kept per channel"},
+	}
+	list := object{
+		"summary":    "List the entries on the backlog",
+		"security":   secured,
+		"parameters": backlog,
+		"responses":  object{"200": jsonResponse("The entries in id order", entries)},
+	}
+	paths := object{
+		"/api/list": object{"get": list},
+		"/api/v1/entries": object{
+			"get": list,
+			"post": object{
+				"summary":     "Add one to the count of the named entry, creating it if needed",
+				"security":    secured,
+				"requestBody": jsonBody(c.of(reflect.TypeOf(apiAddRequest{}))),
+				"responses": object{
+					"201": jsonResponse("The entry as it now stands", entry),
+					"400": object{"description": "Missing name"},
+					"422": object{"description": "Reserved name"},
+				},
+			},
+		},
+		"/api/v1/entries/{id}": object{"delete": object{
+			"summary":  "Take one off the entry's count, removing it when nothing is left",
+			"security": secured,
+			"parameters": append([]object{
+				{"name": "id", "in": "path", "required": true, "schema": object{"type": "integer"}},
+			}, backlog...),
+			"responses": object{
+				"204": object{"description": "Deleted"},
+				"404": object{"description": "No such entry"},
+			},
+		}},
+		"/api/v1/export.csv": object{"get": object{
+			"summary":    "Export the entries on the backlog as CSV",
+			"security":   secured,
+			"parameters": backlog,
+			"responses": object{"200": object{
+				"description": "A header row and a row per entry",
+				"content":     object{"text/csv": object{"schema": object{"type": "string"}}},
+			}},
+		}},
+		"/api/v1/import": object{"post": object{
+			"summary":    "Add entries to the backlog from CSV or JSON, nothing is added unless every row is valid",
+			"security":   secured,
+			"parameters": backlog,
+			"requestBody": object{"content": object{
+				"application/json": object{"schema": object{"type": "array", "items": c.of(reflect.TypeOf(importRow{}))}},
+				"text/csv":         object{"schema": object{"type": "string"}},
+			}},
+			"responses": object{
+				"200": jsonResponse("Imported", object{
+					"type":       "object",
+					"properties": object{"imported": object{"type": "integer"}},
+				}),
+				"400": object{"description": "Unreadable CSV or JSON"},
+				"422": jsonResponse("Rows that failed validation", object{
+					"type":       "object",
+					"properties": object{"errors": object{"type": "array", "items": c.of(reflect.TypeOf(importError{}))}},
+				}),
+				"503": object{"description": "Shutting down or in maintenance"},
+			},
+		}},
+	}
+	export := c.of(reflect.TypeOf(snapshot{}))
+	paths["/api/export/full"] = object{"get": object{
+		"summary":   "Export everything the store holds, in the same shape for every backend",
+		"security":  secured,
+		"responses": object{"200": jsonResponse("The full export", export)},
+	}}
+	paths["/api/import/full"] = object{"post": object{
+		"summary":     "Replace everything the store holds with a full export",
+		"security":    secured,
+		"requestBody": jsonBody(export),
+		"responses": object{
+			"204": object{"description": "Imported"},
+			"400": object{"description": "Invalid export"},
+			"413": object{"description": "Export too large"},
+			"503": object{"description": "Shutting down or in maintenance"},
+		},
+	}}
+	if s.bolt != nil {
+		paths["/api/v1/backup"] = object{"get": object{
+			"summary":  "Download a consistent snapshot of the bolt database while it keeps serving",
+			"security": secured,
+			"responses": object{
+				"200": object{
+					"description": "The database file",
+					"content":     object{"application/octet-stream": object{"schema": object{"type": "string", "format": "binary"}}},
+				},
+				"503": object{"description": "Shutting down"},
+			},
+		}}
+	}
+	if s.shareSecret != nil {
+		paths["/api/share"] = object{"get": object{
+			"summary":  "Create a signed read-only link to the backlog",
+			"security": secured,
+			"parameters": []object{
+				{"name": "channel", "in": "query", "schema": object{"type": "string"}},
+				{"name": "ttl", "in": "query", "schema": object{"type": "string"}, "description": "Link lifetime such as 24h or 7d"},
+			},
+			"responses": object{"200": jsonResponse("The share link", object{
+				"type":       "object",
+				"properties": object{"url": object{"type": "string"}},
+			})},
+		}}
+	}
+	return object{
+		"openapi": "3.0.3",
+		"info":    object{"title": "icecream", "version": "1"},
+		"paths":   paths,
+		"components": object{
+			"schemas":         c,
+			"securitySchemes": object{"bearer": object{"type": "http", "scheme": "bearer"}},
+		},
+	}
+}
+
+func (s *server) handleOpenAPI(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(s.openAPI())
+	if err != nil {
+		logger(req.Context()).Error("openapi failed", "err", err)
+	}
+}
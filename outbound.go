@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	rateLimitRetries = 3
+	// maxRetryAfter bounds the total time a request waits out rate
+	// limits before the 429 is handed back to the caller.
+	maxRetryAfter = time.Minute
+)
+
+// outbound bounds the number of concurrent calls to Slack and webhooks.
+var outbound = make(chan struct{}, 4)
+
+// sendOutbound sends req once a slot is free. When Slack answers 429 the
+// slot is released while waiting out Retry-After, then the request is
+// sent again, unless the wait would take the total past maxRetryAfter.
+func sendOutbound(client *http.Client, req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	for i := 0; ; i++ {
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		select {
+		case outbound <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		resp, err := client.Do(req)
+		<-outbound
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || i == rateLimitRetries {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header.Get("Retry-After"))
+		if waited+wait > maxRetryAfter {
+			slog.Warn("rate limited for too long, giving up", "host", req.URL.Host, "wait", wait, "waited", waited)
+			return resp, nil
+		}
+		waited += wait
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		slog.Warn("rate limited, backing off", "host", req.URL.Host, "wait", wait, "attempt", i+1)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryAfter parses a Retry-After value in seconds, falling back to one
+// second.
+func retryAfter(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return time.Second
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// is reports whether the reporter is the user given as a command argument,
+// by id when it resolved to one and by user name otherwise.
+func (r reporter) is(name, userID string) bool {
+	if userID != "" {
+		return r.ID == userID
+	}
+	return r.Name != "" && strings.EqualFold(r.Name, strings.TrimPrefix(name, "@"))
+}
+
+// between lists the adds on the backlog by one user of another.
+func (s *server) between(cmd *command) (msg, error) {
+	if len(cmd.words) != 2 {
+		return msg{}, errUsage
+	}
+	by, byID := s.parseUser(cmd.ctx, cmd.teamID, cmd.words[0])
+	name, _ := s.parseUser(cmd.ctx, cmd.teamID, cmd.words[1])
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	var lines []string
+	for _, e := range entries {
+		if !strings.EqualFold(e.Name, name) {
+			continue
+		}
+		for _, ev := range e.Events {
+			if ev.Action == "added" && ev.By.is(by, byID) {
+				lines = append(lines, fmt.Sprintf("• %d. %s, %s ago", e.ID, ev.Time.Format(timeFormat), humanize(time.Since(ev.Time))))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return newPrivateMessage(fmt.Sprintf("Nothing on the backlog was added by %s for %s.", by, name)), nil
+	}
+	head := fmt.Sprintf("%s added %s %s:", by, name, plural(len(lines), "time", "times"))
+	return newPrivateMessage(head + "\n" + strings.Join(lines, "\n")), nil
+}
+
+// rivalry counts from the history how often each of two users added the
+// other, less the adds that were undone.
+func (s *server) rivalry(cmd *command) (msg, error) {
+	if len(cmd.words) != 2 {
+		return msg{}, errUsage
+	}
+	a, aID := s.parseUser(cmd.ctx, cmd.teamID, cmd.words[0])
+	b, bID := s.parseUser(cmd.ctx, cmd.teamID, cmd.words[1])
+	if strings.EqualFold(a, b) {
+		return msg{}, usageErrorf("A rivalry takes two different people.")
+	}
+	records, _, err := s.store.Activity(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	var ab, ba int
+	for _, r := range records {
+		n := 0
+		switch r.Action {
+		case "added":
+			n = 1
+		case "undid add":
+			n = -1
+		}
+		switch {
+		case r.By.is(a, aID) && strings.EqualFold(r.Name, b):
+			ab += n
+		case r.By.is(b, bID) && strings.EqualFold(r.Name, a):
+			ba += n
+		}
+	}
+	if ab <= 0 && ba <= 0 {
+		return newPrivateMessage(fmt.Sprintf("%s and %s have never added each other.", a, b)), nil
+	}
+	text := fmt.Sprintf("⚔️ %s has added %s %s, %s has added %s %s.", a, b, plural(max(ab, 0), "time", "times"), b, a, plural(max(ba, 0), "time", "times"))
+	switch {
+	case ab > ba:
+		text += fmt.Sprintf(" %s leads the rivalry.", a)
+	case ba > ab:
+		text += fmt.Sprintf(" %s leads the rivalry.", b)
+	default:
+		text += " It's a tie."
+	}
+	return newPublicMessage(text), nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// archiveName is the bucket holding the backlog's settled debts.
+func (db backlog) archiveName() []byte {
+	return []byte("archive:" + string(db.name))
+}
+
+// pay settles one debt of the entry with the given id, or with the given
+// name when id is zero. The settled debt is recorded in the archive and the
+// entry leaves the backlog once nothing is owed. It returns the entry as it
+// now stands.
+func (db backlog) pay(id uint64, name string, by reporter) (entry, error) {
+	var e entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return errNotFound
+		}
+		var key []byte
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			found, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			if found.ID == id || (id == 0 && strings.EqualFold(found.Name, name)) {
+				e, key = found, k
+				break
+			}
+		}
+		if key == nil {
+			return errNotFound
+		}
+		archive, err := tx.CreateBucketIfNotExists(db.archiveName())
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		e.logBy("paid", now, by)
+		err = db.record(tx, "paid", e, now, by)
+		if err != nil {
+			return err
+		}
+		paid := e
+		paid.Count = 1
+		_, err = insertEntry(archive, paid)
+		if err != nil {
+			return err
+		}
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			return bucket.Delete(key)
+		}
+		return putEntry(bucket, e)
+	})
+	return e, err
+}
+
+// settleDebts logs one settlement for each debt e owes and returns the
+// entry as archived for each, carrying the events up to its own.
+func settleDebts(e *entry, action, note string, t time.Time, by reporter) []entry {
+	n := e.count()
+	rv := make([]entry, n)
+	for i := range rv {
+		e.logBy(action, t, by)
+		rv[i] = e.clone()
+		rv[i].Count = 1
+		rv[i].Note = note
+	}
+	return rv
+}
+
+// settle moves every debt of the entries with the given ids, or of all
+// entries when ids is nil, into the archive in one transaction. It returns
+// the entries as they stood and the ids that aren't on the backlog.
+func (db backlog) settle(ids []uint64, action, note string, by reporter) ([]entry, []uint64, error) {
+	var settled []entry
+	var missing []uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		settled, missing = nil, nil
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			missing = ids
+			return nil
+		}
+		if ids == nil {
+			err := bucket.ForEach(func(k, v []byte) error {
+				e, err := decodeEntry(k, v)
+				settled = append(settled, e)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			v := bucket.Get(itob(id))
+			if v == nil {
+				missing = append(missing, id)
+				continue
+			}
+			e, err := decodeEntry(itob(id), v)
+			if err != nil {
+				return err
+			}
+			settled = append(settled, e)
+		}
+		if len(settled) == 0 {
+			return nil
+		}
+		archive, err := tx.CreateBucketIfNotExists(db.archiveName())
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, e := range settled {
+			for _, paid := range settleDebts(&e, action, note, now, by) {
+				err = db.record(tx, action, e, now, by)
+				if err != nil {
+					return err
+				}
+				_, err = insertEntry(archive, paid)
+				if err != nil {
+					return err
+				}
+			}
+			err = bucket.Delete(itob(e.ID))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return settled, missing, err
+}
+
+func (s *server) pay(cmd *command) (msg, error) {
+	if cmd.args == "" {
+		return msg{}, errUsage
+	}
+	id, err := strconv.ParseUint(cmd.args, 10, 64)
+	name := cmd.args
+	if err != nil {
+		id = 0
+		name, _ = s.parseUser(cmd.ctx, cmd.teamID, cmd.args)
+	}
+	e, err := s.store.Pay(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), id, name, cmd.reporter())
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", id)
+		if id == 0 {
+			text = fmt.Sprintf("%s isn't on the backlog.", name)
+		}
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("✅ %s (%d) is all paid up and off the list.", e.Name, e.ID)
+	if e.Count > 0 {
+		text = fmt.Sprintf("✅ %s (%d) paid one off, %d still owed.", e.Name, e.ID, e.Count)
+	}
+	return newPublicMessage(text), nil
+}
+
+// paid settles everything owed on several entries at once, or on the whole
+// backlog with `all`. With --dry-run it only says what would be settled.
+func (s *server) paid(cmd *command) (msg, error) {
+	if len(cmd.words) == 0 {
+		return msg{}, errUsage
+	}
+	var ids []uint64
+	if len(cmd.words) != 1 || cmd.words[0] != "all" {
+		for _, w := range cmd.words {
+			id, err := strconv.ParseUint(w, 10, 64)
+			if err != nil {
+				return msg{}, usageErrorf("`%s` isn't an id.", w)
+			}
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	var settled []entry
+	var missing []uint64
+	var err error
+	_, dryRun := cmd.option("dry-run")
+	if dryRun {
+		settled, missing, err = s.previewSettle(cmd.ctx, key, ids)
+	} else {
+		settled, missing, err = s.store.Settle(cmd.ctx, key, ids, "paid", "", cmd.reporter())
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	var notFound string
+	if len(missing) > 0 {
+		strs := make([]string, len(missing))
+		for i, id := range missing {
+			strs[i] = strconv.FormatUint(id, 10)
+		}
+		notFound = fmt.Sprintf("There is no entry with id %s.", strs[0])
+		if len(strs) > 1 {
+			notFound = fmt.Sprintf("There are no entries with ids %s.", englishList(strs))
+		}
+	}
+	if len(settled) == 0 {
+		if notFound != "" {
+			return newPrivateMessage(notFound), nil
+		}
+		return newPrivateMessage("The backlog is empty, there is nothing to settle."), nil
+	}
+	n := 0
+	labels := make([]string, len(settled))
+	for i, e := range settled {
+		n += e.count()
+		labels[i] = fmt.Sprintf("%s (%d)", e.label(), e.ID)
+	}
+	text := fmt.Sprintf("✅ %s settled %s: %s.", cmd.reporter(), plural(n, "ice cream", "ice creams"), englishList(labels))
+	if dryRun {
+		text = fmt.Sprintf("Dry run, this would settle %s: %s.", plural(n, "ice cream", "ice creams"), englishList(labels))
+	}
+	if notFound != "" {
+		text += " " + notFound
+	}
+	if dryRun {
+		return newPrivateMessage(text), nil
+	}
+	return newPublicMessage(text), nil
+}
+
+// previewSettle returns what Settle would, without changing anything.
+func (s *server) previewSettle(ctx context.Context, key string, ids []uint64) ([]entry, []uint64, error) {
+	entries, err := s.store.List(ctx, key)
+	if ids == nil || err != nil {
+		return entries, nil, err
+	}
+	var found []entry
+	var missing []uint64
+	for _, id := range ids {
+		i := slices.IndexFunc(entries, func(e entry) bool { return e.ID == id })
+		if i < 0 {
+			missing = append(missing, id)
+			continue
+		}
+		found = append(found, entries[i])
+	}
+	return found, missing, nil
+}
+
+// amnesty forgives everything owed on the backlog, moving it all to the
+// ledger under one note rather than clearing it.
+func (s *server) amnesty(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	_, dryRun := cmd.option("dry-run")
+	if !cmd.confirmed {
+		entries, err := s.store.List(cmd.ctx, key)
+		if err != nil {
+			return msg{}, err
+		}
+		if len(entries) == 0 {
+			return newPrivateMessage("The backlog is already empty, nobody needs an amnesty."), nil
+		}
+		if !dryRun {
+			return s.confirmAmnesty(cmd, entries), nil
+		}
+		n := 0
+		labels := make([]string, len(entries))
+		for i, e := range entries {
+			n += e.count()
+			labels[i] = e.label()
+		}
+		text := fmt.Sprintf("Dry run, amnesty would settle %s owed by %s: %s.", plural(n, "ice cream", "ice creams"), plural(len(entries), "person", "people"), englishList(labels))
+		return newPrivateMessage(text), nil
+	}
+	settled, _, err := s.store.Settle(cmd.ctx, key, nil, "amnesty", cmd.args, cmd.reporter())
+	if err != nil {
+		return msg{}, err
+	}
+	if len(settled) == 0 {
+		return newPrivateMessage("The backlog is already empty, nobody needs an amnesty."), nil
+	}
+	text := fmt.Sprintf("🎉 Amnesty granted to %s!", plural(len(settled), "person", "people"))
+	if cmd.args != "" {
+		text += fmt.Sprintf(" _%s_", escape(cmd.args))
+	}
+	return newPublicMessage(text), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	responseRetries = 3
+	responseBackoff = 500 * time.Millisecond
+	responseQueue   = 64
+)
+
+var responseClient = &http.Client{Timeout: 5 * time.Second}
+
+// slowCommands can take longer than the three seconds Slack waits for a
+// reply on a big backlog, so they are answered through response_url.
+var slowCommands = map[string]bool{
+	"list": true, "stats": true, "history": true, "heatmap": true, "dwell": true,
+	"export": true, "export-md": true, "diff": true, "fsck": true,
+}
+
+// responder runs commands in the background on a fixed number of workers
+// and posts their replies to response_url.
+type responder struct {
+	s     *server
+	queue chan *command
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newResponder(s *server, workers int) *responder {
+	r := &responder{s: s, queue: make(chan *command, responseQueue)}
+	for range workers {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for cmd := range r.queue {
+				s.respondAsync(cmd)
+			}
+		}()
+	}
+	return r
+}
+
+// enqueue hands the command to a worker. It returns false when the queue
+// is full or stopped, and the caller should answer inline instead.
+func (r *responder) enqueue(cmd *command) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return false
+	}
+	select {
+	case r.queue <- cmd:
+		return true
+	default:
+		logger(cmd.ctx).Warn("response queue full, answering inline", "command", cmd.name)
+		return false
+	}
+}
+
+// stop lets the workers finish what is queued, waiting up to timeout so
+// the store can be closed after them.
+func (r *responder) stop(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("async responses still running at shutdown timeout")
+	}
+}
+
+// respondAsync runs the command and posts its reply. The request that
+// carried the command has usually been answered by now, so the command
+// runs detached from its cancellation.
+func (s *server) respondAsync(cmd *command) {
+	cmd.ctx = context.WithoutCancel(cmd.ctx)
+	ctx := cmd.ctx
+	m, err := s.runRecovered(cmd)
+	if err == errUnknownCommand {
+		return
+	}
+	if err == errShuttingDown {
+		m = newPrivateMessage("The service is shutting down, please try again shortly.")
+	}
+	err = postResponse(ctx, cmd.responseURL, m)
+	if err != nil {
+		logger(ctx).Error("async response lost, user saw no reply", "err", err)
+	}
+}
+
+// runRecovered runs the command, turning a panic into an apology. Inline
+// commands get this from net/http, on a worker a panic would end the
+// process.
+func (s *server) runRecovered(cmd *command) (m msg, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger(cmd.ctx).Error("command panicked", "command", cmd.name, "panic", p, "stack", string(debug.Stack()))
+			m, err = newPrivateMessage("Something went wrong, please try again."), nil
+		}
+	}()
+	return s.run(cmd)
+}
+
+func postResponse(ctx context.Context, url string, m msg) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	backoff := responseBackoff
+	for i := 0; ; i++ {
+		err = postJSON(ctx, url, b)
+		var se statusError
+		if err == nil || i == responseRetries || errors.As(err, &se) && se.code < 500 {
+			return err
+		}
+		logger(ctx).Warn("async response failed, retrying", "attempt", i+1, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postJSON(ctx context.Context, url string, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := sendOutbound(responseClient, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError{resp.StatusCode, resp.Status}
+	}
+	return nil
+}
+
+// statusError is an unexpected HTTP status. Only server errors are worth
+// retrying, an expired or invalid response_url stays that way.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s", e.status)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		async, slow bool
+		text        string
+		inline      bool
+	}{
+		{"sync add", false, false, "add alice", true},
+		{"sync list", false, false, "list", true},
+		{"async add", true, false, "add alice", false},
+		{"async list", true, false, "list", false},
+		{"slow only add", false, true, "add alice", true},
+		{"slow only list", false, true, "list", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(s *server) {
+				s.async = tt.async
+				s.asyncSlow = tt.slow
+				if tt.async || tt.slow {
+					s.responder = newResponder(s, 2)
+				}
+			})
+			defer ts.s.responder.stop(time.Second)
+			hook, replies := newResponseHook(t)
+			form := slashForm("U1", tt.text)
+			form.Set("response_url", hook)
+			code, m := ts.post(t, form, nil)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d", code)
+			}
+			if tt.inline {
+				if m.Text == "" {
+					t.Error("no reply in the response")
+				}
+				select {
+				case m := <-replies:
+					t.Errorf("also posted to response_url: %q", m.Text)
+				case <-time.After(100 * time.Millisecond):
+				}
+				return
+			}
+			if m.Text != "" {
+				t.Errorf("replied inline with %q", m.Text)
+			}
+			select {
+			case m := <-replies:
+				if m.Text == "" {
+					t.Error("empty reply posted to response_url")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("nothing posted to response_url")
+			}
+		})
+	}
+}
+
+func TestPostResponseExpired(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		http.Error(w, "expired_url", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	err := postResponse(context.Background(), srv.URL, newPrivateMessage("hi"))
+	var se statusError
+	if !errors.As(err, &se) || se.code != http.StatusNotFound {
+		t.Fatalf("postResponse() error = %v, want a 404 status error", err)
+	}
+	if calls != 1 {
+		t.Errorf("posted %d times, an expired response_url shouldn't be retried", calls)
+	}
+}
+
+// newFakeSlack serves the Slack API methods a test needs, answering any
+// other method with ok.
+func newFakeSlack(t *testing.T, methods map[string]string) *slackClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, ok := methods[strings.TrimPrefix(req.URL.Path, "/")]
+		if !ok {
+			body = `{"ok":true}`
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &slackClient{token: "xoxb-test", base: srv.URL + "/", client: srv.Client()}
+}
+
+func TestRespondAsyncAfterCancel(t *testing.T) {
+	ts := newTestServer(t, func(s *server) {
+		s.slack = newFakeSlack(t, map[string]string{
+			"users.list": `{"ok":true,"members":[{"id":"U2","name":"bob"}]}`,
+		})
+	})
+	hook, replies := newResponseHook(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd := &command{ctx: ctx, userID: "U1", userName: "u1", teamID: "T1", channelID: "C1", responseURL: hook}
+	cmd.parseText("add @bob")
+	ts.s.respondAsync(cmd)
+	select {
+	case <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing posted to response_url")
+	}
+	if entries := ts.entries(t); len(entries) != 1 || entries[0].Name != "<@U2>" {
+		t.Errorf("entries = %+v, want @bob resolved to <@U2>", entries)
+	}
+}
+
+// panicStore fails every List the way a bug in a handler would.
+type panicStore struct {
+	Store
+}
+
+func (panicStore) List(ctx context.Context, key string) ([]entry, error) {
+	panic("list exploded")
+}
+
+func TestRespondAsyncRecovers(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.store = panicStore{s.store} })
+	hook, replies := newResponseHook(t)
+	cmd := &command{ctx: context.Background(), userID: "U1", teamID: "T1", channelID: "C1", responseURL: hook}
+	cmd.parseText("list")
+	ts.s.respondAsync(cmd)
+	select {
+	case m := <-replies:
+		wantText(t, m, "Something went wrong")
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing posted to response_url")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// restoreBackup installs the backup at src, a file path or an
+// s3://bucket/key URL, as the bolt database at path. The backup is copied
+// next to the database and checked before it replaces anything, and a
+// database holding entries is only replaced when forced.
+func restoreBackup(src, path string, force bool, s3Endpoint, s3Region string) error {
+	tmp := path + ".restore"
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+	err := fetchBackup(src, tmp, s3Endpoint, s3Region)
+	if err != nil {
+		return err
+	}
+	err = verifyBackup(tmp)
+	if err != nil {
+		return fmt.Errorf("%s isn't a usable backup: %w", src, err)
+	}
+	if !force {
+		empty, err := boltEmpty(path)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("%s already has data, use -force to replace it", path)
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+func fetchBackup(src, dst, s3Endpoint, s3Region string) error {
+	var r io.ReadCloser
+	var err error
+	if strings.HasPrefix(src, "s3://") {
+		bucket, key, _ := strings.Cut(strings.TrimPrefix(src, "s3://"), "/")
+		if key == "" {
+			return fmt.Errorf("%q has no key", src)
+		}
+		var c *s3Client
+		c, err = newS3Client("s3://"+bucket, s3Endpoint, s3Region)
+		if err != nil {
+			return err
+		}
+		r, err = c.get(key)
+	} else {
+		r, err = os.Open(src)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// verifyBackup opens the file as a bolt database and runs bolt's
+// consistency check over it. A backup from a newer schema version than
+// this build supports is refused, older ones are migrated when opened.
+func verifyBackup(path string) error {
+	db, err := bolt.Open(path, 0, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		// Drain the channel so the check finishes before the
+		// transaction closes.
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return first
+		}
+		var version int
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			if v := meta.Get(schemaVersionKey); v != nil {
+				version = int(itou(v))
+			}
+		}
+		if version > schemaVersion() {
+			return fmt.Errorf("schema version %d is newer than the %d this build supports", version, schemaVersion())
+		}
+		return nil
+	})
+}
+
+// boltEmpty reports whether there is no database at path or none of its
+// buckets hold anything. The schema version in the meta bucket doesn't
+// count, every database that has been opened records it, but settings
+// such as quiet channels and team installs do.
+func boltEmpty(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	db, err := bolt.Open(path, 0, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	empty := true
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			k, _ := c.First()
+			if bytes.Equal(name, metaBucket) && bytes.Equal(k, schemaVersionKey) {
+				k, _ = c.Next()
+			}
+			if k != nil {
+				empty = false
+			}
+			return nil
+		})
+	})
+	return empty, err
+}
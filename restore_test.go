@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// newBoltFile creates a closed bolt database holding the names.
+func newBoltFile(t *testing.T, path string, names ...string) {
+	t.Helper()
+	db, err := openBolt(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if len(names) > 0 {
+		_, err = db.Add(t.Context(), "", reporter{ID: "U1"}, addDetails{}, names...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRestoreOverOpenedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	backup, path := filepath.Join(dir, "backup.db"), filepath.Join(dir, "icecream.db")
+	newBoltFile(t, backup, "alice")
+	newBoltFile(t, path)
+	err := restoreBackup(backup, path, false, "", "")
+	if err != nil {
+		t.Fatalf("restoring over a database without entries: %v", err)
+	}
+	err = restoreBackup(backup, path, false, "", "")
+	if err == nil {
+		t.Fatal("restored over a database with entries without -force")
+	}
+	err = restoreBackup(backup, path, true, "", "")
+	if err != nil {
+		t.Fatalf("forced restore: %v", err)
+	}
+}
+
+func TestRestoreNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	backup, path := filepath.Join(dir, "backup.db"), filepath.Join(dir, "icecream.db")
+	newBoltFile(t, backup, "alice")
+	db, err := bolt.Open(backup, 0660, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(schemaVersionKey, itob(uint64(schemaVersion()+1)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restoreBackup(backup, path, false, "", "")
+	if err == nil {
+		t.Fatal("restored a backup from a newer schema version")
+	}
+	empty, err := boltEmpty(path)
+	if err != nil || !empty {
+		t.Errorf("database replaced by a refused backup")
+	}
+}
+
+func TestBoltEmptyCountsSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "icecream.db")
+	newBoltFile(t, path)
+	empty, err := boltEmpty(path)
+	if err != nil || !empty {
+		t.Fatalf("boltEmpty on a freshly opened database = %v, %v", empty, err)
+	}
+	db, err := openBolt(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SetQuiet(t.Context(), "T1", "C1", time.Now().Add(time.Hour))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err = boltEmpty(path)
+	if err != nil || empty {
+		t.Errorf("boltEmpty on a database with a quiet channel = %v, %v", empty, err)
+	}
+}
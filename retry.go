@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retryTTL is how long a command's reply is kept for Slack's retries.
+	retryTTL = 5 * time.Minute
+	// retryWait is how long a retry waits for the first delivery to
+	// finish, inside Slack's own three second timeout.
+	retryWait = 2 * time.Second
+)
+
+// retryCache remembers recent changing commands so a delivery Slack
+// retries gets the first delivery's reply instead of running again.
+type retryCache struct {
+	mu   sync.Mutex
+	seen map[string]*retryEntry
+}
+
+type retryEntry struct {
+	done    chan struct{}
+	m       msg
+	replied bool
+	expires time.Time
+}
+
+func newRetryCache() *retryCache {
+	return &retryCache{seen: make(map[string]*retryEntry)}
+}
+
+// retryKey identifies a delivery by its trigger_id, or failing that by
+// who sent what. Each command gets its own trigger_id, so a repeated one
+// is always a repeated delivery. Without one, a delivery only counts as
+// a repeat when Slack marks it as a retry, so running the same command
+// twice on purpose still works.
+func retryKey(req *http.Request, cmd *command) (key string, repeat bool) {
+	if id := req.PostFormValue("trigger_id"); id != "" {
+		return "trigger:" + id, true
+	}
+	key = strings.Join([]string{"cmd", cmd.teamID, cmd.channelID, cmd.userID, req.PostFormValue("text")}, "\x00")
+	return key, req.Header.Get("X-Slack-Retry-Num") != ""
+}
+
+// begin records the delivery. When it may be a repeat and an earlier
+// delivery with the same key is known, that delivery's entry is returned
+// with true instead.
+func (c *retryCache) begin(key string, repeat bool, now time.Time) (*retryEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.seen {
+		if now.After(e.expires) {
+			delete(c.seen, k)
+		}
+	}
+	if e, ok := c.seen[key]; ok && repeat {
+		return e, true
+	}
+	e := &retryEntry{done: make(chan struct{}), expires: now.Add(retryTTL)}
+	c.seen[key] = e
+	return e, false
+}
+
+// forget drops a delivery that never ran, so a retry runs it.
+func (c *retryCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, key)
+}
+
+// finish records the reply sent for the delivery. Replies posted to
+// response_url aren't recorded, so retries of those are only acknowledged.
+func (e *retryEntry) finish(m msg, replied bool) {
+	if e == nil {
+		return
+	}
+	e.m = m
+	e.replied = replied
+	close(e.done)
+}
+
+// replay answers a repeated delivery with the first delivery's reply,
+// waiting briefly for it if the command is still running.
+func (s *server) replay(w http.ResponseWriter, req *http.Request, cmd *command, e *retryEntry) {
+	logger(cmd.ctx).Info("repeated delivery, not running the command again", "retry", req.Header.Get("X-Slack-Retry-Num"))
+	select {
+	case <-e.done:
+	case <-time.After(retryWait):
+		return
+	}
+	if !e.replied {
+		return
+	}
+	err := render(w, e.m)
+	if err != nil {
+		logger(cmd.ctx).Error("render failed", "command", cmd.name, "err", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var retried = http.Header{"X-Slack-Retry-Num": {"1"}, "X-Slack-Retry-Reason": {"http_timeout"}}
+
+func TestRetryReplays(t *testing.T) {
+	ts := newTestServer(t)
+	m := ts.slash(t, "U1", "add alice")
+	code, again := ts.post(t, slashForm("U1", "add alice"), retried)
+	if code != http.StatusOK || again.Text != m.Text {
+		t.Errorf("retry = %d %q, want the first reply %q", code, again.Text, m.Text)
+	}
+	if e := ts.entries(t); len(e) != 1 || e[0].count() != 1 {
+		t.Fatalf("entries after a retry = %+v, want alice once", e)
+	}
+
+	ts.slash(t, "U1", "add alice")
+	if e := ts.entries(t); e[0].count() != 2 {
+		t.Errorf("count after adding again without a retry header = %d, want 2", e[0].count())
+	}
+
+	form := slashForm("U1", "add bob")
+	form.Set("trigger_id", "trigger-1")
+	ts.post(t, form, nil)
+	ts.post(t, form, nil)
+	if e := ts.entries(t); len(e) != 2 || e[1].count() != 1 {
+		t.Errorf("entries after a repeated trigger_id = %+v, want bob once", e)
+	}
+}
+
+// blockingStore holds adds until released.
+type blockingStore struct {
+	Store
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStore) Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.Store.Add(ctx, key, by, d, names...)
+}
+
+func TestRetryWhileRunning(t *testing.T) {
+	bs := &blockingStore{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	ts := newTestServer(t, func(s *server) {
+		bs.Store = s.store
+		s.store = bs
+	})
+	first := make(chan msg)
+	go func() {
+		_, m := ts.post(t, slashForm("U1", "add alice"), nil)
+		first <- m
+	}()
+	<-bs.entered
+	second := make(chan msg)
+	go func() {
+		_, m := ts.post(t, slashForm("U1", "add alice"), retried)
+		second <- m
+	}()
+	select {
+	case m := <-second:
+		t.Fatalf("retry answered %q before the first delivery finished", m.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(bs.release)
+	m1, m2 := <-first, <-second
+	if m1.Text == "" || m2.Text != m1.Text {
+		t.Errorf("retry replied %q, want the first reply %q", m2.Text, m1.Text)
+	}
+	if e := ts.entries(t); len(e) != 1 || e[0].count() != 1 {
+		t.Errorf("entries = %+v, want alice once", e)
+	}
+}
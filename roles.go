@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// commandAliases maps alternate command names to the name roles use, so
+// an alias can't be used to get around a restriction.
+var commandAliases = map[string]string{
+	"info":   "show",
+	"rename": "edit",
+	"sample": "random",
+}
+
+// permissions decide who may run which command. The roles file lists the
+// commands of each role, and a command is checked like this:
+//
+//   - Admins may run anything.
+//   - A user holding any of the roles named under "only" may run nothing
+//     but the commands their roles list, so a viewer role listing `list`
+//     and `show` can only read.
+//   - A command listed by any other role may only be run by users holding
+//     one of those roles. Roles under "only" don't restrict the commands
+//     they list, so other users keep them.
+//   - Every other command is open to everyone.
+//
+// Aliases are checked as the command they stand for.
+type permissions struct {
+	restricted map[string]map[string]bool
+	listed     map[string]map[string]bool
+	limited    map[string]bool
+	users      map[string][]string
+}
+
+type rolesFile struct {
+	Roles map[string][]string `json:"roles"`
+	Only  []string            `json:"only"`
+	Users map[string][]string `json:"users"`
+}
+
+func loadPermissions(path string) (*permissions, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f rolesFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p := &permissions{
+		restricted: make(map[string]map[string]bool),
+		listed:     make(map[string]map[string]bool),
+		limited:    make(map[string]bool),
+		users:      f.Users,
+	}
+	for _, role := range f.Only {
+		if _, ok := f.Roles[role]; !ok {
+			return nil, fmt.Errorf("%s: only lists unknown role %q", path, role)
+		}
+		p.limited[role] = true
+	}
+	for role, commands := range f.Roles {
+		p.listed[role] = make(map[string]bool)
+		for _, name := range commands {
+			if _, ok := commandAliases[name]; ok {
+				return nil, fmt.Errorf("%s: role %q lists alias %q, use %q", path, role, name, commandAliases[name])
+			}
+			p.listed[role][name] = true
+			if p.limited[role] {
+				continue
+			}
+			if p.restricted[name] == nil {
+				p.restricted[name] = make(map[string]bool)
+			}
+			p.restricted[name][role] = true
+		}
+	}
+	for user, roles := range f.Users {
+		for _, role := range roles {
+			if _, ok := f.Roles[role]; !ok {
+				return nil, fmt.Errorf("%s: user %s has unknown role %q", path, user, role)
+			}
+		}
+	}
+	return p, nil
+}
+
+// allowed reports whether the user may run the command, leaving out the
+// admin check.
+func (p *permissions) allowed(userID, name string) bool {
+	if p == nil {
+		return true
+	}
+	if canonical, ok := commandAliases[name]; ok {
+		name = canonical
+	}
+	limited, listed := false, false
+	for _, role := range p.users[userID] {
+		limited = limited || p.limited[role]
+		listed = listed || p.listed[role][name]
+	}
+	if limited && !listed {
+		return false
+	}
+	roles, ok := p.restricted[name]
+	if !ok {
+		return true
+	}
+	for _, role := range p.users[userID] {
+		if roles[role] {
+			return true
+		}
+	}
+	return false
+}
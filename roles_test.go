@@ -0,0 +1,119 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	gotoken "go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestDispatchAliases checks that every case in dispatch naming more than
+// one command maps its other names to the first in commandAliases, so roles
+// can't be dodged by using an alias.
+func TestDispatchAliases(t *testing.T) {
+	f, err := parser.ParseFile(gotoken.NewFileSet(), "server.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "dispatch" {
+			return true
+		}
+		found = true
+		sw := fn.Body.List[0].(*ast.SwitchStmt)
+		for _, stmt := range sw.Body.List {
+			clause := stmt.(*ast.CaseClause)
+			if len(clause.List) < 2 {
+				continue
+			}
+			names := make([]string, len(clause.List))
+			for i, expr := range clause.List {
+				names[i], err = strconv.Unquote(expr.(*ast.BasicLit).Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, alias := range names[1:] {
+				if commandAliases[alias] != names[0] {
+					t.Errorf("commandAliases[%q] = %q, want %q", alias, commandAliases[alias], names[0])
+				}
+			}
+		}
+		return false
+	})
+	if !found {
+		t.Fatal("dispatch not found in server.go")
+	}
+}
+
+func TestAllowedAlias(t *testing.T) {
+	p := &permissions{
+		restricted: map[string]map[string]bool{"edit": {"moderator": true}},
+		users:      map[string][]string{"U1": {"moderator"}},
+	}
+	tests := []struct {
+		user, name string
+		want       bool
+	}{
+		{"U1", "edit", true},
+		{"U1", "rename", true},
+		{"U2", "edit", false},
+		{"U2", "rename", false},
+		{"U2", "list", true},
+	}
+	for _, tt := range tests {
+		if got := p.allowed(tt.user, tt.name); got != tt.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", tt.user, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	err := os.WriteFile(path, []byte(`{
+		"roles": {"viewer": ["list", "show"], "treasurer": ["pay"]},
+		"only": ["viewer"],
+		"users": {"UVIEW": ["viewer"], "UBOTH": ["viewer", "treasurer"], "UPAY": ["treasurer"]}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPermissions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, name string
+		want       bool
+	}{
+		{"UVIEW", "list", true},
+		{"UVIEW", "info", true},
+		{"UVIEW", "add", false},
+		{"UVIEW", "pay", false},
+		{"UBOTH", "pay", true},
+		{"UBOTH", "add", false},
+		{"UPAY", "list", true},
+		{"UPAY", "add", true},
+		{"UOTHER", "list", true},
+		{"UOTHER", "pay", false},
+	}
+	for _, tt := range tests {
+		if got := p.allowed(tt.user, tt.name); got != tt.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", tt.user, tt.name, got, tt.want)
+		}
+	}
+
+	err = os.WriteFile(path, []byte(`{"roles": {}, "only": ["viewer"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadPermissions(path)
+	if err == nil {
+		t.Error("loadPermissions accepted an unknown role under only")
+	}
+}
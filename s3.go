@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client talks to an S3-compatible bucket with path-style requests
+// signed with AWS Signature Version 4.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	session   string
+	client    *http.Client
+}
+
+// newS3Client takes the bucket and key prefix from an s3://bucket/prefix
+// URL and credentials from the usual AWS environment variables. An empty
+// endpoint means AWS itself.
+func newS3Client(rawURL, endpoint, region string) (*s3Client, error) {
+	bucket, prefix, err := parseS3URL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("s3 endpoint %q must be an http or https URL", endpoint)
+	}
+	c := &s3Client{
+		endpoint:  u,
+		region:    region,
+		bucket:    bucket,
+		prefix:    prefix,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		session:   os.Getenv("AWS_SESSION_TOKEN"),
+		client:    http.DefaultClient,
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3")
+	}
+	return c, nil
+}
+
+func parseS3URL(raw string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(raw, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("%q isn't an s3://bucket/prefix URL", raw)
+	}
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("%q has no bucket", raw)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return bucket, prefix, nil
+}
+
+// url returns the s3:// URL of the key, for logs.
+func (c *s3Client) url(key string) string {
+	return "s3://" + c.bucket + "/" + c.prefix + key
+}
+
+// put uploads the file under the key. The file is read twice, once for
+// the payload hash the signature covers and once to send it.
+func (c *s3Client) put(key string, f *os.File) error {
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	req, err := c.request(http.MethodPut, c.prefix+key, nil, io.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(req, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// get returns the object stored under the key.
+func (c *s3Client) get(key string) (io.ReadCloser, error) {
+	req, err := c.request(http.MethodGet, c.prefix+key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *s3Client) remove(key string) error {
+	req, err := c.request(http.MethodDelete, c.prefix+key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// list returns the keys under the client's prefix that start with
+// namePrefix, with the client's prefix trimmed.
+func (c *s3Client) list(namePrefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {c.prefix + namePrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := c.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var r struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range r.Contents {
+			keys = append(keys, strings.TrimPrefix(obj.Key, c.prefix))
+		}
+		if !r.IsTruncated || r.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = r.NextContinuationToken
+	}
+}
+
+func (c *s3Client) request(method, key string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = ""
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	// Send the path escaped exactly as it is signed.
+	req.URL.RawPath = awsEscape(req.URL.Path, false)
+	if query != nil {
+		req.URL.RawQuery = awsQuery(query)
+	}
+	return req, nil
+}
+
+// do signs and sends the request, turning responses other than 2xx into
+// errors carrying S3's error code.
+func (c *s3Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	c.sign(req, payloadHash, time.Now())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&r)
+	problem := r.Code
+	if problem == "" {
+		problem = resp.Status
+	}
+	if r.Message != "" {
+		problem += ": " + r.Message
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s", req.Method, req.URL.Path, problem)
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds a Signature Version 4 Authorization header covering the host
+// and every header already set on the request.
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.session != "" {
+		req.Header.Set("X-Amz-Security-Token", c.session)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonical.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{day, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsQuery encodes the query sorted by key with AWS's escaping, which is
+// also its canonical form for signing.
+func awsQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes unless asked to.
+func awsEscape(s string, slash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/' && !slash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const schedulePoll = 30 * time.Second
+
+var scheduledBucket = []byte("scheduled")
+
+type scheduledAdd struct {
+	ID          uint64    `json:"-"`
+	Backlog     string    `json:"channel,omitempty"`
+	Name        string    `json:"name"`
+	At          time.Time `json:"at"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	By          reporter  `json:"by,omitzero"`
+}
+
+// Schedule records an add into the backlog with the given key, empty for
+// the shared backlog.
+func (db *store) Schedule(ctx context.Context, key, name string, at time.Time, by reporter) (uint64, error) {
+	db = db.with(ctx)
+	var id uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(scheduledBucket)
+		if err != nil {
+			return err
+		}
+		id, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(scheduledAdd{Backlog: key, Name: name, At: at, ScheduledAt: time.Now(), By: by})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(id), b)
+	})
+	return id, err
+}
+
+// Scheduled returns the pending adds for the backlog with the given key.
+func (db *store) Scheduled(ctx context.Context, key string) ([]scheduledAdd, error) {
+	db = db.with(ctx)
+	var rv []scheduledAdd
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(scheduledBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var a scheduledAdd
+			err := json.Unmarshal(v, &a)
+			if err != nil {
+				return err
+			}
+			a.ID = itou(k)
+			if a.Backlog == key {
+				rv = append(rv, a)
+			}
+			return nil
+		})
+	})
+	return rv, err
+}
+
+// Promote moves every scheduled add that is due by now into its backlog
+// in a single transaction and returns the new entries.
+func (db *store) Promote(ctx context.Context, now time.Time) ([]entry, error) {
+	db = db.with(ctx)
+	var added []entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		sched := tx.Bucket(scheduledBucket)
+		if sched == nil {
+			return nil
+		}
+		var due [][]byte
+		var pending []scheduledAdd
+		err := sched.ForEach(func(k, v []byte) error {
+			var a scheduledAdd
+			err := json.Unmarshal(v, &a)
+			if err != nil {
+				return err
+			}
+			if !a.At.After(now) {
+				due = append(due, k)
+				pending = append(pending, a)
+			}
+			return nil
+		})
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		for i, a := range pending {
+			b := db.backlog(a.Backlog)
+			bucket, err := b.createBucket(tx)
+			if err != nil {
+				return err
+			}
+			e, err := addEntry(bucket, a.Name, func(e *entry) {
+				e.logBy("scheduled", a.ScheduledAt, a.By)
+				e.logBy("added", now, a.By)
+			})
+			if err != nil {
+				return err
+			}
+			err = b.record(tx, "added", e, now, a.By)
+			if err != nil {
+				return err
+			}
+			err = sched.Delete(due[i])
+			if err != nil {
+				return err
+			}
+			added = append(added, e)
+		}
+		return nil
+	})
+	return added, err
+}
+
+// runScheduler promotes scheduled adds once they are due and clears
+// expired spotlights.
+func runScheduler(ctx context.Context, st Store) {
+	t := time.NewTicker(schedulePoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			added, err := st.Promote(ctx, now)
+			if err != nil {
+				slog.Error("scheduled add promotion failed", "err", err)
+				continue
+			}
+			for _, e := range added {
+				slog.Info("scheduled add promoted", "id", e.ID, "name", e.Name)
+			}
+			cleared, err := st.ClearSpotlights(ctx, now)
+			if err != nil {
+				slog.Error("spotlight sweep failed", "err", err)
+				continue
+			}
+			for _, e := range cleared {
+				slog.Info("spotlight expired", "id", e.ID, "name", e.Name)
+			}
+		}
+	}
+}
+
+var scheduleFormats = []string{"2006-01-02T15:04", "2006-01-02"}
+
+// parseScheduleTime accepts a relative time such as +2d or +3h, or an
+// absolute date with an optional time of day in the server's time zone.
+func parseScheduleTime(s string, now time.Time) (time.Time, error) {
+	if rel, ok := strings.CutPrefix(s, "+"); ok {
+		d, err := parseDuration(rel)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range scheduleFormats {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func (s *server) addAt(cmd *command) (msg, error) {
+	when, rest, _ := strings.Cut(cmd.args, " ")
+	now := time.Now()
+	at, err := parseScheduleTime(when, now)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	if !at.After(now) {
+		return newPrivateMessage("That time has already passed, use `add` instead."), nil
+	}
+	name, userID := s.parseUser(cmd.ctx, cmd.teamID, strings.TrimSpace(rest))
+	if name == "" {
+		return msg{}, errUsage
+	}
+	if s.reserved[strings.ToLower(name)] || s.reserved[strings.ToLower(userID)] {
+		return newPrivateMessage("You can't add that."), nil
+	}
+	_, err = s.store.Schedule(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), name, at, cmd.reporter())
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("Scheduled %s to join the queue on %s.", name, at.Format(timeFormat))
+	return newPublicMessage(text), nil
+}
+
+func (s *server) scheduledCommand(cmd *command) (msg, error) {
+	pending, err := s.store.Scheduled(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	if len(pending) == 0 {
+		return newPrivateMessage("There are no scheduled adds."), nil
+	}
+	lines := []string{"*Scheduled adds:*"}
+	for _, a := range pending {
+		lines = append(lines, fmt.Sprintf("• %s on %s", a.Name, a.At.Format(timeFormat)))
+	}
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// secret holds a credential that may be rotated while the server runs.
+type secret struct {
+	path  string
+	value atomic.Value
+}
+
+func newSecret(value, path string) (*secret, error) {
+	s := &secret{path: path}
+	if path == "" {
+		s.value.Store(value)
+		return s, nil
+	}
+	err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *secret) load() string {
+	v, _ := s.value.Load().(string)
+	return v
+}
+
+func (s *secret) equal(v string) bool {
+	want := s.load()
+	return want != "" && subtle.ConstantTimeCompare([]byte(v), []byte(want)) == 1
+}
+
+func (s *secret) reload() error {
+	if s.path == "" {
+		return errors.New("secret was not loaded from a file")
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return errors.New("secret file " + s.path + " is empty")
+	}
+	s.value.Store(v)
+	return nil
+}
+
+// reloadOnHangup reloads the secrets from their files whenever the
+// process receives SIGHUP.
+func reloadOnHangup(secrets ...*secret) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			for _, s := range secrets {
+				if s == nil || s.path == "" {
+					continue
+				}
+				err := s.reload()
+				if err != nil {
+					slog.Error("secret reload failed", "path", s.path, "err", err)
+					continue
+				}
+				slog.Info("secret reloaded", "path", s.path)
+			}
+		}
+	}()
+}
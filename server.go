@@ -0,0 +1,1263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// maxAddCount caps how many ice creams one add can put on the backlog.
+const maxAddCount = 10
+
+type server struct {
+	token         *secret
+	apiKey        *secret
+	signingSecret *secret
+
+	apiFieldMap map[string]string
+	store       Store
+	bolt        *store
+	slack       *slackClient
+	users       userCache
+	notifyAdds  bool
+	oauth       *oauthConfig
+	botID       string
+	reserved    map[string]bool
+	admins      map[string]bool
+	permissions *permissions
+	settle      settler
+	async       bool
+	asyncSlow   bool
+	responder   *responder
+	retries     *retryCache
+	perChannel  bool
+	multiTeam   bool
+	undoWindow  time.Duration
+
+	confirmDeletes   bool
+	historyRetention time.Duration
+	threads          bool
+	channel          string
+
+	backupDir    string
+	backups      *backuper
+	publicURL    string
+	shareSecret  *secret
+	summaryImage bool
+	summary      summaryCache
+
+	bonusChance    float64
+	random         func() float64
+	warnDuplicates bool
+	addMessages    []*template.Template
+	batcher        *addBatcher
+	drain          *drainer
+	metrics        *metrics
+}
+
+type command struct {
+	ctx         context.Context
+	name        string
+	args        string
+	words       []string
+	opts        map[string]string
+	quoted      map[string]bool
+	userID      string
+	userName    string
+	teamID      string
+	channelID   string
+	responseURL string
+
+	// confirmed is set when the user has already confirmed the command
+	// through an interactive prompt.
+	confirmed bool
+
+	// parseErr is set when the text couldn't be parsed into arguments.
+	parseErr error
+}
+
+func newCommand(req *http.Request) *command {
+	cmd := &command{
+		userID:      req.PostFormValue("user_id"),
+		userName:    req.PostFormValue("user_name"),
+		teamID:      req.PostFormValue("team_id"),
+		channelID:   req.PostFormValue("channel_id"),
+		responseURL: req.PostFormValue("response_url"),
+	}
+	cmd.parseText(req.PostFormValue("text"))
+	cmd.ctx = annotate(req.Context(), cmd.logFields()...)
+	return cmd
+}
+
+// logFields identify the command in logs.
+func (cmd *command) logFields() []any {
+	return []any{"team", cmd.teamID, "channel", cmd.channelID, "user", cmd.userID, "command", cmd.name}
+}
+
+func (cmd *command) reporter() reporter {
+	return reporter{ID: cmd.userID, Name: cmd.userName}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if isCertCheck(req) {
+		return
+	}
+	if req.Method != http.MethodPost {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.verify(req) {
+		abort(w, http.StatusBadRequest)
+		return
+	}
+	if s.channel != "" && req.PostFormValue("channel_id") != s.channel {
+		text := fmt.Sprintf("This bot is restricted to <#%s>.", s.channel)
+		err := render(w, newPrivateMessage(text))
+		if err != nil {
+			logger(req.Context()).Error("render failed", "err", err)
+		}
+		return
+	}
+	cmd := newCommand(req)
+	// Changes are recorded so that a delivery Slack retries after a
+	// slow reply doesn't apply them twice.
+	var retry *retryEntry
+	key, repeat := retryKey(req, cmd)
+	if s.retries != nil && mutates(cmd) {
+		e, repeated := s.retries.begin(key, repeat, time.Now())
+		if repeated {
+			s.replay(w, req, cmd, e)
+			return
+		}
+		retry = e
+	}
+	if cmd.responseURL != "" && (s.async || s.asyncSlow && slowCommands[cmd.name]) && s.responder.enqueue(cmd) {
+		retry.finish(msg{}, false)
+		return
+	}
+	m, err := s.run(cmd)
+	if err == errUnknownCommand {
+		return
+	}
+	if err == errShuttingDown {
+		if retry != nil {
+			s.retries.forget(key)
+		}
+		retry.finish(msg{}, false)
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	retry.finish(m, true)
+	err = render(w, m)
+	if err != nil {
+		logger(cmd.ctx).Error("render failed", "command", cmd.name, "err", err)
+	}
+}
+
+// verify authenticates a request from Slack by its signature when a
+// signing secret is configured, falling back to the legacy verification
+// token for requests that aren't signed.
+func (s *server) verify(req *http.Request) bool {
+	if s.signingSecret != nil && req.Header.Get("X-Slack-Signature") != "" {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxSignedBody))
+		if err != nil {
+			return false
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return verifySignature(s.signingSecret, req.Header, body, time.Now()) == nil
+	}
+	return s.token != nil && s.token.equal(requestToken(req))
+}
+
+// requestToken returns the verification token of a slash command, or of
+// an interaction where it is part of the JSON payload.
+func requestToken(req *http.Request) string {
+	payload := req.PostFormValue("payload")
+	if payload == "" {
+		return req.PostFormValue("token")
+	}
+	var v struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal([]byte(payload), &v)
+	return v.Token
+}
+
+// run dispatches the command and turns its result into the reply,
+// applying the channel's quiet mode. Only errUnknownCommand and
+// errShuttingDown are returned, all other errors become messages.
+func (s *server) run(cmd *command) (msg, error) {
+	if _, known := usages[cmd.name]; known {
+		cmd.ctx = withCommand(cmd.ctx, cmd.name)
+	}
+	if !s.permissions.allowed(cmd.userID, cmd.name) && !s.isAdmin(cmd) {
+		text := fmt.Sprintf("Your role doesn't allow `%s`.", cmd.name)
+		return newPrivateMessage(text), nil
+	}
+	if s.drain.draining() && mutates(cmd) {
+		return newPrivateMessage("Under maintenance, try again shortly."), nil
+	}
+	if _, known := usages[cmd.name]; known && cmd.parseErr != nil {
+		return usageMessage(cmd.name, cmd.parseErr.(*usageError).problem), nil
+	}
+	start := time.Now()
+	m, err := s.dispatch(cmd)
+	var usage *usageError
+	if err != errUnknownCommand {
+		s.metrics.observe(cmd.name, time.Since(start), err != nil && !errors.As(err, &usage))
+	}
+	if err == errUnknownCommand || err == errShuttingDown {
+		return m, err
+	}
+	if errors.As(err, &usage) {
+		return usageMessage(cmd.name, usage.problem), nil
+	}
+	if err != nil {
+		return s.errorMessage(cmd, err), nil
+	}
+	if m.Type == "in_channel" && (s.isQuiet(cmd.ctx, cmd.teamID, cmd.channelID) || s.isPrivate(cmd)) {
+		m.Type = "ephemeral"
+	}
+	return m, nil
+}
+
+func (s *server) dispatch(cmd *command) (msg, error) {
+	switch cmd.name {
+	case "help":
+		return s.help(cmd)
+	case "list":
+		return s.list(cmd)
+	case "me":
+		return s.me(cmd)
+	case "between":
+		return s.between(cmd)
+	case "rivalry":
+		return s.rivalry(cmd)
+	case "overdue":
+		return s.overdue(cmd)
+	case "snooze":
+		return s.snooze(cmd)
+	case "show", "info":
+		return s.show(cmd)
+	case "whoadded":
+		return s.whoAdded(cmd)
+	case "excuse":
+		return s.excuse(cmd)
+	case "reason":
+		return s.reason(cmd)
+	case "edit", "rename":
+		return s.edit(cmd)
+	case "pin":
+		return s.pin(cmd, true)
+	case "unpin":
+		return s.pin(cmd, false)
+	case "spotlight":
+		return s.spotlight(cmd)
+	case "random", "sample":
+		return s.randomEntry(cmd)
+	case "settle-round":
+		return s.settleRound(cmd)
+	case "heatmap":
+		return s.heatmap(cmd)
+	case "summary":
+		return s.summaryCommand(cmd)
+	case "dwell":
+		return s.dwell(cmd)
+	case "export-md":
+		return s.exportMarkdown(cmd)
+	case "export":
+		return s.exportCSV(cmd)
+	case "quiet":
+		return s.quiet(cmd)
+	case "share":
+		return s.share(cmd)
+	case "diff":
+		return s.diff(cmd)
+	case "fsck":
+		return s.fsck(cmd)
+	case "add":
+		return s.add(cmd)
+	case "add-at":
+		return s.addAt(cmd)
+	case "scheduled":
+		return s.scheduledCommand(cmd)
+	case "del":
+		return s.del(cmd)
+	case "clear":
+		return s.clear(cmd)
+	case "migrate-to":
+		return s.migrateTo(cmd)
+	case "config":
+		return s.config(cmd)
+	case "notify":
+		return s.notify(cmd)
+	case "pay":
+		return s.pay(cmd)
+	case "paid":
+		return s.paid(cmd)
+	case "amnesty":
+		return s.amnesty(cmd)
+	case "history":
+		return s.history(cmd)
+	case "trim-history":
+		return s.trimHistory(cmd)
+	case "stats":
+		return s.stats(cmd)
+	case "undo":
+		return s.undo(cmd)
+	}
+	return msg{}, errUnknownCommand
+}
+
+// errorMessage logs a failed command and turns the error into a reply.
+// Admins see the underlying error to speed up diagnosis while everyone
+// else gets a generic apology.
+func (s *server) errorMessage(cmd *command, err error) msg {
+	text, level := classifyStoreError(err)
+	logger(cmd.ctx).Log(cmd.ctx, level, "command failed", "command", cmd.name, "err", err)
+	if s.isAdmin(cmd) {
+		text = fmt.Sprintf("%s\n```%v```", text, err)
+	}
+	return newPrivateMessage(text)
+}
+
+// classifyStoreError returns the reply for a failed command and the level
+// to log it at. A busy or read-only database is something an operator can
+// clear, so those log as warnings.
+func classifyStoreError(err error) (string, slog.Level) {
+	switch {
+	case errors.Is(err, berrors.ErrTimeout):
+		return "The database is busy, please try again in a moment.", slog.LevelWarn
+	case errors.Is(err, berrors.ErrDatabaseReadOnly):
+		return "The backlog is read-only right now, changes can't be saved.", slog.LevelWarn
+	case errors.Is(err, berrors.ErrTxNotWritable):
+		return "That change couldn't be saved, please try again.", slog.LevelError
+	}
+	return "Something went wrong, please try again.", slog.LevelError
+}
+
+func (s *server) help(cmd *command) (msg, error) {
+	lines := []string{
+		"*Did someone leave their screen unlocked? Usage:*",
+		"`/icecream add <username> [--count <n>]` to add a user to the owing backlog, list several to add them all, quote names with spaces",
+		"`/icecream add <username> because <reason>` to say why they owe one, `reason <id> <text>` to change it later",
+		"`/icecream add-at <time> <username>` to add a user later, time is like `+2d` or `2024-06-03T09:00`",
+		"`/icecream scheduled` to list pending scheduled adds",
+		"`/icecream del <id|username>` to take one off what a user owes, use `list` to find id",
+		"`/icecream pay <id|username>` to settle one ice cream, the debt is archived rather than deleted",
+		"`/icecream paid <id> [<id>...]|all [--dry-run]` to settle everything owed on several entries at once",
+		"`/icecream amnesty [note] [--dry-run]` to forgive everyone after a party, moving the backlog to the ledger, admins only",
+		"`/icecream undo` to reverse your last add or delete if it was recent",
+		"`/icecream stats` to show the all-time top offenders, adds per month and how long payment takes",
+		"`/icecream history [count]` to show who added, deleted and paid, newest first",
+		"`/icecream trim-history` to delete history older than the server's retention, admins only",
+		"`/icecream list` to list owing users",
+		"`/icecream list <pattern>` to list owing users matching a glob such as `alic*`",
+		"`/icecream me` to see what you owe",
+		"`/icecream between <reporter> <username>` to see when one user added another",
+		"`/icecream rivalry <username> <username>` to see how often two users have added each other",
+		"`/icecream add <username> --due <when>` to set a deadline such as `2w`, `list --sort due` to see the soonest first",
+		"`/icecream overdue` to list entries past their deadline",
+		"`/icecream snooze <id> <duration>` to push back a deadline and leave the entry out of digests meanwhile",
+		"`/icecream show <id>` to show the timeline of a single entry",
+		"`/icecream whoadded <id>` to see who added an entry and when",
+		"`/icecream edit <id> <new name>` to fix the name on an entry, keeping its id and history",
+		"`/icecream excuse <id> <text>` to attach an excuse to an entry",
+		"`/icecream pin <id>` to keep an entry at the top of the list, `unpin <id>` to release it",
+		"`/icecream spotlight <id> <duration>` to put a countdown on an entry in the list, `off` to clear it",
+		"`/icecream random [--weighted]` to pick who brings ice cream to the next team event, weighted by what they owe if asked, nothing changes",
+		"`/icecream settle-round` to work out who buys for whom",
+		"`/icecream heatmap [weeks]` to show daily activity over the last few weeks",
+		"`/icecream summary` to post an image of the backlog",
+		"`/icecream dwell` to show how long entries have been waiting on average",
+		"`/icecream export-md` to get the ranked offenders as a markdown table",
+		"`/icecream export` to get every entry as CSV, also at `GET /api/v1/export.csv`",
+		"`/icecream share [duration]` to get a read-only link to the backlog",
+		"`/icecream config digest <day> <HH:MM>` to post a weekly summary of debts here, such as `fridays 15:00`, `off` to stop it",
+		"`/icecream config admins add|remove <@user>` to change this team's admins (admins only)",
+		"`/icecream config settle <chain|pairs|top|default>` to pick this team's settle-up strategy (admins only)",
+		"`/icecream config public on|off` to show replies in this channel to everyone or only to whoever ran the command (admins only)",
+		"`/icecream notify off` to stop the direct message you get when someone adds you, `notify on` to get it again",
+		"`/icecream quiet <duration>` to keep replies in this channel private for a while, `quiet off` to end it",
+		"`/icecream diff <backupA> <backupB>` to compare two backups (admins only)",
+		"`/icecream clear` to wipe this backlog after a confirmation (admins only)",
+		"`/icecream migrate-to <#channel>` to copy this backlog into another channel's, `--replace` to overwrite its backlog, `--clear` to empty this one (admins only)",
+		"`/icecream fsck [--repair]` to check the database for unreadable entries (admins only)",
+		"`/icecream help` to display this usage information",
+	}
+	text := strings.Join(lines, "\n")
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) list(cmd *command) (msg, error) {
+	if cmd.args != "" {
+		return s.listMatching(cmd)
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	switch order, _ := cmd.option("sort"); order {
+	case "", "id":
+		sortPinned(entries)
+	case "due":
+		sortByDue(entries)
+	default:
+		return msg{}, usageErrorf("Entries can be sorted by `id` or `due`.")
+	}
+	now := time.Now()
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("%d. %s", e.ID, e.label())
+		if e.overdue(now) {
+			lines[i] = "⚠️ " + lines[i]
+		}
+		if e.Reason != "" {
+			lines[i] += fmt.Sprintf(" because _%s_", escape(e.Reason))
+		}
+		if add := e.firstAdd(); !add.Time.IsZero() {
+			lines[i] += fmt.Sprintf(" — added by %s %s ago", add.By, humanize(time.Since(add.Time)))
+		}
+		if e.Pinned {
+			lines[i] = "📌 " + lines[i]
+		}
+		if left := time.Until(e.SpotlightUntil); left > 0 {
+			lines[i] += fmt.Sprintf(" ⏳ %s left to buy", humanize(left))
+		}
+		if due := e.dueLabel(now); due != "" {
+			lines[i] += ", " + due
+		}
+		if e.snoozed(now) {
+			lines[i] += fmt.Sprintf(" 😴 snoozed for %s", humanize(e.SnoozedUntil.Sub(now)))
+		}
+	}
+	if len(lines) == 0 {
+		return newPublicMessage("The icecream backlog is empty. Tread lightly."), nil
+	}
+	m := newPublicMessage(strings.Join(lines, "\n"))
+	return withBlocks(m, listBlocks(entries, now)), nil
+}
+
+// me lists the entries that mention the invoking user.
+func (s *server) me(cmd *command) (msg, error) {
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	owed := 0
+	var lines []string
+	for _, e := range entries {
+		if _, userID := parseMention(e.Name); userID == "" || userID != cmd.userID {
+			continue
+		}
+		owed += e.count()
+		line := fmt.Sprintf("• %d. %s", e.ID, e.label())
+		if e.Reason != "" {
+			line += fmt.Sprintf(" because _%s_", escape(e.Reason))
+		}
+		if add := e.firstAdd(); !add.Time.IsZero() {
+			line += fmt.Sprintf(" — added by %s %s ago", add.By, humanize(time.Since(add.Time)))
+		}
+		lines = append(lines, line)
+	}
+	if owed == 0 {
+		return newPrivateMessage("You don't owe any ice cream. Keep it that way! 🔒"), nil
+	}
+	head := fmt.Sprintf("You owe %s:", plural(owed, "ice cream", "ice creams"))
+	return newPrivateMessage(head + "\n" + strings.Join(lines, "\n")), nil
+}
+
+// randomEntry names a random entry for fun, leaving the backlog as is.
+// With --weighted each entry's chance follows how many it owes.
+func (s *server) randomEntry(cmd *command) (msg, error) {
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	if len(entries) == 0 {
+		return newPublicMessage("The icecream backlog is empty, nobody to pick."), nil
+	}
+	weight := func(e entry) int { return 1 }
+	if _, ok := cmd.option("weighted"); ok {
+		weight = entry.count
+	}
+	total := 0
+	for _, e := range entries {
+		total += weight(e)
+	}
+	n := min(int(s.random()*float64(total)), total-1)
+	pick := entries[len(entries)-1]
+	for _, e := range entries {
+		if n < weight(e) {
+			pick = e
+			break
+		}
+		n -= weight(e)
+	}
+	text := fmt.Sprintf("🥁 Drumroll please… 🥁\n🎲 And the one bringing ice cream to the next team event is… *%s*! 🍦", pick.Name)
+	if pick.count() > 1 {
+		text += fmt.Sprintf(" They owe %d, so it's only fair.", pick.count())
+	}
+	return newPublicMessage(text), nil
+}
+
+func (s *server) listMatching(cmd *command) (msg, error) {
+	pattern := strings.ToLower(cmd.args)
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		text := fmt.Sprintf("Invalid pattern `%s`, use `*`, `?` and `[...]` to match names.", cmd.args)
+		return newPrivateMessage(text), nil
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	var lines []string
+	for _, e := range entries {
+		ok, _ := path.Match(pattern, strings.ToLower(e.Name))
+		if ok {
+			lines = append(lines, fmt.Sprintf("%d. %s", e.ID, e.label()))
+		}
+	}
+	if len(lines) == 0 {
+		text := fmt.Sprintf("Nobody on the backlog matches `%s`.", cmd.args)
+		return newPrivateMessage(text), nil
+	}
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
+
+// whoAdded answers who first added an entry and when, without the rest
+// of what show prints.
+func (s *server) whoAdded(cmd *command) (msg, error) {
+	n, err := strconv.ParseUint(cmd.args, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	e, err := s.store.Get(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n)
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	add := e.firstAdd()
+	if add.Time.IsZero() {
+		text := fmt.Sprintf("Entry %d (%s) was added before adds were recorded.", e.ID, e.Name)
+		return newPrivateMessage(text), nil
+	}
+	text := fmt.Sprintf("Entry %d (%s) was added by %s, %s ago.", e.ID, e.Name, add.By, humanize(time.Since(add.Time)))
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) show(cmd *command) (msg, error) {
+	n, err := strconv.ParseUint(cmd.args, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	e, err := s.store.Get(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n)
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	lines := []string{fmt.Sprintf("*%d. %s*", e.ID, e.label())}
+	if e.Reason != "" {
+		lines = append(lines, fmt.Sprintf("Reason: _%s_", escape(e.Reason)))
+	}
+	if !e.Due.IsZero() {
+		lines = append(lines, fmt.Sprintf("Due: %s (%s)", e.Due.Format(timeFormat), e.dueLabel(time.Now())))
+	}
+	if e.Excuse != "" {
+		lines = append(lines, fmt.Sprintf("Excuse: _%s_", escape(e.Excuse)))
+	}
+	for _, ev := range e.Events {
+		line := fmt.Sprintf("• %s %s", ev.Time.Format(timeFormat), ev.Action)
+		if ev.By != (reporter{}) {
+			line += " by " + ev.By.String()
+		}
+		lines = append(lines, line)
+	}
+	return newPrivateMessage(strings.Join(lines, "\n")), nil
+}
+
+func (s *server) excuse(cmd *command) (msg, error) {
+	id, text, _ := strings.Cut(cmd.args, " ")
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	// The excuse is kept as typed and escaped wherever it is shown, so
+	// it can't mention or link anyone.
+	text = sanitize(text)
+	if text == "" {
+		return msg{}, errUsage
+	}
+	if utf8.RuneCountInString(text) > maxExcuseLength {
+		text := fmt.Sprintf("Excuses are limited to %d characters, keep it brief.", maxExcuseLength)
+		return newPrivateMessage(text), nil
+	}
+	e, err := s.store.Modify(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, func(e *entry) error {
+		e.Excuse = text
+		e.log("excused", time.Now())
+		return nil
+	})
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	reply := fmt.Sprintf("%s (%d) says: _%s_", e.Name, e.ID, escape(e.Excuse))
+	return newPublicMessage(reply), nil
+}
+
+func (s *server) reason(cmd *command) (msg, error) {
+	id, text, _ := strings.Cut(cmd.args, " ")
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	text = sanitize(text)
+	if text == "" {
+		return msg{}, errUsage
+	}
+	if utf8.RuneCountInString(text) > maxReasonLength {
+		text := fmt.Sprintf("Reasons are limited to %d characters, keep it brief.", maxReasonLength)
+		return newPrivateMessage(text), nil
+	}
+	e, err := s.setReason(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, text, cmd.reporter())
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	reply := fmt.Sprintf("%s (%d) owes one because _%s_", e.Name, e.ID, escape(e.Reason))
+	return newPublicMessage(reply), nil
+}
+
+func (s *server) edit(cmd *command) (msg, error) {
+	id, rest, _ := strings.Cut(cmd.args, " ")
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	name, userID := s.parseUser(cmd.ctx, cmd.teamID, strings.TrimSpace(rest))
+	if name == "" {
+		return msg{}, errUsage
+	}
+	if s.reserved[strings.ToLower(name)] || s.reserved[strings.ToLower(userID)] {
+		return newPrivateMessage("You can't use that name."), nil
+	}
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	entries, err := s.store.List(cmd.ctx, key)
+	if err != nil {
+		return msg{}, err
+	}
+	for _, e := range entries {
+		if e.ID != n && strings.EqualFold(e.Name, name) {
+			text := fmt.Sprintf("%s is already on the backlog as id %d.", e.Name, e.ID)
+			return newPrivateMessage(text), nil
+		}
+	}
+	var old string
+	e, err := s.store.Modify(cmd.ctx, key, n, func(e *entry) error {
+		old = e.Name
+		e.Name = name
+		e.logBy("renamed from "+old, time.Now(), cmd.reporter())
+		return nil
+	})
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("Renamed %s (%d) to %s.", old, e.ID, e.Name)
+	return newPublicMessage(text), nil
+}
+
+func (s *server) setReason(ctx context.Context, key string, id uint64, reason string, by reporter) (entry, error) {
+	return s.store.Modify(ctx, key, id, func(e *entry) error {
+		e.Reason = reason
+		e.logBy("reason given", time.Now(), by)
+		return nil
+	})
+}
+
+func (s *server) pin(cmd *command, pinned bool) (msg, error) {
+	n, err := strconv.ParseUint(cmd.args, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	e, err := s.store.Pin(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, pinned)
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("📌 Pinned %s (%d) to the top of the queue.", e.Name, e.ID)
+	if !pinned {
+		text = fmt.Sprintf("Unpinned %s (%d).", e.Name, e.ID)
+	}
+	return newPublicMessage(text), nil
+}
+
+// sortPinned moves pinned entries to the front in the order they were
+// pinned, leaving the rest in id order.
+func sortPinned(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		return a.Pinned && a.PinnedAt.Before(b.PinnedAt)
+	})
+}
+
+func (s *server) settleRound(cmd *command) (msg, error) {
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	settle, err := s.settlerFor(cmd)
+	if err != nil {
+		return msg{}, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if seen[e.Name] {
+			continue
+		}
+		seen[e.Name] = true
+		names = append(names, e.Name)
+	}
+	var text string
+	switch len(names) {
+	case 0:
+		text = "The icecream backlog is empty. Nobody owes anybody."
+	case 1:
+		text = fmt.Sprintf("%s is the only one on the backlog and buys for the whole team.", names[0])
+	default:
+		lines := []string{"*Settle-up round:*"}
+		for _, a := range settle(names) {
+			lines = append(lines, fmt.Sprintf("%s buys for %s", a.from, a.to))
+		}
+		text = strings.Join(lines, "\n")
+	}
+	return newPublicMessage(text), nil
+}
+
+func (s *server) heatmap(cmd *command) (msg, error) {
+	weeks := 12
+	if cmd.args != "" {
+		n, err := strconv.Atoi(cmd.args)
+		if err != nil || n < 1 || n > 52 {
+			return msg{}, errUsage
+		}
+		weeks = n
+	}
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	var times []time.Time
+	for _, e := range entries {
+		for _, ev := range e.Events {
+			if ev.Action == "added" {
+				times = append(times, ev.Time)
+			}
+		}
+	}
+	text := fmt.Sprintf("*Activity over the last %d weeks:*\n```\n%s```", weeks, renderHeatmap(times, time.Now(), weeks))
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) dwell(cmd *command) (msg, error) {
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	now := time.Now()
+	var total time.Duration
+	var n int
+	for _, e := range entries {
+		if t := e.addedAt(); !t.IsZero() {
+			total += now.Sub(t)
+			n++
+		}
+	}
+	if n == 0 {
+		return newPrivateMessage("The icecream backlog is empty, nobody is waiting."), nil
+	}
+	text := fmt.Sprintf("Entries have been on the backlog for %s on average (%s).", humanize(total/time.Duration(n)), plural(n, "entry", "entries"))
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) exportMarkdown(cmd *command) (msg, error) {
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	if len(entries) == 0 {
+		return newPrivateMessage("The icecream backlog is empty, there is nothing to export."), nil
+	}
+	lines := []string{"| Rank | Name | Count |", "| ---: | --- | ---: |"}
+	for i, t := range tally(entries) {
+		name := strings.ReplaceAll(t.name, "|", "\\|")
+		lines = append(lines, fmt.Sprintf("| %d | %s | %d |", i+1, name, t.count))
+	}
+	text := "```\n" + strings.Join(lines, "\n") + "\n```"
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) quiet(cmd *command) (msg, error) {
+	if cmd.args == "off" {
+		err := s.store.SetQuiet(cmd.ctx, cmd.teamID, cmd.channelID, time.Time{})
+		if err != nil {
+			return msg{}, err
+		}
+		return newPublicMessage("Quiet mode is off, carry on."), nil
+	}
+	d, err := parseDuration(cmd.args)
+	if err != nil || d <= 0 {
+		return msg{}, errUsage
+	}
+	until := time.Now().Add(d)
+	err = s.store.SetQuiet(cmd.ctx, cmd.teamID, cmd.channelID, until)
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("🤫 Quiet mode until %s, replies here will only be visible to whoever asked.", until.Format(timeFormat))
+	return newPublicMessage(text), nil
+}
+
+func (s *server) isQuiet(ctx context.Context, team, channel string) bool {
+	until, err := s.store.QuietUntil(ctx, team, channel)
+	if err != nil {
+		logger(ctx).Error("quiet mode lookup failed", "team", team, "channel", channel, "err", err)
+		return false
+	}
+	return time.Now().Before(until)
+}
+
+func (s *server) add(cmd *command) (msg, error) {
+	words, reason := splitReason(cmd.words)
+	if v, ok := cmd.option("reason"); ok {
+		reason = v
+	}
+	details := addDetails{reason: sanitize(reason)}
+	if utf8.RuneCountInString(details.reason) > maxReasonLength {
+		text := fmt.Sprintf("Reasons are limited to %d characters, keep it brief.", maxReasonLength)
+		return newPrivateMessage(text), nil
+	}
+	if v, ok := cmd.option("due"); ok {
+		now := time.Now()
+		due, err := parseDue(v, now)
+		if err != nil || !due.After(now) {
+			return msg{}, usageErrorf("`%s` isn't a deadline in the future such as `2w` or `2024-06-03`.", v)
+		}
+		details.due = due
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, word := range splitNames(words, cmd.quoted) {
+		name, userID := s.parseUser(cmd.ctx, cmd.teamID, word)
+		if s.reserved[strings.ToLower(name)] || s.reserved[strings.ToLower(userID)] {
+			return newPrivateMessage("You can't add that."), nil
+		}
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return msg{}, errUsage
+	}
+	count := 1
+	if v, ok := cmd.option("count"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAddCount {
+			return msg{}, usageErrorf("The count must be between 1 and %d.", maxAddCount)
+		}
+		count = n
+	}
+	if len(names) > 1 {
+		return s.addMany(cmd, names, count, details)
+	}
+	name := names[0]
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	if count == 1 && s.bonusChance > 0 && s.random() < s.bonusChance {
+		return s.addBonus(cmd, key, name, details)
+	}
+	copies := make([]string, count)
+	for i := range copies {
+		copies[i] = name
+	}
+	added, err := s.store.Add(cmd.ctx, key, cmd.reporter(), details, copies...)
+	if err != nil {
+		return msg{}, err
+	}
+	e := added[len(added)-1]
+	s.notifyAdded(cmd, e, details)
+	if count > 1 {
+		text := fmt.Sprintf("Added %s to the queue %d times, that makes ×%d.", e.Name, count, e.count())
+		return newPublicMessage(details.annotate(text)), nil
+	}
+	text, err := s.addMessage(e.ID, name)
+	if err != nil {
+		return msg{}, err
+	}
+	text = details.annotate(text)
+	if s.warnDuplicates && e.count() > 1 {
+		text = fmt.Sprintf("Heads up — %s is already on the list (id %d), that makes ×%d.", e.Name, e.ID, e.count())
+	} else if s.batcher != nil && cmd.responseURL != "" && !s.isQuiet(cmd.ctx, cmd.teamID, cmd.channelID) && !s.isPrivate(cmd) {
+		s.batcher.queue(cmd.ctx, cmd.channelID, cmd.responseURL, name, text)
+		return newPrivateMessage(fmt.Sprintf("Added %s as id %d, the channel will hear about it shortly.", name, e.ID)), nil
+	}
+	return newPublicMessage(text), nil
+}
+
+// addMany adds several people at once in a single write and announces
+// them together.
+func (s *server) addMany(cmd *command, names []string, count int, details addDetails) (msg, error) {
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	all := make([]string, 0, len(names)*count)
+	for _, name := range names {
+		for range count {
+			all = append(all, name)
+		}
+	}
+	added, err := s.store.Add(cmd.ctx, key, cmd.reporter(), details, all...)
+	if err != nil {
+		return msg{}, err
+	}
+	for i := count - 1; i < len(added); i += count {
+		s.notifyAdded(cmd, added[i], details)
+	}
+	text := fmt.Sprintf("Added %s to the queue.", englishList(names))
+	if count > 1 {
+		text = fmt.Sprintf("Added %s to the queue %d times each.", englishList(names), count)
+	}
+	return newPublicMessage(details.annotate(text)), nil
+}
+
+// splitReason separates the words of an add from the reason that follows
+// "because".
+func splitReason(words []string) ([]string, string) {
+	for i, w := range words {
+		if strings.EqualFold(w, "because") {
+			return words[:i], strings.Join(words[i+1:], " ")
+		}
+	}
+	return words, ""
+}
+
+// englishList joins names as in "alice, bob and carol".
+func englishList(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func (s *server) addBonus(cmd *command, key, name string, details addDetails) (msg, error) {
+	by := cmd.reporter()
+	added, err := s.store.Add(cmd.ctx, key, by, details, name, name)
+	if err != nil {
+		return msg{}, err
+	}
+	err = s.store.LogEvent(cmd.ctx, key, added[1].ID, "bonus")
+	if err != nil {
+		return msg{}, err
+	}
+	s.notifyAdded(cmd, added[1], details)
+	text := fmt.Sprintf("🎰 Double unlock! Added %s to the queue twice. +2", name)
+	return newPublicMessage(details.annotate(text)), nil
+}
+
+// addDetails are the optional reason and deadline given with an add.
+type addDetails struct {
+	reason string
+	due    time.Time
+}
+
+// annotate appends the details to the announcement of an add.
+func (d addDetails) annotate(text string) string {
+	if d.reason != "" {
+		text += fmt.Sprintf(" Reason: _%s_", escape(d.reason))
+	}
+	if !d.due.IsZero() {
+		text += fmt.Sprintf(" Due %s.", d.due.Format(timeFormat))
+	}
+	return text
+}
+
+// apply records the details on an entry as it is added. Adding the same
+// details again, as a count above one does, doesn't log them twice.
+func (d addDetails) apply(e *entry, now time.Time, by reporter) {
+	if d.reason != "" && e.Reason != d.reason {
+		e.Reason = d.reason
+		e.logBy("reason given", now, by)
+	}
+	if !d.due.IsZero() && !e.Due.Equal(d.due) {
+		e.Due = d.due
+		e.logBy("due "+d.due.Format(timeFormat), now, by)
+	}
+}
+
+func (s *server) del(cmd *command) (msg, error) {
+	if cmd.args == "" {
+		return msg{}, errUsage
+	}
+	n, err := strconv.ParseUint(cmd.args, 10, 64)
+	if err != nil {
+		var reply msg
+		var ok bool
+		n, reply, ok, err = s.entryByName(cmd)
+		if !ok || err != nil {
+			return reply, err
+		}
+	}
+	if s.confirmDeletes && !cmd.confirmed {
+		return s.confirmDelete(cmd, n)
+	}
+	e, err := s.store.Del(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, cmd.reporter())
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("Deleted %s (%d) from the queue.", e.Name, n)
+	if e.Count > 0 {
+		text = fmt.Sprintf("Took one off %s (%d), %d still owed.", e.Name, n, e.Count)
+	}
+	return newPublicMessage(text), nil
+}
+
+func (s *server) clear(cmd *command) (msg, error) {
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	if cmd.args != "" {
+		return msg{}, errUsage
+	}
+	key := s.backlogKey(cmd.teamID, cmd.channelID)
+	if !cmd.confirmed {
+		entries, err := s.store.List(cmd.ctx, key)
+		if err != nil {
+			return msg{}, err
+		}
+		if len(entries) == 0 {
+			return newPrivateMessage("The backlog is already empty."), nil
+		}
+		return s.confirmClear(cmd, entries), nil
+	}
+	cleared, err := s.store.Clear(cmd.ctx, key, cmd.reporter())
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("🧹 %s cleared the backlog, %s removed.", cmd.reporter(), plural(len(cleared), "entry", "entries"))
+	return newPublicMessage(text), nil
+}
+
+// entryByName finds the id of the entry named by the command's argument.
+// An exact match wins over a case-insensitive one. When there is no single
+// match it returns false and the message to reply with instead.
+func (s *server) entryByName(cmd *command) (uint64, msg, bool, error) {
+	name, _ := s.parseUser(cmd.ctx, cmd.teamID, cmd.args)
+	entries, err := s.store.List(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return 0, msg{}, false, err
+	}
+	var exact, folded []entry
+	for _, e := range entries {
+		switch {
+		case e.Name == name:
+			exact = append(exact, e)
+		case strings.EqualFold(e.Name, name):
+			folded = append(folded, e)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = folded
+	}
+	switch len(matches) {
+	case 0:
+		return 0, newPrivateMessage(fmt.Sprintf("%s isn't on the backlog.", name)), false, nil
+	case 1:
+		return matches[0].ID, msg{}, true, nil
+	}
+	lines := []string{fmt.Sprintf("Several entries match %s, use an id:", name)}
+	for _, e := range matches {
+		lines = append(lines, fmt.Sprintf("• %s (%d)", e.label(), e.ID))
+	}
+	return 0, newPrivateMessage(strings.Join(lines, "\n")), false, nil
+}
+
+// backlogKey returns the key of the backlog a command from the given team
+// and channel works on, empty for the shared backlog.
+func (s *server) backlogKey(team, channel string) string {
+	var parts []string
+	if s.multiTeam {
+		parts = append(parts, team)
+	}
+	if s.perChannel {
+		parts = append(parts, channel)
+	}
+	return strings.Join(parts, ":")
+}
+
+// post sends a message to a channel outside of a slash command response
+// with the team's bot, as a reply to threadTS when one is given, and
+// returns the message's timestamp. Nothing is posted to a quiet channel.
+func (s *server) post(ctx context.Context, team, channel, threadTS string, m msg) (string, error) {
+	if s.isQuiet(ctx, team, channel) {
+		return "", nil
+	}
+	client, err := s.slackFor(ctx, team)
+	if err != nil {
+		return "", err
+	}
+	return client.postMessage(ctx, channel, threadTS, m)
+}
+
+func abort(w http.ResponseWriter, code int) {
+	http.Error(w, http.StatusText(code), code)
+}
+
+func isCertCheck(req *http.Request) bool {
+	return req.Method == http.MethodGet && req.PostFormValue("ssl_check") == "1"
+}
+
+const timeFormat = "Jan 2 15:04"
+
+// parseDuration extends time.ParseDuration with d and w suffixes for
+// days and weeks, such as 3d or 2w.
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		n, ok := strings.CutSuffix(s, suffix)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(v * float64(unit)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// humanize formats a duration with its two most significant units.
+func humanize(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	minutes := int(d/time.Minute) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return "less than a minute"
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// publicFooter is appended to every public message when set.
+var publicFooter string
+
+const (
+	maxExcuseLength = 200
+	maxReasonLength = 200
+)
+
+var broadcastPattern = regexp.MustCompile(`<!(here|channel|everyone)(\|[^>]*)?>`)
+
+// sanitize flattens free text onto one line and defuses broadcast
+// mentions so stored text can't ping a whole channel when displayed.
+func sanitize(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return broadcastPattern.ReplaceAllString(s, "@$1")
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escape escapes the control characters Slack interprets in message text.
+func escape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+type msg struct {
+	Type        string       `json:"response_type,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+	Blocks      []block      `json:"blocks,omitempty"`
+
+	ReplaceOriginal bool `json:"replace_original,omitempty"`
+	DeleteOriginal  bool `json:"delete_original,omitempty"`
+}
+
+func newPublicMessage(text string) msg {
+	if publicFooter != "" {
+		text += "\n" + publicFooter
+	}
+	return msg{Type: "in_channel", Text: text}
+}
+
+func newPrivateMessage(text string) msg {
+	return msg{Type: "ephemeral", Text: text}
+}
+
+func render(w http.ResponseWriter, v msg) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, err = w.Write(b)
+	return err
+}
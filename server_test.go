@@ -0,0 +1,692 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testToken   = "token"
+	testAPIKey  = "key"
+	testSigning = "signing"
+	testAdmin   = "UADMIN"
+)
+
+type testServer struct {
+	*httptest.Server
+	s  *server
+	db *store
+}
+
+// newTestServer serves a server backed by a bolt file in a temporary
+// directory, as main would set it up with a token, an API key and one
+// admin. The options change the server before its routes are made.
+func newTestServer(t *testing.T, options ...func(s *server)) *testServer {
+	t.Helper()
+	db, err := openBolt(filepath.Join(t.TempDir(), "icecream.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := newSecret(testToken, "")
+	apiKey, _ := newSecret(testAPIKey, "")
+	signing, _ := newSecret(testSigning, "")
+	s := &server{
+		token:         token,
+		apiKey:        apiKey,
+		signingSecret: signing,
+		store:         db,
+		bolt:          db,
+		settle:        settleStrategies["chain"],
+		reserved:      make(map[string]bool),
+		admins:        map[string]bool{testAdmin: true},
+		retries:       newRetryCache(),
+		metrics:       newMetrics(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	ts := &testServer{Server: httptest.NewServer(s.routes()), s: s, db: db}
+	t.Cleanup(func() {
+		ts.Close()
+		db.Close()
+	})
+	return ts
+}
+
+func slashForm(user, text string) url.Values {
+	return url.Values{
+		"token":      {testToken},
+		"team_id":    {"T1"},
+		"channel_id": {"C1"},
+		"user_id":    {user},
+		"user_name":  {strings.ToLower(user)},
+		"text":       {text},
+	}
+}
+
+// post sends a slash command and returns the status and decoded reply.
+func (ts *testServer) post(t *testing.T, form url.Values, header http.Header) (int, msg) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m msg
+	if resp.StatusCode == http.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(&m)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, m
+}
+
+// slash runs the command as the user and fails the test unless it is
+// answered with 200.
+func (ts *testServer) slash(t *testing.T, user, text string) msg {
+	t.Helper()
+	code, m := ts.post(t, slashForm(user, text), nil)
+	if code != http.StatusOK {
+		t.Fatalf("%q: status %d", text, code)
+	}
+	return m
+}
+
+// api sends an API request with the key and decodes a JSON response
+// into v when v isn't nil.
+func (ts *testServer) api(t *testing.T, method, path, key string, body io.Reader, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode/100 == 2 {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// newResponseHook serves a response_url, returning its URL and the
+// messages posted to it. Messages past the first hundred unread are
+// dropped.
+func newResponseHook(t *testing.T) (string, chan msg) {
+	t.Helper()
+	replies := make(chan msg, 100)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var m msg
+		json.NewDecoder(req.Body).Decode(&m)
+		select {
+		case replies <- m:
+		default:
+		}
+	}))
+	t.Cleanup(hook.Close)
+	return hook.URL, replies
+}
+
+func (ts *testServer) entries(t *testing.T) []entry {
+	t.Helper()
+	entries, err := ts.db.List(t.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func wantText(t *testing.T, m msg, substr string) {
+	t.Helper()
+	if !strings.Contains(m.Text, substr) {
+		t.Errorf("reply %q doesn't contain %q", m.Text, substr)
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	ts := newTestServer(t)
+
+	m := ts.slash(t, "U1", "add alice")
+	wantText(t, m, "Added alice to the queue.")
+	if m.Type != "in_channel" {
+		t.Errorf("add replied %q, want in_channel", m.Type)
+	}
+	ts.slash(t, "U1", "add bob")
+	ts.slash(t, "U2", "add alice")
+	entries := ts.entries(t)
+	if len(entries) != 2 || entries[0].Name != "alice" || entries[0].count() != 2 || entries[1].Name != "bob" {
+		t.Fatalf("entries after adds = %+v", entries)
+	}
+
+	m = ts.slash(t, "U1", "list")
+	wantText(t, m, "1. alice")
+	wantText(t, m, "2. bob")
+
+	m = ts.slash(t, "U1", "del 1")
+	wantText(t, m, "alice")
+	if e := ts.entries(t)[0]; e.count() != 1 {
+		t.Errorf("alice's count after del = %d, want 1", e.count())
+	}
+	ts.slash(t, "U1", "del 1")
+	if entries := ts.entries(t); len(entries) != 1 || entries[0].Name != "bob" {
+		t.Fatalf("entries after dels = %+v", entries)
+	}
+
+	m = ts.slash(t, "U1", "clear")
+	wantText(t, m, "Only admins can do that.")
+	m = ts.slash(t, testAdmin, "clear")
+	if len(m.Attachments) != 1 || m.Attachments[0].CallbackID != clearCallback {
+		t.Fatalf("clear by an admin = %+v, want a confirmation", m)
+	}
+	if len(ts.entries(t)) != 1 {
+		t.Fatal("clear removed entries before it was confirmed")
+	}
+
+	hook, replies := newResponseHook(t)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"token":        testToken,
+		"callback_id":  clearCallback,
+		"actions":      []action{{Name: "confirm", Value: "clear"}},
+		"response_url": hook,
+		"user":         map[string]string{"id": testAdmin, "name": "admin"},
+		"channel":      map[string]string{"id": "C1"},
+		"team":         map[string]string{"id": "T1"},
+	})
+	resp, err := ts.Client().PostForm(ts.URL+"/interactive", url.Values{"payload": {string(payload)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case m = <-replies:
+		wantText(t, m, "cleared the backlog, 1 entry removed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the confirmed clear")
+	}
+	if entries := ts.entries(t); len(entries) != 0 {
+		t.Errorf("entries after clear = %+v", entries)
+	}
+}
+
+func TestInteractiveCancel(t *testing.T) {
+	ts := newTestServer(t)
+	for callback, want := range map[string]string{
+		deleteCallback:  "Cancelled, nothing was deleted.",
+		clearCallback:   "Cancelled, nothing was changed.",
+		amnestyCallback: "Cancelled, nothing was changed.",
+	} {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"token":       testToken,
+			"callback_id": callback,
+			"actions":     []action{{Name: "cancel"}},
+			"user":        map[string]string{"id": testAdmin, "name": "admin"},
+			"channel":     map[string]string{"id": "C1"},
+			"team":        map[string]string{"id": "T1"},
+		})
+		resp, err := ts.Client().PostForm(ts.URL+"/interactive", url.Values{"payload": {string(payload)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m msg
+		err = json.NewDecoder(resp.Body).Decode(&m)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Text != want {
+			t.Errorf("cancelled %s = %q, want %q", callback, m.Text, want)
+		}
+	}
+}
+
+func TestSlashCommandErrors(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U1", "add alice")
+
+	t.Run("bad token", func(t *testing.T) {
+		form := slashForm("U1", "add bob")
+		form.Set("token", "wrong")
+		code, _ := ts.post(t, form, nil)
+		if code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+	t.Run("bad signature", func(t *testing.T) {
+		form := slashForm("U1", "add bob")
+		form.Del("token")
+		header := signedHeader(form.Encode(), time.Now())
+		header.Set("X-Slack-Signature", "v0="+strings.Repeat("0", 64))
+		code, _ := ts.post(t, form, header)
+		if code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+	t.Run("signed", func(t *testing.T) {
+		form := slashForm("U1", "list")
+		form.Del("token")
+		code, m := ts.post(t, form, signedHeader(form.Encode(), time.Now()))
+		if code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		wantText(t, m, "alice")
+	})
+	t.Run("missing id", func(t *testing.T) {
+		m := ts.slash(t, "U1", "del 9")
+		wantText(t, m, "There is no entry with id 9.")
+		if m.Type != "ephemeral" {
+			t.Errorf("reply is %q, want ephemeral", m.Type)
+		}
+	})
+	t.Run("no id", func(t *testing.T) {
+		m := ts.slash(t, "U1", "del")
+		wantText(t, m, "/icecream del")
+	})
+	if entries := ts.entries(t); len(entries) != 1 || entries[0].Name != "alice" {
+		t.Errorf("entries after failed requests = %+v", entries)
+	}
+}
+
+func signedHeader(body string, now time.Time) http.Header {
+	stamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testSigning))
+	mac.Write([]byte("v0:" + stamp + ":" + body))
+	return http.Header{
+		"X-Slack-Request-Timestamp": {stamp},
+		"X-Slack-Signature":         {"v0=" + hex.EncodeToString(mac.Sum(nil))},
+	}
+}
+
+func TestAPIEntries(t *testing.T) {
+	ts := newTestServer(t)
+
+	var added apiEntry
+	code := ts.api(t, http.MethodPost, "/api/v1/entries", testAPIKey, strings.NewReader(`{"name":"alice"}`), &added)
+	if code != http.StatusCreated || added.Name != "alice" || added.ID != 1 {
+		t.Fatalf("add = %d %+v", code, added)
+	}
+	var list []apiEntry
+	code = ts.api(t, http.MethodGet, "/api/v1/entries", testAPIKey, nil, &list)
+	if code != http.StatusOK || len(list) != 1 || list[0].Name != "alice" {
+		t.Fatalf("list = %d %+v", code, list)
+	}
+
+	tests := []struct {
+		name, method, path, key string
+		want                    int
+	}{
+		{"bad key", http.MethodGet, "/api/v1/entries", "wrong", http.StatusUnauthorized},
+		{"no key", http.MethodGet, "/api/v1/entries", "", http.StatusUnauthorized},
+		{"missing id", http.MethodDelete, "/api/v1/entries/9", testAPIKey, http.StatusNotFound},
+		{"bad id", http.MethodDelete, "/api/v1/entries/x", testAPIKey, http.StatusNotFound},
+		{"del", http.MethodDelete, "/api/v1/entries/1", testAPIKey, http.StatusNoContent},
+		{"del again", http.MethodDelete, "/api/v1/entries/1", testAPIKey, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := ts.api(t, tt.method, tt.path, tt.key, nil, nil)
+			if code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.want)
+			}
+		})
+	}
+	if entries := ts.entries(t); len(entries) != 0 {
+		t.Errorf("entries after del = %+v", entries)
+	}
+}
+
+func TestExcuseEscaped(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U1", "add alice")
+	m := ts.slash(t, "U1", "excuse 1 <!channel> see <https://example.com|this> & <@U2>")
+	for _, raw := range []string{"<!channel>", "<https://", "<@U2>"} {
+		if strings.Contains(m.Text, raw) {
+			t.Errorf("excuse reply %q contains %q unescaped", m.Text, raw)
+		}
+	}
+	wantText(t, m, "&lt;@U2&gt;")
+	m = ts.slash(t, "U1", "info 1")
+	wantText(t, m, "Excuse: _@channel see &lt;https://example.com|this&gt; &amp; &lt;@U2&gt;_")
+
+	m = ts.slash(t, "U1", "add bob because <@U2> & <!here>")
+	wantText(t, m, "Reason: _&lt;@U2&gt; &amp; ")
+	m = ts.slash(t, "U1", "reason 1 see <https://example.com|this> & <@U2>")
+	wantText(t, m, "because _see &lt;https://example.com|this&gt; &amp; &lt;@U2&gt;_")
+	for _, text := range []string{"list", "show 1", "show 2"} {
+		m = ts.slash(t, "U1", text)
+		body := m.Text
+		for _, b := range m.Blocks {
+			if b.Text != nil {
+				body += "\n" + b.Text.Text
+			}
+		}
+		for _, raw := range []string{"<!here>", "<https://", "<@U2>"} {
+			if strings.Contains(body, raw) {
+				t.Errorf("%s reply %q contains %q unescaped", text, body, raw)
+			}
+		}
+	}
+	wantText(t, m, "Reason: _&lt;@U2&gt; &amp; ")
+}
+
+func TestMigrateTo(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.perChannel = true })
+	ts.slash(t, "U1", "add alice, bob")
+
+	m := ts.slash(t, "U1", "migrate-to <#C2|other>")
+	wantText(t, m, "Only admins can do that.")
+	m = ts.slash(t, testAdmin, "migrate-to #other")
+	wantText(t, m, "isn't a channel mention")
+	m = ts.slash(t, testAdmin, "migrate-to <#C1|here>")
+	wantText(t, m, "this channel's own backlog")
+
+	m = ts.slash(t, testAdmin, "migrate-to <#C2|other> --clear")
+	wantText(t, m, "moved 2 entries to <#C2>")
+	if m.Type != "in_channel" {
+		t.Errorf("reply is %q, want in_channel", m.Type)
+	}
+	moved, err := ts.db.List(t.Context(), "C2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, err := ts.db.List(t.Context(), "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 2 || len(left) != 0 {
+		t.Errorf("after migrate-to, C2 has %+v and C1 has %+v", moved, left)
+	}
+}
+
+func TestPaid(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U1", "add alice, bob, carol")
+
+	m := ts.slash(t, "U1", "paid 1 two")
+	wantText(t, m, "`two` isn't an id.")
+	m = ts.slash(t, "U1", "paid 1 3 9 --dry-run")
+	wantText(t, m, "Dry run, this would settle 2 ice creams: alice (1) and carol (3). There is no entry with id 9.")
+	if m.Type == "in_channel" || len(ts.entries(t)) != 3 {
+		t.Errorf("dry run replied %q with %d entries left", m.Type, len(ts.entries(t)))
+	}
+	m = ts.slash(t, "U1", "paid 1 3 9")
+	wantText(t, m, "✅ @u1 settled 2 ice creams: alice (1) and carol (3). There is no entry with id 9.")
+	if m.Type != "in_channel" {
+		t.Errorf("reply is %q, want in_channel", m.Type)
+	}
+	m = ts.slash(t, "U1", "paid 1 3")
+	wantText(t, m, "There are no entries with ids 1 and 3.")
+	m = ts.slash(t, "U1", "paid all")
+	wantText(t, m, "settled 1 ice cream: bob (2).")
+	m = ts.slash(t, "U1", "paid all")
+	wantText(t, m, "nothing to settle")
+}
+
+func TestTrimHistoryCommand(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.historyRetention = time.Hour })
+	ts.slash(t, "U1", "add alice")
+	m := ts.slash(t, "U1", "trim-history")
+	wantText(t, m, "Only admins can do that.")
+	m = ts.slash(t, testAdmin, "trim-history")
+	wantText(t, m, "There is no history older than 1h 0m to trim.")
+
+	ts = newTestServer(t, func(s *server) { s.historyRetention = time.Nanosecond })
+	ts.slash(t, "U1", "add alice")
+	m = ts.slash(t, testAdmin, "trim-history")
+	wantText(t, m, "Trimmed 1 history record older than")
+	if records, _ := ts.db.History(t.Context(), "", 10); len(records) != 0 {
+		t.Errorf("history after trim = %+v, want none", records)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U1", "add alice")
+	ts.slash(t, "U2", "add alice")
+	ts.slash(t, "U1", "add alice, bob")
+
+	m := ts.slash(t, "U1", "between @u1 Alice")
+	wantText(t, m, "@u1 added Alice 2 times:\n• 1. ")
+	if m.Type == "in_channel" || strings.Count(m.Text, "• 1. ") != 2 {
+		t.Errorf("between replied %q %q, want two private lines for entry 1", m.Type, m.Text)
+	}
+	m = ts.slash(t, "U1", "between u2 alice")
+	wantText(t, m, "u2 added alice 1 time:")
+	m = ts.slash(t, "U1", "between u2 bob")
+	wantText(t, m, "Nothing on the backlog was added by u2 for bob.")
+	m = ts.slash(t, "U1", "between u2")
+	wantText(t, m, "Usage:")
+}
+
+func TestFirstTimer(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.undoWindow = time.Minute })
+	cmd := &command{userID: "U1", userName: "u1", teamID: "T1", channelID: "C1"}
+	first := func() bool {
+		t.Helper()
+		entries := ts.entries(t)
+		ok, err := ts.s.firstTimer(cmd, entries[len(entries)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	ts.slash(t, "U1", "add alice --count 2")
+	if !first() {
+		t.Error("first add of alice isn't a first timer")
+	}
+	ts.slash(t, "U1", "add Alice")
+	if first() {
+		t.Error("second add of alice is a first timer")
+	}
+	ts.slash(t, "U1", "paid all")
+	ts.slash(t, "U1", "add alice")
+	if first() {
+		t.Error("add of alice after paying is a first timer")
+	}
+	ts.slash(t, "U1", "add bob")
+	ts.slash(t, "U1", "undo")
+	ts.slash(t, "U1", "add bob")
+	if !first() {
+		t.Error("add of bob after an undone add isn't a first timer")
+	}
+	wantText(t, welcomeMessage(cmd, entry{Name: "bob"}, addDetails{reason: "lunch"}), "Welcome to the ice cream backlog! @u1 added you in <#C1>. Reason: _lunch_")
+}
+
+func TestUndoCommandAddMany(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.undoWindow = time.Minute })
+	ts.slash(t, "U1", "add alice")
+	ts.slash(t, "U1", "add alice, bob carol")
+	m := ts.slash(t, "U1", "undo")
+	wantText(t, m, "↩️ Undid the 3 adds of alice, bob and carol.")
+	ts.slash(t, "U1", "add bob --count 3")
+	m = ts.slash(t, "U1", "undo")
+	wantText(t, m, "↩️ Undid the 3 adds of bob.")
+	entries := ts.entries(t)
+	if len(entries) != 1 || entries[0].Name != "alice" || entries[0].count() != 1 {
+		t.Errorf("entries after undo = %+v, want alice ×1", entries)
+	}
+}
+
+func TestQuietByTeam(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.multiTeam = true })
+	m := ts.slash(t, "U1", "quiet 1h")
+	wantText(t, m, "Quiet mode until")
+	m = ts.slash(t, "U1", "add alice")
+	if m.Type == "in_channel" {
+		t.Error("add in a quiet channel replied in the channel")
+	}
+	form := slashForm("U1", "add bob")
+	form.Set("team_id", "T2")
+	_, m = ts.post(t, form, nil)
+	if m.Type != "in_channel" {
+		t.Errorf("add in another team's channel with the same id replied %q, want in_channel", m.Type)
+	}
+}
+
+func TestAmnesty(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U1", "add alice, bob --count 2")
+
+	m := ts.slash(t, "U1", "amnesty")
+	wantText(t, m, "Only admins can do that.")
+	m = ts.slash(t, testAdmin, "amnesty summer party --dry-run")
+	wantText(t, m, "Dry run, amnesty would settle 4 ice creams owed by 2 people: alice ×2 and bob ×2.")
+	m = ts.slash(t, testAdmin, "amnesty summer party")
+	if len(m.Attachments) != 1 || m.Attachments[0].CallbackID != amnestyCallback {
+		t.Fatalf("amnesty = %+v, want a confirmation", m)
+	}
+	if len(ts.entries(t)) != 2 {
+		t.Fatal("amnesty settled entries before it was confirmed")
+	}
+
+	hook, replies := newResponseHook(t)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"token":        testToken,
+		"callback_id":  amnestyCallback,
+		"actions":      []action{{Name: "confirm", Value: m.Attachments[0].Actions[0].Value}},
+		"response_url": hook,
+		"user":         map[string]string{"id": testAdmin, "name": "admin"},
+		"channel":      map[string]string{"id": "C1"},
+		"team":         map[string]string{"id": "T1"},
+	})
+	resp, err := ts.Client().PostForm(ts.URL+"/interactive", url.Values{"payload": {string(payload)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case m = <-replies:
+		wantText(t, m, "🎉 Amnesty granted to 2 people! _summer party_")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the confirmed amnesty")
+	}
+	if entries := ts.entries(t); len(entries) != 0 {
+		t.Errorf("entries after amnesty = %+v", entries)
+	}
+	_, ledger, err := ts.db.Activity(t.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger) != 4 || ledger[0].Note != "summer party" || !ledger[0].Events[len(ledger[0].Events)-1].Time.Equal(ledger[3].Events[len(ledger[3].Events)-1].Time) {
+		t.Errorf("ledger after amnesty = %+v, want 4 debts sharing the note and time", ledger)
+	}
+	m = ts.slash(t, testAdmin, "amnesty")
+	wantText(t, m, "already empty")
+}
+
+func TestMetricsLatency(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.store = &timedStore{Store: s.store, metrics: s.metrics} })
+	ts.slash(t, "U1", "add alice")
+	ts.slash(t, "U1", "show 9")
+
+	resp, err := ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`icecream_command_duration_seconds_count{command="add"} 1`,
+		`icecream_command_duration_seconds_bucket{command="show",le="+Inf"} 1`,
+		`icecream_store_duration_seconds_count{command="add",op="Add"} 1`,
+		`icecream_store_duration_seconds_bucket{command="add",op="Add",le="+Inf"} 1`,
+		`icecream_store_duration_seconds_count{command="show",op="Get"} 1`,
+		`icecream_store_errors_total{command="show",op="Get"} 0`,
+		`# HELP icecream_store_duration_seconds Time spent in the store, by command and operation.`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics don't contain %s:\n%s", want, b)
+		}
+	}
+}
+
+func TestWhoAdded(t *testing.T) {
+	ts := newTestServer(t)
+	ts.slash(t, "U2", "add alice")
+
+	m := ts.slash(t, "U1", "whoadded 1")
+	wantText(t, m, "Entry 1 (alice) was added by @u2, less than a minute ago.")
+	if m.Type == "in_channel" {
+		t.Error("whoadded replied in the channel")
+	}
+	m = ts.slash(t, "U1", "whoadded 2")
+	wantText(t, m, "There is no entry with id 2.")
+	m = ts.slash(t, "U1", "whoadded alice")
+	wantText(t, m, "Usage:")
+}
+
+func TestRivalry(t *testing.T) {
+	ts := newTestServer(t, func(s *server) { s.undoWindow = time.Minute })
+	ts.slash(t, "U1", "add u2")
+	ts.slash(t, "U1", "add u2")
+	ts.slash(t, "U2", "add u1")
+	ts.slash(t, "U3", "add u2")
+	ts.slash(t, "U1", "add u2")
+	ts.slash(t, "U1", "undo")
+
+	m := ts.slash(t, "U3", "rivalry u1 U2")
+	wantText(t, m, "⚔️ u1 has added U2 2 times, U2 has added u1 1 time. u1 leads the rivalry.")
+	if m.Type != "in_channel" {
+		t.Errorf("reply is %q, want in_channel", m.Type)
+	}
+	m = ts.slash(t, "U3", "rivalry u1 u3")
+	wantText(t, m, "u1 and u3 have never added each other.")
+	m = ts.slash(t, "U3", "rivalry u1 u1")
+	wantText(t, m, "A rivalry takes two different people.")
+	m = ts.slash(t, "U3", "rivalry u1")
+	wantText(t, m, "Usage:")
+}
+
+func TestConfigPublic(t *testing.T) {
+	ts := newTestServer(t)
+	m := ts.slash(t, "U1", "config public off")
+	wantText(t, m, "Only admins can do that.")
+	m = ts.slash(t, testAdmin, "config public off")
+	wantText(t, m, "now only shown to whoever ran the command")
+
+	m = ts.slash(t, "U1", "add alice")
+	if m.Type != "ephemeral" {
+		t.Errorf("add in a private channel replied %q, want ephemeral", m.Type)
+	}
+	form := slashForm("U1", "add bob")
+	form.Set("channel_id", "C2")
+	if _, m = ts.post(t, form, nil); m.Type != "in_channel" {
+		t.Errorf("add in another channel replied %q, want in_channel", m.Type)
+	}
+	m = ts.slash(t, "U1", "config public")
+	wantText(t, m, "only shown to whoever ran the command")
+
+	m = ts.slash(t, testAdmin, "config public on")
+	wantText(t, m, "public again")
+	if m.Type != "in_channel" {
+		t.Errorf("config public on replied %q, want in_channel", m.Type)
+	}
+}
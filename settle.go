@@ -0,0 +1,44 @@
+package main
+
+type assignment struct {
+	from string
+	to   string
+}
+
+type settler func(names []string) []assignment
+
+var settleStrategies = map[string]settler{
+	"chain": settleChain,
+	"pairs": settlePairs,
+	"top":   settleTop,
+}
+
+func settleChain(names []string) []assignment {
+	rv := make([]assignment, len(names))
+	for i, name := range names {
+		rv[i] = assignment{name, names[(i+1)%len(names)]}
+	}
+	return rv
+}
+
+func settlePairs(names []string) []assignment {
+	var rv []assignment
+	n := len(names)
+	if n%2 == 1 {
+		// The odd one out joins the last pair as a chain of three.
+		rv = settleChain(names[n-3:])
+		n -= 3
+	}
+	for i := 0; i < n; i += 2 {
+		rv = append(rv, assignment{names[i], names[i+1]}, assignment{names[i+1], names[i]})
+	}
+	return rv
+}
+
+func settleTop(names []string) []assignment {
+	rv := make([]assignment, len(names)-1)
+	for i, name := range names[1:] {
+		rv[i] = assignment{names[0], name}
+	}
+	return rv
+}
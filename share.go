@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultShareTTL = 24 * time.Hour
+
+var sharedTemplate = template.Must(template.New("shared").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Ice cream backlog</title>
+</head>
+<body>
+<h1>Ice cream backlog</h1>
+{{if .Entries}}<ol>
+{{range .Entries}}<li value="{{.ID}}">{{.Name}}{{if gt .Count 1}} ×{{.Count}}{{end}}</li>
+{{end}}</ol>
+{{else}}<p>The backlog is empty. Tread lightly.</p>
+{{end}}<p><small>Snapshot link valid until {{.Expires.Format "Jan 2 15:04 MST"}}.</small></p>
+</body>
+</html>
+`))
+
+// shareSignature signs a link to the team's channel. The team is only part
+// of the signed text when set, so links made before teams were kept apart
+// stay valid.
+func (s *server) shareSignature(team, channel string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(s.shareSecret.load()))
+	if team != "" {
+		fmt.Fprintf(mac, "%s\n", team)
+	}
+	fmt.Fprintf(mac, "%s\n%d", channel, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareQuery returns the signed query that lets a link read the team's
+// channel until ttl from now.
+func (s *server) shareQuery(team, channel string, ttl time.Duration) url.Values {
+	exp := time.Now().Add(ttl).Unix()
+	v := url.Values{
+		"channel": {channel},
+		"exp":     {strconv.FormatInt(exp, 10)},
+		"sig":     {s.shareSignature(team, channel, exp)},
+	}
+	if team != "" {
+		v.Set("team", team)
+	}
+	return v
+}
+
+func (s *server) shareURL(team, channel string, ttl time.Duration) string {
+	return s.publicURL + "/shared?" + s.shareQuery(team, channel, ttl).Encode()
+}
+
+// verifyShare checks the request's signed query, returning the team and
+// channel it may read and when it stops being valid.
+func (s *server) verifyShare(req *http.Request) (team, channel string, exp int64, ok bool) {
+	team, channel = req.FormValue("team"), req.FormValue("channel")
+	exp, err := strconv.ParseInt(req.FormValue("exp"), 10, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	sig, err := hex.DecodeString(req.FormValue("sig"))
+	want, _ := hex.DecodeString(s.shareSignature(team, channel, exp))
+	if err != nil || !hmac.Equal(sig, want) || time.Now().Unix() > exp {
+		return "", "", 0, false
+	}
+	return team, channel, exp, true
+}
+
+func (s *server) share(cmd *command) (msg, error) {
+	if s.shareSecret == nil {
+		return newPrivateMessage("Share links are not enabled on this server."), nil
+	}
+	ttl := defaultShareTTL
+	if cmd.args != "" {
+		d, err := parseDuration(cmd.args)
+		if err != nil || d <= 0 {
+			return msg{}, errUsage
+		}
+		ttl = d
+	}
+	text := fmt.Sprintf("Read-only link to this backlog, valid for %s:\n%s", humanize(ttl), s.shareURL(cmd.teamID, cmd.channelID, ttl))
+	return newPrivateMessage(text), nil
+}
+
+func (s *server) handleShareLink(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		abort(w, http.StatusMethodNotAllowed)
+		return
+	}
+	ttl := defaultShareTTL
+	if v := req.FormValue("ttl"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(map[string]string{"url": s.shareURL(req.FormValue("team"), req.FormValue("channel"), ttl)})
+	if err != nil {
+		logger(req.Context()).Error("share link failed", "err", err)
+	}
+}
+
+func (s *server) handleShared(w http.ResponseWriter, req *http.Request) {
+	team, channel, exp, ok := s.verifyShare(req)
+	if !ok {
+		abort(w, http.StatusForbidden)
+		return
+	}
+	entries, err := s.store.List(req.Context(), s.backlogKey(team, channel))
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("shared view failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if req.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		type item struct {
+			ID    uint64 `json:"id"`
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		items := make([]item, len(entries))
+		for i, e := range entries {
+			items[i] = item{e.ID, e.Name, e.count()}
+		}
+		err = json.NewEncoder(w).Encode(items)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = sharedTemplate.Execute(w, struct {
+			Entries []entry
+			Expires time.Time
+		}{entries, time.Unix(exp, 0)})
+	}
+	if err != nil {
+		logger(req.Context()).Error("shared view failed", "err", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// signatureWindow bounds how old a signed request may be, so a
+	// captured request can't be replayed later.
+	signatureWindow = 5 * time.Minute
+
+	maxSignedBody = 1 << 20
+)
+
+var errBadSignature = errors.New("invalid slack signature")
+
+// verifySignature checks Slack's X-Slack-Signature header, an HMAC-SHA256
+// of the version, request timestamp and raw body keyed by the signing
+// secret.
+func verifySignature(key *secret, h http.Header, body []byte, now time.Time) error {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > signatureWindow || d < -signatureWindow {
+		return errBadSignature
+	}
+	want := key.load()
+	if want == "" {
+		return errBadSignature
+	}
+	mac := hmac.New(sha256.New, []byte(want))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	sig := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(h.Get("X-Slack-Signature"))) {
+		return errBadSignature
+	}
+	return nil
+}
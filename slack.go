@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	slackAPI = "https://slack.com/api/"
+	// slackTimeout bounds each Slack API call, including waiting for a
+	// free outbound slot.
+	slackTimeout = 10 * time.Second
+)
+
+type slackClient struct {
+	token  string
+	base   string
+	client *http.Client
+}
+
+func newSlackClient(token string) *slackClient {
+	return &slackClient{token: token, base: slackAPI, client: &http.Client{Timeout: slackTimeout}}
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (c *slackClient) call(ctx context.Context, method string, params url.Values, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, slackTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := sendOutbound(c.client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body json.RawMessage
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return err
+	}
+	var r slackResponse
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return err
+	}
+	if !r.OK {
+		return errors.New("slack: " + method + ": " + r.Error)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (c *slackClient) authTest(ctx context.Context) (string, error) {
+	var r struct {
+		UserID string `json:"user_id"`
+	}
+	err := c.call(ctx, "auth.test", url.Values{}, &r)
+	return r.UserID, err
+}
+
+func (c *slackClient) postMessage(ctx context.Context, channel, threadTS string, m msg) (string, error) {
+	params := url.Values{
+		"channel": {channel},
+		"text":    {m.Text},
+	}
+	if threadTS != "" {
+		params.Set("thread_ts", threadTS)
+	}
+	var r struct {
+		TS string `json:"ts"`
+	}
+	err := c.call(ctx, "chat.postMessage", params, &r)
+	return r.TS, err
+}
+
+// openIM returns the id of the bot's direct message channel with the user.
+func (c *slackClient) openIM(ctx context.Context, user string) (string, error) {
+	var r struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := c.call(ctx, "conversations.open", url.Values{"users": {user}}, &r)
+	return r.Channel.ID, err
+}
+
+func (c *slackClient) postEphemeral(ctx context.Context, channel, user, threadTS string, m msg) error {
+	params := url.Values{
+		"channel": {channel},
+		"user":    {user},
+		"text":    {m.Text},
+	}
+	if threadTS != "" {
+		params.Set("thread_ts", threadTS)
+	}
+	return c.call(ctx, "chat.postEphemeral", params, nil)
+}
+
+// parseMention normalizes Slack's escaped mention syntax. User mentions
+// such as <@U123|bob> become <@U123> and the user id is returned, while
+// special mentions such as <!here> become @here.
+func parseMention(s string) (name, userID string) {
+	if !strings.HasPrefix(s, "<") || !strings.HasSuffix(s, ">") {
+		return s, ""
+	}
+	inner := s[1 : len(s)-1]
+	if i := strings.Index(inner, "|"); i >= 0 {
+		inner = inner[:i]
+	}
+	switch {
+	case strings.HasPrefix(inner, "@"):
+		return "<" + inner + ">", inner[1:]
+	case strings.HasPrefix(inner, "!"):
+		return "@" + inner[1:], ""
+	}
+	return s, ""
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// spotlight sets or, with a zero time, clears the entry's spotlight.
+func (db backlog) spotlight(id uint64, until time.Time) (entry, error) {
+	return db.update(id, func(e *entry) error {
+		e.setSpotlight(until, time.Now())
+		return nil
+	})
+}
+
+func (e *entry) setSpotlight(until, now time.Time) {
+	e.SpotlightUntil = until
+	if until.IsZero() {
+		e.log("spotlight cleared", now)
+	} else {
+		e.log("spotlighted", now)
+	}
+}
+
+// ClearSpotlights clears spotlights in every backlog that expired before
+// now and returns the entries that were changed.
+func (db *store) ClearSpotlights(ctx context.Context, now time.Time) ([]entry, error) {
+	db = db.with(ctx)
+	var cleared []entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if !db.isBacklog(name) {
+				return nil
+			}
+			var expired []entry
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				e, err := decodeEntry(k, v)
+				if err != nil {
+					return err
+				}
+				if e.SpotlightUntil.IsZero() || e.SpotlightUntil.After(now) {
+					continue
+				}
+				e.SpotlightUntil = time.Time{}
+				e.log("spotlight expired", now)
+				expired = append(expired, e)
+			}
+			for _, e := range expired {
+				err := putEntry(bucket, e)
+				if err != nil {
+					return err
+				}
+			}
+			cleared = append(cleared, expired...)
+			return nil
+		})
+	})
+	return cleared, err
+}
+
+func (s *server) spotlight(cmd *command) (msg, error) {
+	id, arg, _ := strings.Cut(cmd.args, " ")
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return msg{}, errUsage
+	}
+	var until time.Time
+	arg = strings.TrimSpace(arg)
+	if arg != "off" {
+		d, err := parseDuration(arg)
+		if err != nil || d <= 0 {
+			return msg{}, errUsage
+		}
+		until = time.Now().Add(d)
+	}
+	e, err := s.store.Spotlight(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), n, until)
+	if err == errNotFound {
+		text := fmt.Sprintf("There is no entry with id %d.", n)
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("🔦 %s (%d) is in the spotlight, %s left to buy.", e.Name, e.ID, humanize(time.Until(until)))
+	if until.IsZero() {
+		text = fmt.Sprintf("%s (%d) is out of the spotlight.", e.Name, e.ID)
+	}
+	return newPublicMessage(text), nil
+}
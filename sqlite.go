@@ -0,0 +1,33 @@
+package main
+
+import _ "modernc.org/sqlite"
+
+// sqliteDialect uses a single connection so writers never see a busy
+// database. WAL mode keeps the file usable with replication tools such as
+// litestream.
+var sqliteDialect = sqlDialect{
+	name:    "sqlite",
+	maxOpen: 1,
+	schema: []string{
+		`PRAGMA journal_mode = WAL`,
+		`CREATE TABLE IF NOT EXISTS version (id INTEGER PRIMARY KEY, n INTEGER NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS sequences (name TEXT PRIMARY KEY, n INTEGER NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS entries (
+			backlog TEXT NOT NULL,
+			id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (backlog, id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS history (seq INTEGER PRIMARY KEY AUTOINCREMENT, backlog TEXT NOT NULL, data TEXT NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS history_backlog ON history (backlog, seq)`,
+		`CREATE TABLE IF NOT EXISTS undo (backlog TEXT NOT NULL, user_id TEXT NOT NULL, data TEXT NOT NULL, PRIMARY KEY (backlog, user_id))`,
+		`CREATE TABLE IF NOT EXISTS scheduled (id INTEGER PRIMARY KEY, backlog TEXT NOT NULL, data TEXT NOT NULL)`,
+		`DROP TABLE IF EXISTS quiet`,
+		`CREATE TABLE IF NOT EXISTS quiet_channels (team TEXT NOT NULL, channel TEXT NOT NULL, until_unix INTEGER NOT NULL, PRIMARY KEY (team, channel))`,
+		`CREATE TABLE IF NOT EXISTS teams (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS team_config (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS digests (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS notify_optout (team TEXT NOT NULL, user_id TEXT NOT NULL, PRIMARY KEY (team, user_id))`,
+	},
+}
@@ -0,0 +1,1257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sqlStore keeps the backlogs in a SQL database. Entries and the records
+// around them are stored as the same JSON the bolt store writes.
+//
+// Every write transaction first bumps the version row, which serializes
+// writers the way bolt does even when several replicas share a database.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	idStart uint64
+	closed  atomic.Bool
+}
+
+type sqlDialect struct {
+	name   string
+	schema []string
+	// numbered placeholders such as $1 instead of ?
+	numbered bool
+	// maxOpen limits open connections, 0 is unlimited
+	maxOpen int
+}
+
+var _ Store = (*sqlStore)(nil)
+
+func openSQL(d sqlDialect, dsn string, idStart uint64) (*sqlStore, error) {
+	db, err := sql.Open(d.name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(d.maxOpen)
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, stmt := range d.schema {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	_, err = db.Exec(d.bind("INSERT INTO version (id, n) VALUES (1, 0) ON CONFLICT (id) DO NOTHING"))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: d, idStart: idStart}, nil
+}
+
+// bind rewrites ? placeholders for dialects that number them.
+func (d sqlDialect) bind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type sqlTx struct {
+	*sql.Tx
+	dialect sqlDialect
+}
+
+func (tx sqlTx) exec(query string, args ...any) error {
+	_, err := tx.Exec(tx.dialect.bind(query), args...)
+	return err
+}
+
+func (tx sqlTx) queryRow(query string, args ...any) *sql.Row {
+	return tx.QueryRow(tx.dialect.bind(query), args...)
+}
+
+func (tx sqlTx) query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Query(tx.dialect.bind(query), args...)
+}
+
+func (db *sqlStore) view(ctx context.Context, fn func(tx sqlTx) error) error {
+	return db.run(ctx, false, fn)
+}
+
+func (db *sqlStore) update(ctx context.Context, fn func(tx sqlTx) error) error {
+	return db.run(ctx, true, fn)
+}
+
+func (db *sqlStore) run(ctx context.Context, write bool, fn func(tx sqlTx) error) error {
+	if db.closed.Load() {
+		return errShuttingDown
+	}
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	t, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqlClosedErr(err)
+	}
+	tx := sqlTx{t, db.dialect}
+	if write {
+		err = tx.exec("UPDATE version SET n = n + 1 WHERE id = 1")
+		if err != nil {
+			tx.Rollback()
+			return sqlClosedErr(err)
+		}
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return sqlClosedErr(err)
+	}
+	return sqlClosedErr(tx.Commit())
+}
+
+func sqlClosedErr(err error) error {
+	if errors.Is(err, sql.ErrConnDone) {
+		return errShuttingDown
+	}
+	return err
+}
+
+func (db *sqlStore) Close() error {
+	db.closed.Store(true)
+	return db.db.Close()
+}
+
+func (db *sqlStore) Version(ctx context.Context) (int, error) {
+	var v int
+	err := db.view(ctx, func(tx sqlTx) error {
+		return tx.queryRow("SELECT n FROM version WHERE id = 1").Scan(&v)
+	})
+	return v, err
+}
+
+// nextID returns the next id in the named sequence, starting a new one at
+// the configured id offset.
+func (db *sqlStore) nextID(tx sqlTx, seq string) (uint64, error) {
+	err := tx.exec("INSERT INTO sequences (name, n) VALUES (?, ?) ON CONFLICT (name) DO NOTHING", seq, db.idStart)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.exec("UPDATE sequences SET n = n + 1 WHERE name = ?", seq)
+	if err != nil {
+		return 0, err
+	}
+	var id uint64
+	err = tx.queryRow("SELECT n FROM sequences WHERE name = ?", seq).Scan(&id)
+	return id, err
+}
+
+func scanEntries(rows *sql.Rows) ([]entry, error) {
+	defer rows.Close()
+	var entries []entry
+	for rows.Next() {
+		var id uint64
+		var data string
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeEntry(itob(id), []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (db *sqlStore) listTx(tx sqlTx, key string) ([]entry, error) {
+	rows, err := tx.query("SELECT id, data FROM entries WHERE backlog = ? ORDER BY id", key)
+	if err != nil {
+		return nil, err
+	}
+	return scanEntries(rows)
+}
+
+func (db *sqlStore) getTx(tx sqlTx, key string, id uint64) (entry, error) {
+	var data string
+	err := tx.queryRow("SELECT data FROM entries WHERE backlog = ? AND id = ?", key, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return entry{}, errNotFound
+	}
+	if err != nil {
+		return entry{}, err
+	}
+	return decodeEntry(itob(id), []byte(data))
+}
+
+func (db *sqlStore) putTx(tx sqlTx, key string, e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.exec(`INSERT INTO entries (backlog, id, name, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (backlog, id) DO UPDATE SET name = excluded.name, data = excluded.data`, key, e.ID, e.Name, string(b))
+}
+
+func (db *sqlStore) insertTx(tx sqlTx, key string, e entry) (uint64, error) {
+	var err error
+	e.ID, err = db.nextID(tx, key)
+	if err != nil {
+		return 0, err
+	}
+	return e.ID, db.putTx(tx, key, e)
+}
+
+func (db *sqlStore) deleteTx(tx sqlTx, key string, id uint64) error {
+	return tx.exec("DELETE FROM entries WHERE backlog = ? AND id = ?", key, id)
+}
+
+// addEntry is the SQL counterpart of the bolt addEntry.
+func (db *sqlStore) addEntry(tx sqlTx, key, name string, fn func(e *entry)) (entry, error) {
+	e, found, err := db.findTx(tx, key, name)
+	if err != nil {
+		return entry{}, err
+	}
+	if found {
+		e.Count = e.count() + 1
+		fn(&e)
+		return e, db.putTx(tx, key, e)
+	}
+	e = entry{Name: name, Count: 1}
+	fn(&e)
+	e.ID, err = db.insertTx(tx, key, e)
+	return e, err
+}
+
+// findTx returns the entry with the given name, ignoring case.
+func (db *sqlStore) findTx(tx sqlTx, key, name string) (entry, bool, error) {
+	entries, err := db.listTx(tx, key)
+	if err != nil {
+		return entry{}, false, err
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.Name, name) {
+			return e, true, nil
+		}
+	}
+	return entry{}, false, nil
+}
+
+func (db *sqlStore) record(tx sqlTx, key, action string, e entry, t time.Time, by reporter) error {
+	b, err := json.Marshal(historyRecord{action, e.ID, e.Name, t, by})
+	if err != nil {
+		return err
+	}
+	return tx.exec("INSERT INTO history (backlog, data) VALUES (?, ?)", key, string(b))
+}
+
+func (db *sqlStore) remember(tx sqlTx, key string, by reporter, c lastChange) error {
+	if by.ID == "" || len(c.IDs) == 0 && c.Action == "added" {
+		return nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = tx.exec("DELETE FROM undo WHERE backlog = ? AND user_id = ?", key, by.ID)
+	if err != nil {
+		return err
+	}
+	return tx.exec("INSERT INTO undo (backlog, user_id, data) VALUES (?, ?, ?)", key, by.ID, string(b))
+}
+
+func (db *sqlStore) Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error) {
+	added := make([]entry, len(names))
+	err := db.update(ctx, func(tx sqlTx) error {
+		now := time.Now()
+		for i, name := range names {
+			e, err := db.addEntry(tx, key, name, func(e *entry) {
+				e.logBy("added", now, by)
+				d.apply(e, now, by)
+			})
+			if err != nil {
+				return err
+			}
+			err = db.record(tx, key, "added", e, now, by)
+			if err != nil {
+				return err
+			}
+			added[i] = e
+		}
+		return db.remember(tx, key, by, addedChange(added, now))
+	})
+	return added, err
+}
+
+func (db *sqlStore) Del(ctx context.Context, key string, id uint64, by reporter) (entry, error) {
+	var e entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		var err error
+		e, err = db.getTx(tx, key, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = db.record(tx, key, "deleted", e, now, by)
+		if err != nil {
+			return err
+		}
+		err = db.remember(tx, key, by, lastChange{Action: "deleted", EntryID: e.ID, Entry: e, Time: now})
+		if err != nil {
+			return err
+		}
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			return db.deleteTx(tx, key, id)
+		}
+		e.logBy("deleted one", now, by)
+		return db.putTx(tx, key, e)
+	})
+	return e, err
+}
+
+func (db *sqlStore) Get(ctx context.Context, key string, id uint64) (entry, error) {
+	var e entry
+	err := db.view(ctx, func(tx sqlTx) error {
+		var err error
+		e, err = db.getTx(tx, key, id)
+		return err
+	})
+	return e, err
+}
+
+func (db *sqlStore) Modify(ctx context.Context, key string, id uint64, fn func(e *entry) error) (entry, error) {
+	var e entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		var err error
+		e, err = db.getTx(tx, key, id)
+		if err != nil {
+			return err
+		}
+		err = fn(&e)
+		if err != nil {
+			return err
+		}
+		return db.putTx(tx, key, e)
+	})
+	return e, err
+}
+
+func (db *sqlStore) Pin(ctx context.Context, key string, id uint64, pinned bool) (entry, error) {
+	return db.Modify(ctx, key, id, func(e *entry) error {
+		e.setPinned(pinned, time.Now())
+		return nil
+	})
+}
+
+func (db *sqlStore) Spotlight(ctx context.Context, key string, id uint64, until time.Time) (entry, error) {
+	return db.Modify(ctx, key, id, func(e *entry) error {
+		e.setSpotlight(until, time.Now())
+		return nil
+	})
+}
+
+func (db *sqlStore) LogEvent(ctx context.Context, key string, id uint64, action string) error {
+	_, err := db.Modify(ctx, key, id, func(e *entry) error {
+		e.log(action, time.Now())
+		return nil
+	})
+	return err
+}
+
+func (db *sqlStore) List(ctx context.Context, key string) ([]entry, error) {
+	var entries []entry
+	err := db.view(ctx, func(tx sqlTx) error {
+		var err error
+		entries, err = db.listTx(tx, key)
+		return err
+	})
+	return entries, err
+}
+
+func archiveKey(key string) string {
+	return "archive:" + key
+}
+
+func (db *sqlStore) Pay(ctx context.Context, key string, id uint64, name string, by reporter) (entry, error) {
+	var e entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		entries, err := db.listTx(tx, key)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, candidate := range entries {
+			if candidate.ID == id || (id == 0 && strings.EqualFold(candidate.Name, name)) {
+				e, found = candidate, true
+				break
+			}
+		}
+		if !found {
+			return errNotFound
+		}
+		now := time.Now()
+		e.logBy("paid", now, by)
+		err = db.record(tx, key, "paid", e, now, by)
+		if err != nil {
+			return err
+		}
+		paid := e
+		paid.Count = 1
+		_, err = db.insertTx(tx, archiveKey(key), paid)
+		if err != nil {
+			return err
+		}
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			return db.deleteTx(tx, key, e.ID)
+		}
+		return db.putTx(tx, key, e)
+	})
+	return e, err
+}
+
+func (db *sqlStore) Settle(ctx context.Context, key string, ids []uint64, action, note string, by reporter) ([]entry, []uint64, error) {
+	var settled []entry
+	var missing []uint64
+	err := db.update(ctx, func(tx sqlTx) error {
+		settled, missing = nil, nil
+		if ids == nil {
+			var err error
+			settled, err = db.listTx(tx, key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			e, err := db.getTx(tx, key, id)
+			if err == errNotFound {
+				missing = append(missing, id)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			settled = append(settled, e)
+		}
+		now := time.Now()
+		for _, e := range settled {
+			for _, paid := range settleDebts(&e, action, note, now, by) {
+				err := db.record(tx, key, action, e, now, by)
+				if err != nil {
+					return err
+				}
+				_, err = db.insertTx(tx, archiveKey(key), paid)
+				if err != nil {
+					return err
+				}
+			}
+			err := db.deleteTx(tx, key, e.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return settled, missing, err
+}
+
+func scanHistory(rows *sql.Rows) ([]historyRecord, error) {
+	defer rows.Close()
+	var rv []historyRecord
+	for rows.Next() {
+		var data string
+		err := rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		var r historyRecord
+		err = json.Unmarshal([]byte(data), &r)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, r)
+	}
+	return rv, rows.Err()
+}
+
+func (db *sqlStore) History(ctx context.Context, key string, n int) ([]historyRecord, error) {
+	var rv []historyRecord
+	err := db.view(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT data FROM history WHERE backlog = ? ORDER BY seq DESC LIMIT ?", key, n)
+		if err != nil {
+			return err
+		}
+		rv, err = scanHistory(rows)
+		return err
+	})
+	return rv, err
+}
+
+// TrimHistory finds the first record to keep in write order and deletes
+// every record before it.
+func (db *sqlStore) TrimHistory(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := db.update(ctx, func(tx sqlTx) error {
+		n = 0
+		rows, err := tx.query("SELECT seq, data FROM history ORDER BY seq")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var keep int64 = -1
+		for rows.Next() {
+			var seq int64
+			var data string
+			err := rows.Scan(&seq, &data)
+			if err != nil {
+				return err
+			}
+			var r historyRecord
+			err = json.Unmarshal([]byte(data), &r)
+			if err != nil {
+				return err
+			}
+			if !r.Time.Before(before) {
+				keep = seq
+				break
+			}
+			n++
+		}
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+		rows.Close()
+		if n == 0 {
+			return nil
+		}
+		if keep < 0 {
+			return tx.exec("DELETE FROM history")
+		}
+		return tx.exec("DELETE FROM history WHERE seq < ?", keep)
+	})
+	return n, err
+}
+
+func (db *sqlStore) Activity(ctx context.Context, key string) ([]historyRecord, []entry, error) {
+	var records []historyRecord
+	var paid []entry
+	err := db.view(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT data FROM history WHERE backlog = ? ORDER BY seq", key)
+		if err != nil {
+			return err
+		}
+		records, err = scanHistory(rows)
+		if err != nil {
+			return err
+		}
+		paid, err = db.listTx(tx, archiveKey(key))
+		return err
+	})
+	return records, paid, err
+}
+
+func (db *sqlStore) Undo(ctx context.Context, key string, by reporter, window time.Duration) (lastChange, error) {
+	var last lastChange
+	err := db.update(ctx, func(tx sqlTx) error {
+		var data string
+		err := tx.queryRow("SELECT data FROM undo WHERE backlog = ? AND user_id = ?", key, by.ID).Scan(&data)
+		if err == sql.ErrNoRows {
+			return errNothingToUndo
+		}
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(data), &last)
+		if err != nil {
+			return err
+		}
+		last.Entry.ID = last.EntryID
+		now := time.Now()
+		if now.Sub(last.Time) > window {
+			return errNothingToUndo
+		}
+		action := "undid " + undoVerb(last.Action)
+		put := func(e entry) error {
+			err := db.record(tx, key, action, e, now, by)
+			if err != nil {
+				return err
+			}
+			if e.Count == 0 {
+				return db.deleteTx(tx, key, e.ID)
+			}
+			e.logBy(action, now, by)
+			return db.putTx(tx, key, e)
+		}
+		if last.Action == "added" {
+			for _, id := range last.IDs {
+				e, err := db.getTx(tx, key, id)
+				if err == errNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				e.Count = e.count() - 1
+				err = put(e)
+				if err != nil {
+					return err
+				}
+				last.Undone = append(last.Undone, e.Name)
+			}
+			if len(last.Undone) == 0 {
+				return errNothingToUndo
+			}
+			return tx.exec("DELETE FROM undo WHERE backlog = ? AND user_id = ?", key, by.ID)
+		}
+		e, err := db.getTx(tx, key, last.EntryID)
+		switch {
+		case err == errNotFound:
+			var found bool
+			e, found, err = db.findTx(tx, key, last.Entry.Name)
+			if err != nil {
+				return err
+			}
+			if found {
+				e.Count = e.count() + 1
+			} else {
+				e = last.Entry
+				e.Count = 1
+			}
+		case err != nil:
+			return err
+		default:
+			e.Count = e.count() + 1
+		}
+		last.EntryID = e.ID
+		err = tx.exec("DELETE FROM undo WHERE backlog = ? AND user_id = ?", key, by.ID)
+		if err != nil {
+			return err
+		}
+		return put(e)
+	})
+	return last, err
+}
+
+func (db *sqlStore) Clear(ctx context.Context, key string, by reporter) ([]entry, error) {
+	var cleared []entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		var err error
+		cleared, err = db.listTx(tx, key)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, e := range cleared {
+			err = db.record(tx, key, "cleared", e, now, by)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.exec("DELETE FROM entries WHERE backlog = ?", key)
+	})
+	return cleared, err
+}
+
+func (db *sqlStore) Migrate(ctx context.Context, from, to string, replace, move bool, by reporter) (int, error) {
+	var entries []entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		var err error
+		entries, err = db.listTx(tx, from)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		now := time.Now()
+		if replace {
+			old, err := db.listTx(tx, to)
+			if err != nil {
+				return err
+			}
+			for _, e := range old {
+				err = db.record(tx, to, "cleared", e, now, by)
+				if err != nil {
+					return err
+				}
+			}
+			err = tx.exec("DELETE FROM entries WHERE backlog = ?", to)
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			if move {
+				err = db.deleteTx(tx, from, e.ID)
+				if err == nil {
+					err = db.record(tx, from, "migrated out", e, now, by)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			e.logBy("migrated", now, by)
+			existing, found, err := db.findTx(tx, to, e.Name)
+			if err != nil {
+				return err
+			}
+			if found {
+				existing.merge(e)
+				e = existing
+				err = db.putTx(tx, to, e)
+			} else {
+				e.ID, err = db.insertTx(tx, to, e)
+			}
+			if err == nil {
+				err = db.record(tx, to, "migrated in", e, now, by)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(entries), err
+}
+
+func (db *sqlStore) Schedule(ctx context.Context, key, name string, at time.Time, by reporter) (uint64, error) {
+	var id uint64
+	err := db.update(ctx, func(tx sqlTx) error {
+		var err error
+		id, err = db.nextID(tx, "scheduled")
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(scheduledAdd{Backlog: key, Name: name, At: at, ScheduledAt: time.Now(), By: by})
+		if err != nil {
+			return err
+		}
+		return tx.exec("INSERT INTO scheduled (id, backlog, data) VALUES (?, ?, ?)", id, key, string(b))
+	})
+	return id, err
+}
+
+func scanScheduled(rows *sql.Rows) ([]scheduledAdd, error) {
+	defer rows.Close()
+	var rv []scheduledAdd
+	for rows.Next() {
+		var id uint64
+		var data string
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+		var a scheduledAdd
+		err = json.Unmarshal([]byte(data), &a)
+		if err != nil {
+			return nil, err
+		}
+		a.ID = id
+		rv = append(rv, a)
+	}
+	return rv, rows.Err()
+}
+
+func (db *sqlStore) Scheduled(ctx context.Context, key string) ([]scheduledAdd, error) {
+	var rv []scheduledAdd
+	err := db.view(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT id, data FROM scheduled WHERE backlog = ? ORDER BY id", key)
+		if err != nil {
+			return err
+		}
+		rv, err = scanScheduled(rows)
+		return err
+	})
+	return rv, err
+}
+
+func (db *sqlStore) Promote(ctx context.Context, now time.Time) ([]entry, error) {
+	var added []entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT id, data FROM scheduled ORDER BY id")
+		if err != nil {
+			return err
+		}
+		pending, err := scanScheduled(rows)
+		if err != nil {
+			return err
+		}
+		for _, a := range pending {
+			if a.At.After(now) {
+				continue
+			}
+			e, err := db.addEntry(tx, a.Backlog, a.Name, func(e *entry) {
+				e.logBy("scheduled", a.ScheduledAt, a.By)
+				e.logBy("added", now, a.By)
+			})
+			if err != nil {
+				return err
+			}
+			err = db.record(tx, a.Backlog, "added", e, now, a.By)
+			if err != nil {
+				return err
+			}
+			err = tx.exec("DELETE FROM scheduled WHERE id = ?", a.ID)
+			if err != nil {
+				return err
+			}
+			added = append(added, e)
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (db *sqlStore) ClearSpotlights(ctx context.Context, now time.Time) ([]entry, error) {
+	var cleared []entry
+	err := db.update(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT backlog, id, data FROM entries WHERE backlog NOT LIKE 'archive:%' ORDER BY backlog, id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var keys []string
+		var expired []entry
+		for rows.Next() {
+			var key, data string
+			var id uint64
+			err := rows.Scan(&key, &id, &data)
+			if err != nil {
+				return err
+			}
+			e, err := decodeEntry(itob(id), []byte(data))
+			if err != nil {
+				return err
+			}
+			if e.SpotlightUntil.IsZero() || e.SpotlightUntil.After(now) {
+				continue
+			}
+			e.SpotlightUntil = time.Time{}
+			e.log("spotlight expired", now)
+			keys = append(keys, key)
+			expired = append(expired, e)
+		}
+		err = rows.Err()
+		if err != nil {
+			return err
+		}
+		rows.Close()
+		for i, e := range expired {
+			err := db.putTx(tx, keys[i], e)
+			if err != nil {
+				return err
+			}
+		}
+		cleared = expired
+		return nil
+	})
+	return cleared, err
+}
+
+func (db *sqlStore) QuietUntil(ctx context.Context, team, channel string) (time.Time, error) {
+	var until time.Time
+	err := db.view(ctx, func(tx sqlTx) error {
+		var v int64
+		err := tx.queryRow("SELECT until_unix FROM quiet_channels WHERE team = ? AND channel = ?", team, channel).Scan(&v)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		until = time.Unix(v, 0)
+		return err
+	})
+	return until, err
+}
+
+func (db *sqlStore) SetQuiet(ctx context.Context, team, channel string, until time.Time) error {
+	return db.update(ctx, func(tx sqlTx) error {
+		err := tx.exec("DELETE FROM quiet_channels WHERE team = ? AND channel = ?", team, channel)
+		if err != nil || until.IsZero() {
+			return err
+		}
+		return tx.exec("INSERT INTO quiet_channels (team, channel, until_unix) VALUES (?, ?, ?)", team, channel, until.Unix())
+	})
+}
+
+func (db *sqlStore) Team(ctx context.Context, id string) (teamInstall, error) {
+	var t teamInstall
+	err := db.view(ctx, func(tx sqlTx) error {
+		var data string
+		err := tx.queryRow("SELECT data FROM teams WHERE id = ?", id).Scan(&data)
+		if err == sql.ErrNoRows {
+			return errNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(data), &t)
+	})
+	return t, err
+}
+
+func (db *sqlStore) SaveTeam(ctx context.Context, id string, t teamInstall) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx sqlTx) error {
+		err := tx.exec("DELETE FROM teams WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		return tx.exec("INSERT INTO teams (id, data) VALUES (?, ?)", id, string(b))
+	})
+}
+
+func (db *sqlStore) TeamConfig(ctx context.Context, team string) (teamConfig, error) {
+	var c teamConfig
+	err := db.view(ctx, func(tx sqlTx) error {
+		var data string
+		err := tx.queryRow("SELECT data FROM team_config WHERE id = ?", team).Scan(&data)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(data), &c)
+	})
+	return c, err
+}
+
+func (db *sqlStore) SaveTeamConfig(ctx context.Context, team string, c teamConfig) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx sqlTx) error {
+		err := tx.exec("DELETE FROM team_config WHERE id = ?", team)
+		if err != nil {
+			return err
+		}
+		return tx.exec("INSERT INTO team_config (id, data) VALUES (?, ?)", team, string(b))
+	})
+}
+
+func (db *sqlStore) Digests(ctx context.Context) ([]digest, error) {
+	var rv []digest
+	err := db.view(ctx, func(tx sqlTx) error {
+		rows, err := tx.query("SELECT data FROM digests ORDER BY id")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var data string
+			err = rows.Scan(&data)
+			if err != nil {
+				return err
+			}
+			var d digest
+			err = json.Unmarshal([]byte(data), &d)
+			if err != nil {
+				return err
+			}
+			rv = append(rv, d)
+		}
+		return rows.Err()
+	})
+	return rv, err
+}
+
+func (db *sqlStore) SaveDigest(ctx context.Context, d digest) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return db.update(ctx, func(tx sqlTx) error {
+		return tx.exec("INSERT INTO digests (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data", digestID(d.Team, d.Channel), string(b))
+	})
+}
+
+func (db *sqlStore) DeleteDigest(ctx context.Context, team, channel string) error {
+	return db.update(ctx, func(tx sqlTx) error {
+		return tx.exec("DELETE FROM digests WHERE id = ?", digestID(team, channel))
+	})
+}
+
+func (db *sqlStore) NotifyOptOut(ctx context.Context, team, user string) (bool, error) {
+	var n int
+	err := db.view(ctx, func(tx sqlTx) error {
+		return tx.queryRow("SELECT COUNT(*) FROM notify_optout WHERE team = ? AND user_id = ?", team, user).Scan(&n)
+	})
+	return n > 0, err
+}
+
+func (db *sqlStore) SetNotifyOptOut(ctx context.Context, team, user string, out bool) error {
+	return db.update(ctx, func(tx sqlTx) error {
+		if !out {
+			return tx.exec("DELETE FROM notify_optout WHERE team = ? AND user_id = ?", team, user)
+		}
+		return tx.exec("INSERT INTO notify_optout (team, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING", team, user)
+	})
+}
+
+// each runs fn on every row the query returns.
+func (tx sqlTx) each(query string, fn func(rows *sql.Rows) error) error {
+	rows, err := tx.query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = fn(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db *sqlStore) Export(ctx context.Context) (snapshot, error) {
+	data := newSnapshot()
+	backlogs := snapshotBacklogs{}
+	teams := snapshotTeams{}
+	err := db.view(ctx, func(tx sqlTx) error {
+		err := tx.each("SELECT backlog, id, data FROM entries ORDER BY backlog, id", func(rows *sql.Rows) error {
+			var key, v string
+			var id uint64
+			err := rows.Scan(&key, &id, &v)
+			if err != nil {
+				return err
+			}
+			e, err := decodeEntry(itob(id), []byte(v))
+			if err != nil {
+				return err
+			}
+			if key, ok := strings.CutPrefix(key, "archive:"); ok {
+				b := backlogs.get(key)
+				b.Archive = append(b.Archive, entrySnapshot{id, e})
+				return nil
+			}
+			b := backlogs.get(key)
+			b.Entries = append(b.Entries, entrySnapshot{id, e})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.each("SELECT name, n FROM sequences", func(rows *sql.Rows) error {
+			var name string
+			var n uint64
+			err := rows.Scan(&name, &n)
+			switch key, archived := strings.CutPrefix(name, "archive:"); {
+			case err != nil || name == "scheduled":
+			case archived:
+				backlogs.get(key).ArchiveSequence = n
+			default:
+				backlogs.get(name).Sequence = n
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.each("SELECT backlog, data FROM history ORDER BY seq", func(rows *sql.Rows) error {
+			var key, v string
+			err := rows.Scan(&key, &v)
+			if err != nil {
+				return err
+			}
+			var r historyRecord
+			err = json.Unmarshal([]byte(v), &r)
+			b := backlogs.get(key)
+			b.History = append(b.History, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		rows, err := tx.query("SELECT id, data FROM scheduled ORDER BY id")
+		if err != nil {
+			return err
+		}
+		scheduled, err := scanScheduled(rows)
+		if err != nil {
+			return err
+		}
+		for _, a := range scheduled {
+			data.Scheduled = append(data.Scheduled, scheduledSnapshot{a.ID, a})
+		}
+		err = tx.each("SELECT id, data FROM teams", func(rows *sql.Rows) error {
+			var id, v string
+			err := rows.Scan(&id, &v)
+			if err != nil {
+				return err
+			}
+			var t teamInstall
+			err = json.Unmarshal([]byte(v), &t)
+			teams.get(id).Install = &t
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.each("SELECT id, data FROM team_config", func(rows *sql.Rows) error {
+			var id, v string
+			err := rows.Scan(&id, &v)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal([]byte(v), &teams.get(id).Config)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.each("SELECT data FROM digests ORDER BY id", func(rows *sql.Rows) error {
+			var v string
+			err := rows.Scan(&v)
+			if err != nil {
+				return err
+			}
+			var d digest
+			err = json.Unmarshal([]byte(v), &d)
+			data.Digests = append(data.Digests, d)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.each("SELECT team, channel, until_unix FROM quiet_channels ORDER BY team, channel", func(rows *sql.Rows) error {
+			var q quietSnapshot
+			var until int64
+			err := rows.Scan(&q.Team, &q.Channel, &until)
+			q.Until = time.Unix(until, 0)
+			data.Quiet = append(data.Quiet, q)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return tx.each("SELECT team, user_id FROM notify_optout ORDER BY team, user_id", func(rows *sql.Rows) error {
+			var n notifyOffSnapshot
+			err := rows.Scan(&n.Team, &n.User)
+			data.NotifyOff = append(data.NotifyOff, n)
+			return err
+		})
+	})
+	data.Backlogs = backlogs.sorted()
+	data.Teams = teams.sorted()
+	return data, err
+}
+
+// Import replaces every table but the version row with the snapshot.
+func (db *sqlStore) Import(ctx context.Context, data snapshot) error {
+	return db.update(ctx, func(tx sqlTx) error {
+		for _, table := range []string{"sequences", "entries", "history", "undo", "scheduled", "quiet_channels", "teams", "team_config", "digests", "notify_optout"} {
+			err := tx.exec("DELETE FROM " + table)
+			if err != nil {
+				return err
+			}
+		}
+		for _, b := range data.Backlogs {
+			err := db.loadEntries(tx, b.Key, b.Sequence, b.Entries)
+			if err == nil && (b.ArchiveSequence > 0 || len(b.Archive) > 0) {
+				err = db.loadEntries(tx, archiveKey(b.Key), b.ArchiveSequence, b.Archive)
+			}
+			if err != nil {
+				return err
+			}
+			for _, r := range b.History {
+				err = db.record(tx, b.Key, r.Action, entry{ID: r.EntryID, Name: r.Name}, r.Time, r.By)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		var seq uint64
+		for _, a := range data.Scheduled {
+			v, err := json.Marshal(a.Add)
+			if err != nil {
+				return err
+			}
+			err = tx.exec("INSERT INTO scheduled (id, backlog, data) VALUES (?, ?, ?)", a.ID, a.Add.Backlog, string(v))
+			if err != nil {
+				return err
+			}
+			seq = max(seq, a.ID)
+		}
+		if seq > 0 {
+			err := db.setSequence(tx, "scheduled", seq)
+			if err != nil {
+				return err
+			}
+		}
+		for _, t := range data.Teams {
+			if t.Install != nil {
+				v, err := json.Marshal(t.Install)
+				if err == nil {
+					err = tx.exec("INSERT INTO teams (id, data) VALUES (?, ?)", t.ID, string(v))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			v, err := json.Marshal(t.Config)
+			if err == nil {
+				err = tx.exec("INSERT INTO team_config (id, data) VALUES (?, ?)", t.ID, string(v))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, d := range data.Digests {
+			v, err := json.Marshal(d)
+			if err == nil {
+				err = tx.exec("INSERT INTO digests (id, data) VALUES (?, ?)", digestID(d.Team, d.Channel), string(v))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, q := range data.Quiet {
+			err := tx.exec("INSERT INTO quiet_channels (team, channel, until_unix) VALUES (?, ?, ?)", q.Team, q.Channel, q.Until.Unix())
+			if err != nil {
+				return err
+			}
+		}
+		for _, n := range data.NotifyOff {
+			err := tx.exec("INSERT INTO notify_optout (team, user_id) VALUES (?, ?)", n.Team, n.User)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *sqlStore) loadEntries(tx sqlTx, key string, seq uint64, entries []entrySnapshot) error {
+	for _, e := range snapshotEntries(entries) {
+		err := db.putTx(tx, key, e)
+		if err != nil {
+			return err
+		}
+	}
+	return db.setSequence(tx, key, seq)
+}
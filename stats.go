@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	statsTop    = 5
+	statsMonths = 6
+)
+
+// activity returns the backlog's whole history and its settled debts.
+func (db backlog) activity() ([]historyRecord, []entry, error) {
+	var records []historyRecord
+	var paid []entry
+	err := db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(db.historyName()); bucket != nil {
+			err := bucket.ForEach(func(k, v []byte) error {
+				var r historyRecord
+				err := json.Unmarshal(v, &r)
+				records = append(records, r)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		bucket := tx.Bucket(db.archiveName())
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(k, v)
+			paid = append(paid, e)
+			return err
+		})
+	})
+	return records, paid, err
+}
+
+// timeToPay returns how long the debt settled by the archived entry was
+// owed. Debts are paid oldest first, so the nth payment settles the nth
+// add still in the entry's history.
+func timeToPay(e entry) (time.Duration, bool) {
+	var adds []time.Time
+	var paid time.Time
+	n := 0
+	for _, ev := range e.Events {
+		switch ev.Action {
+		case "added":
+			adds = append(adds, ev.Time)
+		case "paid":
+			paid = ev.Time
+			n++
+		}
+	}
+	if n == 0 || n > len(adds) {
+		return 0, false
+	}
+	return paid.Sub(adds[n-1]), true
+}
+
+func (s *server) stats(cmd *command) (msg, error) {
+	records, paid, err := s.store.Activity(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID))
+	if err != nil {
+		return msg{}, err
+	}
+	if len(records) == 0 {
+		return newPrivateMessage("There are no stats yet, nothing has happened on this backlog."), nil
+	}
+	counts := make(map[string]int)
+	months := make(map[string]int)
+	for _, r := range records {
+		switch r.Action {
+		case "added":
+			counts[r.Name]++
+			months[r.Time.Format("2006-01")]++
+		case "undid add":
+			counts[r.Name]--
+			months[r.Time.Format("2006-01")]--
+		}
+	}
+	var top []tallyRow
+	for name, n := range counts {
+		if n > 0 {
+			top = append(top, tallyRow{name, n})
+		}
+	}
+	sortTally(top)
+	lines := []string{"*All-time top offenders:*"}
+	for i, t := range top[:min(len(top), statsTop)] {
+		lines = append(lines, fmt.Sprintf("%d. %s — %d", i+1, t.name, t.count))
+	}
+	lines = append(lines, "*Adds per month:*")
+	now := time.Now()
+	for i := statsMonths - 1; i >= 0; i-- {
+		month := now.AddDate(0, -i, 1-now.Day()).Format("2006-01")
+		lines = append(lines, fmt.Sprintf("%s: %d", month, months[month]))
+	}
+	var total time.Duration
+	var n int
+	for _, e := range paid {
+		if d, ok := timeToPay(e); ok {
+			total += d
+			n++
+		}
+	}
+	if n > 0 {
+		lines = append(lines, fmt.Sprintf("*Average time to payment:* %s (%s)", humanize(total/time.Duration(n)), plural(n, "payment", "payments")))
+	} else {
+		lines = append(lines, "*Average time to payment:* nobody has paid yet")
+	}
+	return newPublicMessage(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the storage behind the commands. Backlogs are addressed by key,
+// empty for the shared backlog, see server.backlogKey. Every method is safe
+// for concurrent use, and fails with the context's error without touching
+// the store once ctx is done.
+type Store interface {
+	// Add counts one more for each name and records the details on the
+	// entries in the same transaction.
+	Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error)
+	Del(ctx context.Context, key string, id uint64, by reporter) (entry, error)
+	Get(ctx context.Context, key string, id uint64) (entry, error)
+	Modify(ctx context.Context, key string, id uint64, fn func(e *entry) error) (entry, error)
+	Pin(ctx context.Context, key string, id uint64, pinned bool) (entry, error)
+	Spotlight(ctx context.Context, key string, id uint64, until time.Time) (entry, error)
+	LogEvent(ctx context.Context, key string, id uint64, action string) error
+	List(ctx context.Context, key string) ([]entry, error)
+	Pay(ctx context.Context, key string, id uint64, name string, by reporter) (entry, error)
+	// Settle archives every debt of the entries with the given ids, or of
+	// all entries when ids is nil, under the action and with the note. It
+	// returns the entries as they stood and the ids it didn't find.
+	Settle(ctx context.Context, key string, ids []uint64, action, note string, by reporter) ([]entry, []uint64, error)
+	History(ctx context.Context, key string, n int) ([]historyRecord, error)
+	Activity(ctx context.Context, key string) ([]historyRecord, []entry, error)
+	// TrimHistory deletes the history of every backlog recorded before the
+	// time and returns how many records went.
+	TrimHistory(ctx context.Context, before time.Time) (int, error)
+	Undo(ctx context.Context, key string, by reporter, window time.Duration) (lastChange, error)
+	// Clear removes every entry from the backlog and returns them.
+	Clear(ctx context.Context, key string, by reporter) ([]entry, error)
+	// Migrate copies the entries of one backlog into another, merging
+	// those for names the target already owes. Replace empties the
+	// target first and move empties the source.
+	Migrate(ctx context.Context, from, to string, replace, move bool, by reporter) (int, error)
+
+	Schedule(ctx context.Context, key, name string, at time.Time, by reporter) (uint64, error)
+	Scheduled(ctx context.Context, key string) ([]scheduledAdd, error)
+	Promote(ctx context.Context, now time.Time) ([]entry, error)
+	ClearSpotlights(ctx context.Context, now time.Time) ([]entry, error)
+
+	QuietUntil(ctx context.Context, team, channel string) (time.Time, error)
+	SetQuiet(ctx context.Context, team, channel string, until time.Time) error
+	Team(ctx context.Context, id string) (teamInstall, error)
+	SaveTeam(ctx context.Context, id string, t teamInstall) error
+	// TeamConfig returns the zero config for teams that haven't set any.
+	TeamConfig(ctx context.Context, team string) (teamConfig, error)
+	SaveTeamConfig(ctx context.Context, team string, c teamConfig) error
+	Digests(ctx context.Context) ([]digest, error)
+	SaveDigest(ctx context.Context, d digest) error
+	DeleteDigest(ctx context.Context, team, channel string) error
+	NotifyOptOut(ctx context.Context, team, user string) (bool, error)
+	SetNotifyOptOut(ctx context.Context, team, user string, out bool) error
+
+	// Export returns everything the store holds, and Import replaces it
+	// all with a snapshot in a single transaction.
+	Export(ctx context.Context) (snapshot, error)
+	Import(ctx context.Context, data snapshot) error
+
+	// Version changes whenever anything is written.
+	Version(ctx context.Context) (int, error)
+	Close() error
+}
+
+var _ Store = (*store)(nil)
+
+func (db *store) Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error) {
+	return db.with(ctx).backlog(key).add(by, d, names...)
+}
+
+func (db *store) Del(ctx context.Context, key string, id uint64, by reporter) (entry, error) {
+	return db.with(ctx).backlog(key).del(id, by)
+}
+
+func (db *store) Clear(ctx context.Context, key string, by reporter) ([]entry, error) {
+	return db.with(ctx).backlog(key).clear(by)
+}
+
+func (db *store) Get(ctx context.Context, key string, id uint64) (entry, error) {
+	return db.with(ctx).backlog(key).get(id)
+}
+
+func (db *store) Modify(ctx context.Context, key string, id uint64, fn func(e *entry) error) (entry, error) {
+	return db.with(ctx).backlog(key).update(id, fn)
+}
+
+func (db *store) Pin(ctx context.Context, key string, id uint64, pinned bool) (entry, error) {
+	return db.with(ctx).backlog(key).pin(id, pinned)
+}
+
+func (db *store) Spotlight(ctx context.Context, key string, id uint64, until time.Time) (entry, error) {
+	return db.with(ctx).backlog(key).spotlight(id, until)
+}
+
+func (db *store) LogEvent(ctx context.Context, key string, id uint64, action string) error {
+	return db.with(ctx).backlog(key).logEvent(id, action)
+}
+
+func (db *store) List(ctx context.Context, key string) ([]entry, error) {
+	return db.with(ctx).backlog(key).list()
+}
+
+func (db *store) Pay(ctx context.Context, key string, id uint64, name string, by reporter) (entry, error) {
+	return db.with(ctx).backlog(key).pay(id, name, by)
+}
+
+func (db *store) Settle(ctx context.Context, key string, ids []uint64, action, note string, by reporter) ([]entry, []uint64, error) {
+	return db.with(ctx).backlog(key).settle(ids, action, note, by)
+}
+
+func (db *store) History(ctx context.Context, key string, n int) ([]historyRecord, error) {
+	return db.with(ctx).backlog(key).history(n)
+}
+
+func (db *store) Activity(ctx context.Context, key string) ([]historyRecord, []entry, error) {
+	return db.with(ctx).backlog(key).activity()
+}
+
+func (db *store) Undo(ctx context.Context, key string, by reporter, window time.Duration) (lastChange, error) {
+	return db.with(ctx).backlog(key).undo(by, window)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FreeRatio reports the share of the database file taken up by free
+// pages along with the file size.
+func (db *DB) FreeRatio() (float64, int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	fi, err := os.Stat(db.path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("storage: free ratio: %w", err)
+	}
+	if fi.Size() == 0 {
+		return 0, 0, nil
+	}
+	stats := db.db.Stats()
+	return float64(stats.FreeAlloc) / float64(fi.Size()), fi.Size(), nil
+}
+
+// Compact rewrites the database into a fresh file and swaps it in,
+// returning the new size. All transactions are blocked while it runs so
+// no writes are lost.
+func (db *DB) Compact() (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return 0, ErrClosed
+	}
+	tmp := db.path + ".compact"
+	os.Remove(tmp)
+	dst, err := bolt.Open(tmp, 0660, db.opts)
+	if err != nil {
+		return 0, fmt.Errorf("storage: compact: %w", err)
+	}
+	err = db.db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(nb, b)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	defer os.Remove(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("storage: compact: %w", err)
+	}
+	err = db.db.Close()
+	if err != nil {
+		return 0, db.reopen(err)
+	}
+	err = db.swap(tmp)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(db.path)
+	if err != nil {
+		return 0, fmt.Errorf("storage: compact: %w", err)
+	}
+	return fi.Size(), nil
+}
+
+// rename is os.Rename, swapped out by tests.
+var rename = os.Rename
+
+// swap moves the compacted file at tmp into place and opens it. The
+// original file is kept aside until then, and should either step fail it
+// is put back and reopened so the database keeps serving from it.
+func (db *DB) swap(tmp string) error {
+	old := db.path + ".precompact"
+	err := rename(db.path, old)
+	if err != nil {
+		return db.reopen(err)
+	}
+	err = rename(tmp, db.path)
+	if err == nil {
+		var reopened *bolt.DB
+		reopened, err = bolt.Open(db.path, 0660, db.opts)
+		if err == nil {
+			db.db = reopened
+			os.Remove(old)
+			return nil
+		}
+	}
+	rerr := rename(old, db.path)
+	if rerr != nil {
+		return fmt.Errorf("storage: compact: %w, and restoring %s failed: %w", err, old, rerr)
+	}
+	return db.reopen(err)
+}
+
+// reopen opens the database again after a failed swap and returns the
+// failure. If even that fails the closed handle stays in place, so
+// transactions report ErrClosed instead of using a nil database.
+func (db *DB) reopen(cause error) error {
+	reopened, err := bolt.Open(db.path, 0660, db.opts)
+	if err != nil {
+		return fmt.Errorf("storage: compact: %w, and reopening %s failed: %w", cause, db.path, err)
+	}
+	db.db = reopened
+	return fmt.Errorf("storage: compact: %w", cause)
+}
+
+func copyBucket(dst, src *bolt.Bucket) error {
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nb, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(nb, src.Bucket(k))
+	})
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("b")
+
+func put(t *testing.T, db *DB, keys ...string) {
+	t.Helper()
+	err := db.Update(context.Background(), func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = b.Put([]byte(k), []byte(k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func count(t *testing.T, db *DB) int {
+	t.Helper()
+	n := 0
+	err := db.View(context.Background(), func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCompact(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	put(t, db, "alice", "bob")
+	_, err = db.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, db, "carol")
+	if n := count(t, db); n != 3 {
+		t.Errorf("keys after compaction = %d, want 3", n)
+	}
+}
+
+func TestCompactRenameFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(from, to string) bool
+	}{
+		{"set aside", func(from, to string) bool { return strings.HasSuffix(to, ".precompact") }},
+		{"swap in", func(from, to string) bool { return strings.HasSuffix(from, ".compact") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(filepath.Join(dir, "test.db"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			put(t, db, "alice", "bob")
+			errRename := errors.New("rename refused")
+			rename = func(from, to string) error {
+				if tt.fail(from, to) {
+					return errRename
+				}
+				return os.Rename(from, to)
+			}
+			defer func() { rename = os.Rename }()
+			_, err = db.Compact()
+			if !errors.Is(err, errRename) {
+				t.Fatalf("Compact() error = %v, want %v", err, errRename)
+			}
+			if n := count(t, db); n != 2 {
+				t.Fatalf("keys after failed compaction = %d, want 2", n)
+			}
+			put(t, db, "carol")
+			names, _ := filepath.Glob(filepath.Join(dir, "*"))
+			if len(names) != 1 {
+				t.Errorf("files left behind: %v", names)
+			}
+		})
+	}
+}
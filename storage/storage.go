@@ -0,0 +1,102 @@
+// Package storage wraps the bbolt database behind the bolt store. A
+// transaction won't start once its context is done or the database is
+// closed, and failures from bolt itself say what failed and where, while
+// errors from the caller's own function come back unchanged.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+// ErrClosed is returned for transactions on a closed database.
+var ErrClosed = errors.New("storage: database closed")
+
+// DB is a bolt database that can be compacted while it is in use.
+type DB struct {
+	path   string
+	opts   *bolt.Options
+	closed atomic.Bool
+
+	// mu guards db, which is swapped out by compaction.
+	mu sync.RWMutex
+	db *bolt.DB
+}
+
+// Open opens the bolt database at path, creating it if necessary.
+func Open(path string, opts *bolt.Options) (*DB, error) {
+	db, err := bolt.Open(path, 0660, opts)
+	if err != nil {
+		return nil, fmt.Errorf("storage: open %s: %w", path, err)
+	}
+	return &DB{path: path, opts: opts, db: db}, nil
+}
+
+func (db *DB) Path() string {
+	return db.path
+}
+
+// View runs fn in a read-only transaction.
+func (db *DB) View(ctx context.Context, fn func(*bolt.Tx) error) error {
+	return db.run(ctx, "view", false, fn)
+}
+
+// Update runs fn in a read-write transaction, committed if fn returns nil.
+func (db *DB) Update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	return db.run(ctx, "update", true, fn)
+}
+
+func (db *DB) run(ctx context.Context, op string, write bool, fn func(*bolt.Tx) error) error {
+	if db.closed.Load() {
+		return ErrClosed
+	}
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("storage: %s: %w", op, err)
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var fnErr error
+	tx := func(tx *bolt.Tx) error {
+		fnErr = fn(tx)
+		return fnErr
+	}
+	if write {
+		err = db.db.Update(tx)
+	} else {
+		err = db.db.View(tx)
+	}
+	return db.wrap(op, err, fnErr)
+}
+
+func (db *DB) wrap(op string, err, fnErr error) error {
+	switch {
+	case err == nil || err == fnErr:
+		return err
+	case errors.Is(err, berrors.ErrDatabaseNotOpen):
+		return ErrClosed
+	}
+	return fmt.Errorf("storage: %s %s: %w", op, db.path, err)
+}
+
+// Stats returns bolt's statistics for the open database.
+func (db *DB) Stats() bolt.Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.db.Stats()
+}
+
+// Close waits for open transactions to finish and closes the database.
+// Later transactions fail with ErrClosed.
+func (db *DB) Close() error {
+	db.closed.Store(true)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.wrap("close", db.db.Close(), nil)
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+func TestErrors(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errOwn := errors.New("own error")
+	err = db.Update(context.Background(), func(tx *bolt.Tx) error { return errOwn })
+	if err != errOwn {
+		t.Errorf("Update returned %v, want the function's own error unchanged", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran := false
+	err = db.View(ctx, func(tx *bolt.Tx) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || ran {
+		t.Errorf("View with a done context = %v, ran %v", err, ran)
+	}
+
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(context.Background(), func(tx *bolt.Tx) error { return nil })
+	if err != ErrClosed {
+		t.Errorf("View after Close = %v, want ErrClosed", err)
+	}
+	_, err = db.Compact()
+	if err != ErrClosed {
+		t.Errorf("Compact after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = Open(path, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(context.Background(), func(tx *bolt.Tx) error { return nil })
+	if !errors.Is(err, berrors.ErrDatabaseReadOnly) || !strings.HasPrefix(err.Error(), "storage: update "+path) {
+		t.Errorf("Update on a read-only database = %v", err)
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing", "test.db"), nil)
+	if err == nil {
+		t.Fatal("Open in a missing directory succeeded")
+	}
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pnelson/icecream/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+const maxEvents = 20
+
+var (
+	errNotFound     = errors.New("entry not found")
+	errShuttingDown = errors.New("service shutting down")
+)
+
+var metaBucket = []byte("meta")
+
+type store struct {
+	*storage.DB
+	bucketName []byte
+	idStart    uint64
+	ctx        context.Context
+}
+
+// openBolt opens the bolt database at path, migrating it to the current
+// schema version.
+func openBolt(path string, idStart uint64) (*store, error) {
+	db, err := storage.Open(path, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	st := &store{
+		DB:         db,
+		bucketName: []byte("icecream"),
+		idStart:    idStart,
+	}
+	err = st.migrate()
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// with returns a copy of db whose transactions run under ctx.
+func (db *store) with(ctx context.Context) *store {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+func (db *store) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// View runs fn in a read-only transaction under the context the store was
+// bound to by with, so a canceled request never starts one. Requests
+// arriving after the store has been closed fail with errShuttingDown.
+func (db *store) View(fn func(*bolt.Tx) error) error {
+	return closedErr(db.DB.View(db.context(), fn))
+}
+
+// Update runs fn in a read-write transaction the same way as View.
+func (db *store) Update(fn func(*bolt.Tx) error) error {
+	return closedErr(db.DB.Update(db.context(), fn))
+}
+
+func closedErr(err error) error {
+	if errors.Is(err, storage.ErrClosed) {
+		return errShuttingDown
+	}
+	return err
+}
+
+// backlog is the bucket of entries for one team or channel, or the shared
+// bucket when backlogs aren't kept apart.
+type backlog struct {
+	*store
+	name []byte
+}
+
+func (db *store) backlog(key string) backlog {
+	if key == "" {
+		return backlog{db, db.bucketName}
+	}
+	return backlog{db, []byte(string(db.bucketName) + ":" + key)}
+}
+
+// isBacklog reports whether the top level bucket holds entries.
+func (db *store) isBacklog(name []byte) bool {
+	base := string(db.bucketName)
+	return string(name) == base || strings.HasPrefix(string(name), base+":")
+}
+
+// keyOf returns the key of the backlog held in the named bucket.
+func (db *store) keyOf(name []byte) string {
+	if string(name) == string(db.bucketName) {
+		return ""
+	}
+	return strings.TrimPrefix(string(name), string(db.bucketName)+":")
+}
+
+type entry struct {
+	ID       uint64    `json:"-"`
+	Name     string    `json:"name"`
+	Pinned   bool      `json:"pinned,omitempty"`
+	PinnedAt time.Time `json:"pinned_at,omitzero"`
+	Excuse   string    `json:"excuse,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Count    int       `json:"count,omitempty"`
+	Events   []event   `json:"events,omitempty"`
+
+	SpotlightUntil time.Time `json:"spotlight_until,omitzero"`
+	Due            time.Time `json:"due,omitzero"`
+	SnoozedUntil   time.Time `json:"snoozed_until,omitzero"`
+}
+
+type event struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+	By     reporter  `json:"by,omitzero"`
+}
+
+// reporter is the Slack user behind an event.
+type reporter struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func (r reporter) String() string {
+	if r.Name != "" {
+		return "@" + r.Name
+	}
+	if r.ID != "" {
+		return "<@" + r.ID + ">"
+	}
+	return "someone"
+}
+
+func (e *entry) log(action string, t time.Time) {
+	e.logBy(action, t, reporter{})
+}
+
+func (e *entry) logBy(action string, t time.Time, by reporter) {
+	e.Events = append(e.Events, event{action, t, by})
+	if n := len(e.Events) - maxEvents; n > 0 {
+		e.Events = append(e.Events[:0], e.Events[n:]...)
+	}
+}
+
+// count is the number of ice creams owed. Entries written before counts
+// were kept stand for one each.
+func (e entry) count() int {
+	return max(e.Count, 1)
+}
+
+// label is the entry's name with its count when more than one is owed.
+func (e entry) label() string {
+	if e.count() > 1 {
+		return fmt.Sprintf("%s ×%d", e.Name, e.count())
+	}
+	return e.Name
+}
+
+// merge folds a duplicate entry for the same name into e.
+func (e *entry) merge(dup entry) {
+	e.Count = e.count() + dup.count()
+	if !e.Pinned && dup.Pinned {
+		e.Pinned, e.PinnedAt = true, dup.PinnedAt
+	}
+	if e.Excuse == "" {
+		e.Excuse = dup.Excuse
+	}
+	if e.Reason == "" {
+		e.Reason = dup.Reason
+	}
+	if dup.SpotlightUntil.After(e.SpotlightUntil) {
+		e.SpotlightUntil = dup.SpotlightUntil
+	}
+	e.Events = append(e.Events, dup.Events...)
+	sort.SliceStable(e.Events, func(i, j int) bool {
+		return e.Events[i].Time.Before(e.Events[j].Time)
+	})
+	if n := len(e.Events) - maxEvents; n > 0 {
+		e.Events = e.Events[n:]
+	}
+}
+
+func (e entry) addedAt() time.Time {
+	return e.firstAdd().Time
+}
+
+// firstAdd returns the earliest add still in the entry's history.
+func (e entry) firstAdd() event {
+	for _, ev := range e.Events {
+		if ev.Action == "added" {
+			return ev
+		}
+	}
+	return event{}
+}
+
+func decodeEntry(k, v []byte) (entry, error) {
+	e := entry{ID: itou(k)}
+	if !bytes.HasPrefix(v, []byte("{")) {
+		// Entries written before values were structured hold only the name.
+		e.Name = string(v)
+		return e, nil
+	}
+	err := json.Unmarshal(v, &e)
+	if err != nil {
+		return e, fmt.Errorf("decoding entry %d: %w", e.ID, err)
+	}
+	return e, nil
+}
+
+func putEntry(bucket *bolt.Bucket, e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(e.ID), b)
+}
+
+// createBucket returns the entries bucket, creating it if necessary.
+// A new bucket starts its sequence at the configured id offset. The
+// bucket keeps its sequence from then on, so later changes to the flag
+// don't affect a backlog that already exists.
+func (db backlog) createBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if bucket := tx.Bucket(db.name); bucket != nil {
+		return bucket, nil
+	}
+	bucket, err := tx.CreateBucket(db.name)
+	if err != nil {
+		return nil, err
+	}
+	return bucket, bucket.SetSequence(db.idStart)
+}
+
+// add counts one more for each name on behalf of by, with the details,
+// and returns the entries as they stand after each add.
+func (db backlog) add(by reporter, d addDetails, names ...string) ([]entry, error) {
+	added := make([]entry, len(names))
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := db.createBucket(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i, name := range names {
+			e, err := addEntry(bucket, name, func(e *entry) {
+				e.logBy("added", now, by)
+				d.apply(e, now, by)
+			})
+			if err != nil {
+				return err
+			}
+			err = db.record(tx, "added", e, now, by)
+			if err != nil {
+				return err
+			}
+			added[i] = e
+		}
+		return db.remember(tx, by, addedChange(added, now))
+	})
+	return added, err
+}
+
+// addEntry counts one more for the entry with the given name, creating it
+// if the name isn't on the backlog yet, and applies fn before writing it.
+func addEntry(bucket *bolt.Bucket, name string, fn func(e *entry)) (entry, error) {
+	e, found, err := findEntry(bucket, name)
+	if err != nil {
+		return entry{}, err
+	}
+	if found {
+		e.Count = e.count() + 1
+		fn(&e)
+		return e, putEntry(bucket, e)
+	}
+	e = entry{Name: name, Count: 1}
+	fn(&e)
+	e.ID, err = insertEntry(bucket, e)
+	return e, err
+}
+
+// findEntry returns the entry with the given name, ignoring case.
+func findEntry(bucket *bolt.Bucket, name string) (entry, bool, error) {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		e, err := decodeEntry(k, v)
+		if err != nil {
+			return entry{}, false, err
+		}
+		if strings.EqualFold(e.Name, name) {
+			return e, true, nil
+		}
+	}
+	return entry{}, false, nil
+}
+
+func insertEntry(bucket *bolt.Bucket, e entry) (uint64, error) {
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	e.ID = id
+	return id, putEntry(bucket, e)
+}
+
+func (db backlog) get(id uint64) (entry, error) {
+	var e entry
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return errNotFound
+		}
+		key := itob(id)
+		v := bucket.Get(key)
+		if v == nil {
+			return errNotFound
+		}
+		var err error
+		e, err = decodeEntry(key, v)
+		return err
+	})
+	return e, err
+}
+
+// update applies fn to the entry with the given id and writes it back,
+// returning the updated entry.
+func (db backlog) update(id uint64, fn func(e *entry) error) (entry, error) {
+	var e entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return errNotFound
+		}
+		key := itob(id)
+		v := bucket.Get(key)
+		if v == nil {
+			return errNotFound
+		}
+		var err error
+		e, err = decodeEntry(key, v)
+		if err != nil {
+			return err
+		}
+		err = fn(&e)
+		if err != nil {
+			return err
+		}
+		return putEntry(bucket, e)
+	})
+	return e, err
+}
+
+func (db backlog) logEvent(id uint64, action string) error {
+	_, err := db.update(id, func(e *entry) error {
+		e.log(action, time.Now())
+		return nil
+	})
+	return err
+}
+
+func (db backlog) pin(id uint64, pinned bool) (entry, error) {
+	return db.update(id, func(e *entry) error {
+		e.setPinned(pinned, time.Now())
+		return nil
+	})
+}
+
+func (e *entry) setPinned(pinned bool, now time.Time) {
+	e.Pinned = pinned
+	e.PinnedAt = time.Time{}
+	if pinned {
+		e.PinnedAt = now
+		e.log("pinned", now)
+	} else {
+		e.log("unpinned", now)
+	}
+}
+
+// del takes one off the entry's count, removing the entry when nothing is
+// left, and returns the entry as it now stands.
+func (db backlog) del(id uint64, by reporter) (entry, error) {
+	var e entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return errNotFound
+		}
+		key := itob(id)
+		v := bucket.Get(key)
+		if v == nil {
+			return errNotFound
+		}
+		var err error
+		e, err = decodeEntry(key, v)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = db.record(tx, "deleted", e, now, by)
+		if err != nil {
+			return err
+		}
+		err = db.remember(tx, by, lastChange{Action: "deleted", EntryID: e.ID, Entry: e, Time: now})
+		if err != nil {
+			return err
+		}
+		e.Count = e.count() - 1
+		if e.Count == 0 {
+			return bucket.Delete(key)
+		}
+		e.logBy("deleted one", now, by)
+		return putEntry(bucket, e)
+	})
+	return e, err
+}
+
+// clear removes every entry, keeping the sequence so ids aren't reused.
+func (db backlog) clear(by reporter) ([]entry, error) {
+	var cleared []entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return nil
+		}
+		now := time.Now()
+		err := bucket.ForEach(func(k, v []byte) error {
+			e, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			cleared = append(cleared, e)
+			return db.record(tx, "cleared", e, now, by)
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range cleared {
+			err = bucket.Delete(itob(e.ID))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return cleared, err
+}
+
+func (db backlog) findByName(name string) ([]entry, error) {
+	entries, err := db.list()
+	if err != nil {
+		return nil, err
+	}
+	var rv []entry
+	for _, e := range entries {
+		if strings.EqualFold(e.Name, name) {
+			rv = append(rv, e)
+		}
+	}
+	return rv, nil
+}
+
+func (db backlog) list() ([]entry, error) {
+	var entries []entry
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.name)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			e, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// mergeDuplicates folds entries that share a name into the one with the
+// lowest id, adding up their counts. Backlogs written before counts were
+// kept hold an entry per add. It returns the number of entries removed.
+func (db *store) mergeDuplicates(tx *bolt.Tx) (int, error) {
+	var merged int
+	err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+		if !db.isBacklog(name) {
+			return nil
+		}
+		keep := make(map[string]*entry)
+		changed := make(map[*entry]bool)
+		var dups [][]byte
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			e, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			key := strings.ToLower(e.Name)
+			first, ok := keep[key]
+			if !ok {
+				keep[key] = &e
+				continue
+			}
+			first.merge(e)
+			changed[first] = true
+			dups = append(dups, append([]byte(nil), k...))
+		}
+		for e := range changed {
+			err := putEntry(bucket, *e)
+			if err != nil {
+				return err
+			}
+		}
+		for _, k := range dups {
+			err := bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		merged += len(dups)
+		return nil
+	})
+	return merged, err
+}
+
+func itou(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+func itob(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// quietKey is keyed by team as well as channel since channel ids are only
+// unique within a workspace.
+func quietKey(team, channel string) []byte {
+	return []byte("quiet:" + team + "/" + channel)
+}
+
+func (db *store) QuietUntil(ctx context.Context, team, channel string) (time.Time, error) {
+	db = db.with(ctx)
+	var until time.Time
+	err := db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return nil
+		}
+		if v := meta.Get(quietKey(team, channel)); v != nil {
+			until = time.Unix(int64(itou(v)), 0)
+		}
+		return nil
+	})
+	return until, err
+}
+
+// SetQuiet mutes public replies in the team's channel until the given
+// time, a zero time ends quiet mode.
+func (db *store) SetQuiet(ctx context.Context, team, channel string, until time.Time) error {
+	db = db.with(ctx)
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if until.IsZero() {
+			return meta.Delete(quietKey(team, channel))
+		}
+		return meta.Put(quietKey(team, channel), itob(uint64(until.Unix())))
+	})
+}
+
+type tallyRow struct {
+	name  string
+	count int
+}
+
+// tally counts what is owed per name, ordered from the most owed down and
+// then by name.
+func tally(entries []entry) []tallyRow {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Name] += e.count()
+	}
+	rv := make([]tallyRow, 0, len(counts))
+	for name, n := range counts {
+		rv = append(rv, tallyRow{name, n})
+	}
+	sortTally(rv)
+	return rv
+}
+
+func sortTally(rows []tallyRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].count != rows[j].count {
+			return rows[i].count > rows[j].count
+		}
+		return rows[i].name < rows[j].name
+	})
+}
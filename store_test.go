@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// TestCloseDuringRequests closes the store while adds and lists are
+// running. Every failure must be errShuttingDown rather than a panic or
+// bolt's own error.
+func TestCloseDuringRequests(t *testing.T) {
+	db, err := openBolt(filepath.Join(t.TempDir(), "icecream.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				_, err := db.Add(t.Context(), "", reporter{ID: "U1"}, addDetails{}, fmt.Sprintf("p%d-%d", i, j))
+				if err == nil {
+					_, err = db.List(t.Context(), "")
+				}
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, errShuttingDown) {
+					t.Errorf("got %v, want errShuttingDown", err)
+				}
+				return
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+// TestCloseWaitsForTransaction closes the store while a transaction is
+// open. The transaction finishes, and later ones are refused.
+func TestCloseWaitsForTransaction(t *testing.T) {
+	db, err := openBolt(filepath.Join(t.TempDir(), "icecream.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside, release := make(chan struct{}), make(chan struct{})
+	viewed := make(chan error)
+	go func() {
+		viewed <- db.View(func(tx *bolt.Tx) error {
+			close(inside)
+			<-release
+			return nil
+		})
+	}()
+	<-inside
+	closed := make(chan error)
+	go func() { closed <- db.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-viewed; err != nil {
+		t.Errorf("open transaction failed: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Add(t.Context(), "", reporter{ID: "U1"}, addDetails{}, "alice")
+	if err != errShuttingDown {
+		t.Errorf("Add after Close = %v, want errShuttingDown", err)
+	}
+}
+
+func TestClosedStoreServiceUnavailable(t *testing.T) {
+	ts := newTestServer(t)
+	ts.db.Close()
+	code, _ := ts.post(t, slashForm("U1", "add alice"), nil)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("add status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	code = ts.api(t, http.MethodGet, "/api/v1/entries", testAPIKey, nil, nil)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("api list status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+// stores returns a fresh store of every kind.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := openBolt(filepath.Join(dir, "icecream.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, err := openSQL(sqliteDialect, filepath.Join(dir, "icecream.sqlite"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]Store{"bolt": db, "sqlite": sql, "memory": newMemStore(0)}
+	t.Cleanup(func() {
+		for _, st := range all {
+			st.Close()
+		}
+	})
+	return all
+}
+
+func TestAddDetails(t *testing.T) {
+	due := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	d := addDetails{reason: "broke the build", due: due}
+	for name, st := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			added, err := st.Add(t.Context(), "", reporter{ID: "U1"}, d, "alice", "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := st.Get(t.Context(), "", added[1].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Reason != d.reason || !e.Due.Equal(due) || e.count() != 2 {
+				t.Errorf("entry = %+v, want the reason, due date and a count of 2", e)
+			}
+			var actions []string
+			for _, ev := range e.Events {
+				actions = append(actions, ev.Action)
+			}
+			want := []string{"added", "reason given", "due " + due.Format(timeFormat), "added"}
+			if !slices.Equal(actions, want) {
+				t.Errorf("events = %q, want %q", actions, want)
+			}
+		})
+	}
+}
+
+func TestUndoDeleteMergesByName(t *testing.T) {
+	alice, bob := reporter{ID: "U1"}, reporter{ID: "U2"}
+	for name, st := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			added, err := st.Add(t.Context(), "", alice, addDetails{}, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = st.Del(t.Context(), "", added[0].ID, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readded, err := st.Add(t.Context(), "", bob, addDetails{}, "Alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			last, err := st.Undo(t.Context(), "", alice, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last.EntryID != readded[0].ID {
+				t.Errorf("undo reported id %d, want %d", last.EntryID, readded[0].ID)
+			}
+			entries, err := st.List(t.Context(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].ID != readded[0].ID || entries[0].count() != 2 {
+				t.Errorf("entries after undo = %+v, want one entry ×2", entries)
+			}
+		})
+	}
+}
+
+func TestUndoDeleteRestores(t *testing.T) {
+	by := reporter{ID: "U1"}
+	for name, st := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			added, err := st.Add(t.Context(), "", by, addDetails{}, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = st.Del(t.Context(), "", added[0].ID, by)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = st.Undo(t.Context(), "", by, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := st.Get(t.Context(), "", added[0].ID)
+			if err != nil || e.Name != "alice" || e.count() != 1 {
+				t.Errorf("Get after undo = %+v, %v", e, err)
+			}
+		})
+	}
+}
+
+func TestUndoAddMany(t *testing.T) {
+	by := reporter{ID: "U1"}
+	for name, st := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := st.Add(t.Context(), "", by, addDetails{}, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = st.Add(t.Context(), "", by, addDetails{}, "alice", "alice", "bob", "carol")
+			if err != nil {
+				t.Fatal(err)
+			}
+			last, err := st.Undo(t.Context(), "", by, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"alice", "alice", "bob", "carol"}
+			if !slices.Equal(last.Undone, want) {
+				t.Errorf("undone = %q, want %q", last.Undone, want)
+			}
+			entries, err := st.List(t.Context(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name != "alice" || entries[0].count() != 1 {
+				t.Errorf("entries after undo = %+v, want alice ×1", entries)
+			}
+			_, err = st.Undo(t.Context(), "", by, time.Hour)
+			if err != errNothingToUndo {
+				t.Errorf("second undo err = %v, want errNothingToUndo", err)
+			}
+		})
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	for name, st := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			cancel()
+			_, err := st.Add(ctx, "", reporter{ID: "U1"}, addDetails{}, "alice")
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Add err = %v, want context.Canceled", err)
+			}
+			entries, err := st.List(t.Context(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("entries = %+v, want none", entries)
+			}
+		})
+	}
+}
+
+func TestIDStart(t *testing.T) {
+	db, err := openBolt(filepath.Join(t.TempDir(), "icecream.db"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	added, err := db.Add(t.Context(), "C1", reporter{}, addDetails{}, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added[0].ID != 101 {
+		t.Errorf("first id = %d, want 101", added[0].ID)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).ForEach(func(k, v []byte) error {
+			if bytes.HasPrefix(k, []byte("id-start:")) {
+				t.Errorf("unexpected meta key %q", k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	admin := reporter{ID: "UADMIN"}
+	tests := []struct {
+		name          string
+		replace, move bool
+		want, left    []string
+	}{
+		{"merge", false, false, []string{"bob ×2", "carol", "alice"}, []string{"alice", "bob"}},
+		{"replace", true, false, []string{"alice", "bob"}, []string{"alice", "bob"}},
+		{"move", false, true, []string{"bob ×2", "carol", "alice"}, nil},
+	}
+	for _, tt := range tests {
+		for name, db := range stores(t) {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				_, err := db.Add(t.Context(), "C1", admin, addDetails{}, "alice", "bob")
+				if err == nil {
+					_, err = db.Add(t.Context(), "C2", admin, addDetails{}, "Bob", "carol")
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				n, err := db.Migrate(t.Context(), "C1", "C2", tt.replace, tt.move, admin)
+				if err != nil || n != 2 {
+					t.Fatalf("Migrate = %d, %v, want 2", n, err)
+				}
+				labels := func(key string) []string {
+					entries, err := db.List(t.Context(), key)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var rv []string
+					for _, e := range entries {
+						rv = append(rv, strings.ToLower(e.label()))
+					}
+					return rv
+				}
+				if got := labels("C2"); !slices.Equal(got, tt.want) {
+					t.Errorf("target = %q, want %q", got, tt.want)
+				}
+				if got := labels("C1"); !slices.Equal(got, tt.left) {
+					t.Errorf("source = %q, want %q", got, tt.left)
+				}
+				entries, _ := db.List(t.Context(), "C2")
+				if entries[len(entries)-1].ID != 3 && !tt.replace {
+					t.Errorf("copied entry got id %d, want the target's next id 3", entries[len(entries)-1].ID)
+				}
+			})
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	admin := reporter{ID: "UADMIN"}
+	for name, db := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := db.Add(t.Context(), "", admin, addDetails{}, "alice", "bob", "bob", "carol")
+			if err != nil {
+				t.Fatal(err)
+			}
+			settled, missing, err := db.Settle(t.Context(), "", []uint64{2, 7, 1}, "paid", "", admin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(settled) != 2 || settled[0].label() != "bob ×2" || settled[1].Name != "alice" {
+				t.Errorf("settled %+v, want bob ×2 and alice", settled)
+			}
+			if !slices.Equal(missing, []uint64{7}) {
+				t.Errorf("missing %v, want [7]", missing)
+			}
+			entries, _ := db.List(t.Context(), "")
+			if len(entries) != 1 || entries[0].Name != "carol" {
+				t.Errorf("backlog %+v, want only carol", entries)
+			}
+			records, paid, err := db.Activity(t.Context(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paid) != 3 {
+				t.Errorf("archived %d debts, want 3", len(paid))
+			}
+			for _, e := range paid {
+				if _, ok := timeToPay(e); !ok {
+					t.Errorf("archived %+v has no time to pay", e)
+				}
+			}
+			n := 0
+			for _, r := range records {
+				if r.Action == "paid" && r.By == admin {
+					n++
+				}
+			}
+			if n != 3 {
+				t.Errorf("recorded %d payments by the admin, want 3", n)
+			}
+			settled, _, err = db.Settle(t.Context(), "", nil, "paid", "", admin)
+			if err != nil || len(settled) != 1 {
+				t.Errorf("settling all = %+v, %v, want carol", settled, err)
+			}
+			if entries, _ := db.List(t.Context(), ""); len(entries) != 0 {
+				t.Errorf("backlog %+v after settling all, want empty", entries)
+			}
+		})
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	admin := reporter{ID: "UADMIN"}
+	for name, db := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := db.Add(t.Context(), "", admin, addDetails{}, "alice", "bob")
+			if err == nil {
+				_, err = db.Add(t.Context(), "C1", admin, addDetails{}, "carol")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			cutoff := time.Now()
+			time.Sleep(time.Millisecond)
+			_, err = db.Add(t.Context(), "", admin, addDetails{}, "dave")
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := db.TrimHistory(t.Context(), cutoff)
+			if err != nil || n != 3 {
+				t.Fatalf("TrimHistory = %d, %v, want 3", n, err)
+			}
+			records, err := db.History(t.Context(), "", 10)
+			if err != nil || len(records) != 1 || records[0].Name != "dave" {
+				t.Errorf("history after trim = %+v, %v, want only dave", records, err)
+			}
+			if records, _ := db.History(t.Context(), "C1", 10); len(records) != 0 {
+				t.Errorf("C1 history after trim = %+v, want none", records)
+			}
+			n, err = db.TrimHistory(t.Context(), cutoff)
+			if err != nil || n != 0 {
+				t.Errorf("trimming again = %d, %v, want 0", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	summaryWidth   = 480
+	summaryLine    = 18
+	summaryPadding = 16
+	summaryMaxRows = 25
+)
+
+var (
+	summaryBackground = color.RGBA{0xfd, 0xf6, 0xe3, 0xff}
+	summaryHeading    = color.RGBA{0xd3, 0x36, 0x82, 0xff}
+	summaryText       = color.RGBA{0x58, 0x6e, 0x75, 0xff}
+)
+
+// summaryCache holds the most recently rendered summary image. It is
+// keyed on the backlog and the id of the last committed write transaction
+// so any mutation of the database causes the next request to render
+// afresh.
+type summaryCache struct {
+	mu      sync.Mutex
+	key     string
+	version int
+	png     []byte
+}
+
+func (db *store) Version(ctx context.Context) (int, error) {
+	db = db.with(ctx)
+	var v int
+	err := db.View(func(tx *bolt.Tx) error {
+		v = tx.ID()
+		return nil
+	})
+	return v, err
+}
+
+func (s *server) summaryPNG(ctx context.Context, key string) ([]byte, int, error) {
+	v, err := s.store.Version(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.summary.mu.Lock()
+	defer s.summary.mu.Unlock()
+	if s.summary.png != nil && s.summary.version == v && s.summary.key == key {
+		return s.summary.png, v, nil
+	}
+	entries, err := s.store.List(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	b, err := renderSummary(entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.summary.key = key
+	s.summary.version = v
+	s.summary.png = b
+	return b, v, nil
+}
+
+func renderSummary(entries []entry) ([]byte, error) {
+	lines := make([]string, 0, len(entries))
+	for i, e := range entries {
+		if i == summaryMaxRows {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(entries)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", e.ID, e.label()))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "The backlog is empty. Tread lightly.")
+	}
+	height := 2*summaryPadding + (len(lines)+2)*summaryLine
+	img := image.NewRGBA(image.Rect(0, 0, summaryWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(summaryBackground), image.Point{}, draw.Src)
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(summaryHeading),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(summaryPadding, summaryPadding+summaryLine),
+	}
+	d.DrawString(fmt.Sprintf("Ice cream backlog (%d)", len(entries)))
+	d.Src = image.NewUniform(summaryText)
+	for i, line := range lines {
+		d.Dot = fixed.P(summaryPadding, summaryPadding+(i+3)*summaryLine)
+		d.DrawString(line)
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+func (s *server) handleSummaryImage(w http.ResponseWriter, req *http.Request) {
+	team, channel, _, ok := s.verifyShare(req)
+	if !ok {
+		abort(w, http.StatusForbidden)
+		return
+	}
+	b, v, err := s.summaryPNG(req.Context(), s.backlogKey(team, channel))
+	if err == errShuttingDown {
+		abort(w, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logger(req.Context()).Error("summary image failed", "err", err)
+		abort(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(v)))
+	http.ServeContent(w, req, "summary.png", time.Time{}, bytes.NewReader(b))
+}
+
+func (s *server) summaryCommand(cmd *command) (msg, error) {
+	if !s.summaryImage {
+		return newPrivateMessage("The summary image is not enabled on this server."), nil
+	}
+	v, err := s.store.Version(cmd.ctx)
+	if err != nil {
+		return msg{}, err
+	}
+	var team, channel string
+	if s.multiTeam {
+		team = cmd.teamID
+	}
+	if s.perChannel {
+		channel = cmd.channelID
+	}
+	// The link is signed like a share link so the image can't be fetched
+	// for any backlog by guessing ids. The version query parameter makes
+	// Slack unfurl a fresh image after the backlog changes instead of
+	// reusing its cached preview.
+	q := s.shareQuery(team, channel, defaultShareTTL)
+	q.Set("v", strconv.Itoa(v))
+	return newPublicMessage(s.publicURL + "/summary.png?" + q.Encode()), nil
+}
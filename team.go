@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var teamConfigBucket = []byte("team-config")
+
+// teamConfig holds the settings a workspace chooses for itself, on top of
+// the server's flags.
+type teamConfig struct {
+	Admins []string `json:"admins,omitempty"`
+	Settle string   `json:"settle,omitempty"`
+	// Private lists the channels where every reply is ephemeral.
+	Private []string `json:"private,omitempty"`
+}
+
+func (db *store) TeamConfig(ctx context.Context, team string) (teamConfig, error) {
+	db = db.with(ctx)
+	var c teamConfig
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(teamConfigBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(team))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &c)
+	})
+	return c, err
+}
+
+func (db *store) SaveTeamConfig(ctx context.Context, team string, c teamConfig) error {
+	db = db.with(ctx)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(teamConfigBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(team), b)
+	})
+}
+
+// isAdmin reports whether the user is an admin of the whole server or of
+// the team the command came from.
+func (s *server) isAdmin(cmd *command) bool {
+	if s.admins[cmd.userID] {
+		return true
+	}
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		logger(cmd.ctx).Error("team config lookup failed", "err", err)
+		return false
+	}
+	return slices.Contains(c.Admins, cmd.userID)
+}
+
+// isPrivate reports whether the channel turned public replies off. A
+// failed lookup keeps replies public, as they are by default.
+func (s *server) isPrivate(cmd *command) bool {
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		logger(cmd.ctx).Error("team config lookup failed", "err", err)
+		return false
+	}
+	return slices.Contains(c.Private, cmd.channelID)
+}
+
+// settlerFor returns the team's settle strategy, or the server's when the
+// team hasn't picked one.
+func (s *server) settlerFor(cmd *command) (settler, error) {
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		return nil, err
+	}
+	if settle, ok := settleStrategies[c.Settle]; ok {
+		return settle, nil
+	}
+	return s.settle, nil
+}
+
+// addInstaller makes whoever installed the app an admin of their team,
+// unless the team already has admins.
+func (s *server) addInstaller(ctx context.Context, team, user string) {
+	c, err := s.store.TeamConfig(ctx, team)
+	if err == nil && len(c.Admins) == 0 && user != "" {
+		c.Admins = []string{user}
+		err = s.store.SaveTeamConfig(ctx, team, c)
+	}
+	if err != nil {
+		slog.Error("saving team admins failed", "team", team, "err", err)
+	}
+}
+
+func (s *server) configAdmins(cmd *command, args []string) (msg, error) {
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		return msg{}, err
+	}
+	if len(args) == 0 {
+		if len(c.Admins) == 0 {
+			return newPrivateMessage("This team has no admins of its own."), nil
+		}
+		mentions := make([]string, len(c.Admins))
+		for i, id := range c.Admins {
+			mentions[i] = "<@" + id + ">"
+		}
+		return newPrivateMessage("Team admins: " + englishList(mentions) + "."), nil
+	}
+	if len(args) != 2 || (args[0] != "add" && args[0] != "remove") {
+		return msg{}, errUsage
+	}
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	_, id := parseMention(args[1])
+	if id == "" {
+		return msg{}, usageErrorf("`%s` isn't a user mention.", args[1])
+	}
+	if args[0] == "add" {
+		if !slices.Contains(c.Admins, id) {
+			c.Admins = append(c.Admins, id)
+			sort.Strings(c.Admins)
+		}
+	} else {
+		c.Admins = slices.DeleteFunc(c.Admins, func(a string) bool { return a == id })
+	}
+	err = s.store.SaveTeamConfig(cmd.ctx, cmd.teamID, c)
+	if err != nil {
+		return msg{}, err
+	}
+	if args[0] == "add" {
+		return newPublicMessage(fmt.Sprintf("<@%s> is now a team admin.", id)), nil
+	}
+	return newPublicMessage(fmt.Sprintf("<@%s> is no longer a team admin.", id)), nil
+}
+
+func (s *server) configPublic(cmd *command, args []string) (msg, error) {
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		return msg{}, err
+	}
+	if len(args) == 0 {
+		if slices.Contains(c.Private, cmd.channelID) {
+			return newPrivateMessage("Replies in this channel are only shown to whoever ran the command."), nil
+		}
+		return newPrivateMessage("Replies in this channel are public."), nil
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return msg{}, errUsage
+	}
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	c.Private = slices.DeleteFunc(c.Private, func(id string) bool { return id == cmd.channelID })
+	if args[0] == "off" {
+		c.Private = append(c.Private, cmd.channelID)
+		sort.Strings(c.Private)
+	}
+	err = s.store.SaveTeamConfig(cmd.ctx, cmd.teamID, c)
+	if err != nil {
+		return msg{}, err
+	}
+	if args[0] == "off" {
+		return newPrivateMessage("Replies in this channel are now only shown to whoever ran the command."), nil
+	}
+	return newPublicMessage("Replies in this channel are public again."), nil
+}
+
+func (s *server) configSettle(cmd *command, args []string) (msg, error) {
+	if len(args) == 0 {
+		c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+		if err != nil {
+			return msg{}, err
+		}
+		if c.Settle == "" {
+			return newPrivateMessage("Settle-up rounds use the server's strategy."), nil
+		}
+		return newPrivateMessage(fmt.Sprintf("Settle-up rounds use the %s strategy.", c.Settle)), nil
+	}
+	if len(args) != 1 {
+		return msg{}, errUsage
+	}
+	if !s.isAdmin(cmd) {
+		return newPrivateMessage("Only admins can do that."), nil
+	}
+	name := args[0]
+	if name == "default" {
+		name = ""
+	} else if _, ok := settleStrategies[name]; !ok {
+		names := make([]string, 0, len(settleStrategies))
+		for n := range settleStrategies {
+			names = append(names, "`"+n+"`")
+		}
+		sort.Strings(names)
+		return msg{}, usageErrorf("`%s` isn't a strategy, pick one of %s.", name, strings.Join(names, ", "))
+	}
+	c, err := s.store.TeamConfig(cmd.ctx, cmd.teamID)
+	if err != nil {
+		return msg{}, err
+	}
+	c.Settle = name
+	err = s.store.SaveTeamConfig(cmd.ctx, cmd.teamID, c)
+	if err != nil {
+		return msg{}, err
+	}
+	if name == "" {
+		return newPublicMessage("Settle-up rounds are back to the server's strategy."), nil
+	}
+	return newPublicMessage(fmt.Sprintf("Settle-up rounds now use the %s strategy.", name)), nil
+}
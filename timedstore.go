@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// timedStore times every call to the store it wraps for /metrics, so
+// slowness in storage can be told apart from slowness in the commands.
+type timedStore struct {
+	Store
+	metrics *metrics
+}
+
+var _ Store = (*timedStore)(nil)
+
+// observe records a call that started at start. Missing entries and
+// nothing to undo are answers rather than failures.
+func (ts *timedStore) observe(ctx context.Context, op string, start time.Time, err error) {
+	failed := err != nil && err != errNotFound && err != errNothingToUndo
+	ts.metrics.observeStore(commandName(ctx), op, time.Since(start), failed)
+}
+
+func (ts *timedStore) Add(ctx context.Context, key string, by reporter, d addDetails, names ...string) ([]entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Add(ctx, key, by, d, names...)
+	ts.observe(ctx, "Add", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Del(ctx context.Context, key string, id uint64, by reporter) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Del(ctx, key, id, by)
+	ts.observe(ctx, "Del", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Get(ctx context.Context, key string, id uint64) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Get(ctx, key, id)
+	ts.observe(ctx, "Get", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Modify(ctx context.Context, key string, id uint64, fn func(e *entry) error) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Modify(ctx, key, id, fn)
+	ts.observe(ctx, "Modify", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Pin(ctx context.Context, key string, id uint64, pinned bool) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Pin(ctx, key, id, pinned)
+	ts.observe(ctx, "Pin", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Spotlight(ctx context.Context, key string, id uint64, until time.Time) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Spotlight(ctx, key, id, until)
+	ts.observe(ctx, "Spotlight", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) LogEvent(ctx context.Context, key string, id uint64, action string) error {
+	start := time.Now()
+	err := ts.Store.LogEvent(ctx, key, id, action)
+	ts.observe(ctx, "LogEvent", start, err)
+	return err
+}
+
+func (ts *timedStore) List(ctx context.Context, key string) ([]entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.List(ctx, key)
+	ts.observe(ctx, "List", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Pay(ctx context.Context, key string, id uint64, name string, by reporter) (entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Pay(ctx, key, id, name, by)
+	ts.observe(ctx, "Pay", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Settle(ctx context.Context, key string, ids []uint64, action, note string, by reporter) ([]entry, []uint64, error) {
+	start := time.Now()
+	settled, missing, err := ts.Store.Settle(ctx, key, ids, action, note, by)
+	ts.observe(ctx, "Settle", start, err)
+	return settled, missing, err
+}
+
+func (ts *timedStore) History(ctx context.Context, key string, n int) ([]historyRecord, error) {
+	start := time.Now()
+	rv, err := ts.Store.History(ctx, key, n)
+	ts.observe(ctx, "History", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Activity(ctx context.Context, key string) ([]historyRecord, []entry, error) {
+	start := time.Now()
+	records, paid, err := ts.Store.Activity(ctx, key)
+	ts.observe(ctx, "Activity", start, err)
+	return records, paid, err
+}
+
+func (ts *timedStore) TrimHistory(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	rv, err := ts.Store.TrimHistory(ctx, before)
+	ts.observe(ctx, "TrimHistory", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Undo(ctx context.Context, key string, by reporter, window time.Duration) (lastChange, error) {
+	start := time.Now()
+	rv, err := ts.Store.Undo(ctx, key, by, window)
+	ts.observe(ctx, "Undo", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Clear(ctx context.Context, key string, by reporter) ([]entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Clear(ctx, key, by)
+	ts.observe(ctx, "Clear", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Migrate(ctx context.Context, from, to string, replace, move bool, by reporter) (int, error) {
+	start := time.Now()
+	rv, err := ts.Store.Migrate(ctx, from, to, replace, move, by)
+	ts.observe(ctx, "Migrate", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Schedule(ctx context.Context, key, name string, at time.Time, by reporter) (uint64, error) {
+	start := time.Now()
+	rv, err := ts.Store.Schedule(ctx, key, name, at, by)
+	ts.observe(ctx, "Schedule", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Scheduled(ctx context.Context, key string) ([]scheduledAdd, error) {
+	start := time.Now()
+	rv, err := ts.Store.Scheduled(ctx, key)
+	ts.observe(ctx, "Scheduled", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Promote(ctx context.Context, now time.Time) ([]entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.Promote(ctx, now)
+	ts.observe(ctx, "Promote", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) ClearSpotlights(ctx context.Context, now time.Time) ([]entry, error) {
+	start := time.Now()
+	rv, err := ts.Store.ClearSpotlights(ctx, now)
+	ts.observe(ctx, "ClearSpotlights", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) QuietUntil(ctx context.Context, team, channel string) (time.Time, error) {
+	start := time.Now()
+	rv, err := ts.Store.QuietUntil(ctx, team, channel)
+	ts.observe(ctx, "QuietUntil", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) SetQuiet(ctx context.Context, team, channel string, until time.Time) error {
+	start := time.Now()
+	err := ts.Store.SetQuiet(ctx, team, channel, until)
+	ts.observe(ctx, "SetQuiet", start, err)
+	return err
+}
+
+func (ts *timedStore) Team(ctx context.Context, id string) (teamInstall, error) {
+	start := time.Now()
+	rv, err := ts.Store.Team(ctx, id)
+	ts.observe(ctx, "Team", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) SaveTeam(ctx context.Context, id string, t teamInstall) error {
+	start := time.Now()
+	err := ts.Store.SaveTeam(ctx, id, t)
+	ts.observe(ctx, "SaveTeam", start, err)
+	return err
+}
+
+func (ts *timedStore) TeamConfig(ctx context.Context, team string) (teamConfig, error) {
+	start := time.Now()
+	rv, err := ts.Store.TeamConfig(ctx, team)
+	ts.observe(ctx, "TeamConfig", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) SaveTeamConfig(ctx context.Context, team string, c teamConfig) error {
+	start := time.Now()
+	err := ts.Store.SaveTeamConfig(ctx, team, c)
+	ts.observe(ctx, "SaveTeamConfig", start, err)
+	return err
+}
+
+func (ts *timedStore) Digests(ctx context.Context) ([]digest, error) {
+	start := time.Now()
+	rv, err := ts.Store.Digests(ctx)
+	ts.observe(ctx, "Digests", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) SaveDigest(ctx context.Context, d digest) error {
+	start := time.Now()
+	err := ts.Store.SaveDigest(ctx, d)
+	ts.observe(ctx, "SaveDigest", start, err)
+	return err
+}
+
+func (ts *timedStore) DeleteDigest(ctx context.Context, team, channel string) error {
+	start := time.Now()
+	err := ts.Store.DeleteDigest(ctx, team, channel)
+	ts.observe(ctx, "DeleteDigest", start, err)
+	return err
+}
+
+func (ts *timedStore) NotifyOptOut(ctx context.Context, team, user string) (bool, error) {
+	start := time.Now()
+	rv, err := ts.Store.NotifyOptOut(ctx, team, user)
+	ts.observe(ctx, "NotifyOptOut", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) SetNotifyOptOut(ctx context.Context, team, user string, out bool) error {
+	start := time.Now()
+	err := ts.Store.SetNotifyOptOut(ctx, team, user, out)
+	ts.observe(ctx, "SetNotifyOptOut", start, err)
+	return err
+}
+
+func (ts *timedStore) Export(ctx context.Context) (snapshot, error) {
+	start := time.Now()
+	rv, err := ts.Store.Export(ctx)
+	ts.observe(ctx, "Export", start, err)
+	return rv, err
+}
+
+func (ts *timedStore) Import(ctx context.Context, data snapshot) error {
+	start := time.Now()
+	err := ts.Store.Import(ctx, data)
+	ts.observe(ctx, "Import", start, err)
+	return err
+}
+
+func (ts *timedStore) Version(ctx context.Context) (int, error) {
+	start := time.Now()
+	rv, err := ts.Store.Version(ctx)
+	ts.observe(ctx, "Version", start, err)
+	return rv, err
+}
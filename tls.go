@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertConfig gets and renews certificates for the hosts from Let's
+// Encrypt, answering its challenges over TLS-ALPN on the same listener.
+func autocertConfig(hosts, cacheDir string) *tls.Config {
+	var names []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(names...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+	return m.TLSConfig()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var errNothingToUndo = errors.New("nothing to undo")
+
+// lastChange is a user's most recent add or delete on a backlog, kept so
+// it can be undone. An add of several names or with a count is one change
+// holding every id it counted up, once per count.
+type lastChange struct {
+	Action  string    `json:"action"`
+	EntryID uint64    `json:"entry_id"`
+	Entry   entry     `json:"entry"`
+	IDs     []uint64  `json:"ids,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// Undone holds the name of each add taken back by an undo.
+	Undone []string `json:"-"`
+}
+
+// addedChange is the change made by an add that returned added.
+func addedChange(added []entry, t time.Time) lastChange {
+	c := lastChange{Action: "added", Time: t}
+	for _, e := range added {
+		c.IDs = append(c.IDs, e.ID)
+		c.EntryID, c.Entry = e.ID, e
+	}
+	return c
+}
+
+func (db backlog) undoKey(userID string) []byte {
+	return []byte("undo:" + string(db.name) + ":" + userID)
+}
+
+// remember records c as by's most recent change within the caller's
+// transaction. For a delete c.Entry is the entry as it was before, so it
+// can be restored.
+func (db backlog) remember(tx *bolt.Tx, by reporter, c lastChange) error {
+	if by.ID == "" || len(c.IDs) == 0 && c.Action == "added" {
+		return nil
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return meta.Put(db.undoKey(by.ID), b)
+}
+
+// undo reverses by's most recent add or delete if it happened within the
+// window. An add takes one off the count of every entry it counted up, a
+// delete puts one back. When the deleted entry is gone it is restored,
+// unless someone has since been added under the same name, who gets the
+// one back instead.
+func (db backlog) undo(by reporter, window time.Duration) (lastChange, error) {
+	var last lastChange
+	err := db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if meta == nil {
+			return errNothingToUndo
+		}
+		key := db.undoKey(by.ID)
+		v := meta.Get(key)
+		if v == nil {
+			return errNothingToUndo
+		}
+		err := json.Unmarshal(v, &last)
+		if err != nil {
+			return err
+		}
+		last.Entry.ID = last.EntryID
+		now := time.Now()
+		if now.Sub(last.Time) > window {
+			return errNothingToUndo
+		}
+		bucket, err := db.createBucket(tx)
+		if err != nil {
+			return err
+		}
+		action := "undid " + undoVerb(last.Action)
+		put := func(e entry) error {
+			err := db.record(tx, action, e, now, by)
+			if err != nil {
+				return err
+			}
+			if e.Count == 0 {
+				return bucket.Delete(itob(e.ID))
+			}
+			e.logBy(action, now, by)
+			return putEntry(bucket, e)
+		}
+		if last.Action == "added" {
+			for _, id := range last.IDs {
+				v := bucket.Get(itob(id))
+				if v == nil {
+					continue
+				}
+				e, err := decodeEntry(itob(id), v)
+				if err != nil {
+					return err
+				}
+				e.Count = e.count() - 1
+				err = put(e)
+				if err != nil {
+					return err
+				}
+				last.Undone = append(last.Undone, e.Name)
+			}
+			if len(last.Undone) == 0 {
+				return errNothingToUndo
+			}
+			return meta.Delete(key)
+		}
+		var e entry
+		switch v := bucket.Get(itob(last.EntryID)); {
+		case v == nil:
+			var found bool
+			e, found, err = findEntry(bucket, last.Entry.Name)
+			if err != nil {
+				return err
+			}
+			if found {
+				e.Count = e.count() + 1
+			} else {
+				e = last.Entry
+				e.Count = 1
+			}
+		default:
+			e, err = decodeEntry(itob(last.EntryID), v)
+			if err != nil {
+				return err
+			}
+			e.Count = e.count() + 1
+		}
+		last.EntryID = e.ID
+		err = meta.Delete(key)
+		if err != nil {
+			return err
+		}
+		return put(e)
+	})
+	return last, err
+}
+
+func undoVerb(action string) string {
+	if action == "deleted" {
+		return "delete"
+	}
+	return "add"
+}
+
+func (s *server) undo(cmd *command) (msg, error) {
+	last, err := s.store.Undo(cmd.ctx, s.backlogKey(cmd.teamID, cmd.channelID), cmd.reporter(), s.undoWindow)
+	if err == errNothingToUndo {
+		text := fmt.Sprintf("You have no add or delete from the last %s to undo.", humanize(s.undoWindow))
+		return newPrivateMessage(text), nil
+	}
+	if err != nil {
+		return msg{}, err
+	}
+	text := fmt.Sprintf("↩️ Undid the %s of %s (%d).", undoVerb(last.Action), last.Entry.Name, last.EntryID)
+	if len(last.Undone) > 1 {
+		var names []string
+		for _, name := range last.Undone {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		text = fmt.Sprintf("↩️ Undid the %d adds of %s.", len(last.Undone), englishList(names))
+	}
+	return newPublicMessage(text), nil
+}
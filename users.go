@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	Profile struct {
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	} `json:"profile"`
+}
+
+func (c *slackClient) lookupByEmail(ctx context.Context, email string) (slackUser, error) {
+	var r struct {
+		User slackUser `json:"user"`
+	}
+	err := c.call(ctx, "users.lookupByEmail", url.Values{"email": {email}}, &r)
+	return r.User, err
+}
+
+// listUsers pages through users.list.
+func (c *slackClient) listUsers(ctx context.Context) ([]slackUser, error) {
+	var users []slackUser
+	params := url.Values{"limit": {"200"}}
+	for {
+		var r struct {
+			Members  []slackUser `json:"members"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		err := c.call(ctx, "users.list", params, &r)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, r.Members...)
+		if r.Metadata.NextCursor == "" {
+			return users, nil
+		}
+		params.Set("cursor", r.Metadata.NextCursor)
+	}
+}
+
+// userCacheTTL is how long a team's user list is used before it is
+// fetched again, so people who joined or were renamed since are found.
+const userCacheTTL = 15 * time.Minute
+
+// userCache keeps each team's active users by lowercased username and
+// display name, so resolving a handle doesn't page through users.list on
+// every add.
+type userCache struct {
+	mu    sync.Mutex
+	teams map[string]*teamUsers
+}
+
+type teamUsers struct {
+	mu      sync.Mutex
+	byName  map[string]slackUser
+	fetched time.Time
+}
+
+// find looks for an active user whose username or display name is name,
+// a username winning over someone else's display name. Lookups for other
+// teams aren't held up while one team's list is fetched.
+func (c *userCache) find(ctx context.Context, client *slackClient, team, name string) (slackUser, bool, error) {
+	c.mu.Lock()
+	if c.teams == nil {
+		c.teams = make(map[string]*teamUsers)
+	}
+	t, ok := c.teams[team]
+	if !ok {
+		t = &teamUsers{}
+		c.teams[team] = t
+	}
+	c.mu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.fetched) > userCacheTTL {
+		users, err := client.listUsers(ctx)
+		if err != nil {
+			return slackUser{}, false, err
+		}
+		t.byName = make(map[string]slackUser, 2*len(users))
+		for _, u := range users {
+			if !u.Deleted && u.Profile.DisplayName != "" {
+				t.byName[strings.ToLower(u.Profile.DisplayName)] = u
+			}
+		}
+		for _, u := range users {
+			if !u.Deleted {
+				t.byName[strings.ToLower(u.Name)] = u
+			}
+		}
+		t.fetched = time.Now()
+	}
+	u, ok := t.byName[strings.ToLower(name)]
+	return u, ok, nil
+}
+
+// resolveUser turns a plain @username or email address into a mention of
+// the Slack user so the entry follows renames and links to the person.
+// Anything it can't resolve is returned as given.
+func (s *server) resolveUser(ctx context.Context, team, name string) (string, string) {
+	handle, isHandle := strings.CutPrefix(name, "@")
+	isEmail := !isHandle && strings.Contains(name, "@") && !strings.ContainsAny(name, " <>")
+	if !isHandle && !isEmail || s.reserved[strings.ToLower(name)] {
+		return name, ""
+	}
+	client, err := s.slackFor(ctx, team)
+	if err != nil {
+		return name, ""
+	}
+	var u slackUser
+	found := true
+	if isEmail {
+		u, err = client.lookupByEmail(ctx, name)
+		if err != nil && strings.HasSuffix(err.Error(), "users_not_found") {
+			found, err = false, nil
+		}
+	} else {
+		u, found, err = s.users.find(ctx, client, team, handle)
+	}
+	if err != nil {
+		logger(ctx).Warn("user lookup failed", "name", name, "err", err)
+		return name, ""
+	}
+	if !found {
+		return name, ""
+	}
+	return "<@" + u.ID + ">", u.ID
+}
+
+// parseUser parses the name of a command argument, resolving it to a user
+// mention where possible.
+func (s *server) parseUser(ctx context.Context, team, text string) (name, userID string) {
+	name, userID = parseMention(text)
+	if userID == "" && name != "" {
+		name, userID = s.resolveUser(ctx, team, name)
+	}
+	return name, userID
+}